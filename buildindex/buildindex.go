@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"github.com/rootsdev/fsbff/fs_index"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+/*
+Builds an index of a file of key/value lines, like the source references file of person ids and
+source ids separated by commas, so that fsxml2protobuf can look up the sources of each person
+instead of reading the whole file into memory. The index is verified after it is built;
+with -verify and no input file, an existing index is verified instead.
+*/
+
+// buildIndex reads lines of key, delimiter and value and writes an index of them.
+// Lines without the delimiter are counted and skipped.
+func buildIndex(r io.Reader, filename, delimiter, tmpDir string, sortLines int) (pairs, badLines int, err error) {
+	b := fs_index.NewBuilder(tmpDir, sortLines)
+	defer b.Close()
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		fields := strings.SplitN(scanner.Text(), delimiter, 2)
+		if len(fields) < 2 || strings.Contains(fields[0], "\t") {
+			badLines++
+			continue
+		}
+		if err = b.Add(fields[0], fields[1]); err != nil {
+			return pairs, badLines, err
+		}
+		pairs++
+	}
+	if err = scanner.Err(); err != nil {
+		return pairs, badLines, err
+	}
+	return pairs, badLines, b.Write(filename)
+}
+
+func verifyIndex(filename string) (int64, error) {
+	index, err := fs_index.Open(filename)
+	if err != nil {
+		return 0, err
+	}
+	defer index.Close()
+	return index.Len(), index.Verify()
+}
+
+func check(err error) {
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+var inFilename = flag.String("i", "", "input filename of key/value lines, like the source references file")
+var outFilename = flag.String("o", "", "index filename")
+var delimiter = flag.String("d", ",", "delimiter between key and value")
+var tmpDir = flag.String("t", "", "temporary directory for sorting")
+var sortLines = flag.Int("sortlines", 5000000, "number of lines sorted in memory at a time")
+var verifyOnly = flag.Bool("verify", false, "verify the index instead of building it")
+
+func main() {
+	flag.Parse()
+
+	if !*verifyOnly {
+		fmt.Println("Building index")
+		in, err := os.Open(*inFilename)
+		check(err)
+		dir := *tmpDir
+		if dir == "" {
+			dir = filepath.Dir(*outFilename)
+		}
+		pairs, badLines, err := buildIndex(in, *outFilename, *delimiter, dir, *sortLines)
+		in.Close()
+		check(err)
+		fmt.Printf("Indexed pairs=%d skipped lines=%d\n", pairs, badLines)
+	}
+
+	fmt.Println("Verifying index")
+	pairs, err := verifyIndex(*outFilename)
+	check(err)
+	fmt.Printf("Total pairs=%d\n", pairs)
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBuildIndex(t *testing.T) {
+	dir, err := ioutil.TempDir("", "buildindex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "sources.index")
+	in := "P2,S1\nP1,S2\nbad line\nP1,S1,extra\nP2\tX,S3\n"
+	pairs, badLines, err := buildIndex(strings.NewReader(in), filename, ",", dir, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pairs != 3 || badLines != 2 {
+		t.Errorf("buildIndex = %d pairs %d bad lines; want 3 pairs 2 bad lines", pairs, badLines)
+	}
+	n, err := verifyIndex(filename)
+	if err != nil || n != 3 {
+		t.Errorf("verifyIndex = %d err %v; want 3", n, err)
+	}
+	if _, err = verifyIndex(filepath.Join(dir, "missing")); err == nil {
+		t.Errorf("verifyIndex(missing) returned no error")
+	}
+}
@@ -2,18 +2,15 @@ package main
 
 import (
 	"bufio"
-	"code.google.com/p/goprotobuf/proto"
-	"compress/gzip"
+	"context"
 	"flag"
 	"fmt"
 	"github.com/rootsdev/fsbff/fs_data"
-	"io"
-	"io/ioutil"
+	"github.com/rootsdev/fsbff/fs_parallel"
+	"github.com/rootsdev/fsbff/fs_reader"
 	"log"
-	"math"
 	"os"
-	"runtime"
-	"strings"
+	"sort"
 )
 
 func check(err error) {
@@ -22,30 +19,37 @@ func check(err error) {
 	}
 }
 
-func processFile(filename string) map[string]int {
-	var file io.ReadCloser
-	var err error
-	eventTypes := make(map[string]int)
-
-	file, err = os.Open(filename)
-	check(err)
-	defer file.Close()
-
-	if strings.HasSuffix(filename, ".gz") {
-		file, err = gzip.NewReader(file)
-		check(err)
-		defer file.Close()
+// sortedTypes returns the types of the vocabulary and any other counted types, most frequent first,
+// so that runs over the same data write the same lines in the same order
+func sortedTypes(counts map[string]int) []string {
+	types := make([]string, 0, len(fs_data.FactTypes)+len(counts))
+	types = append(types, fs_data.FactTypes...)
+	for t := range counts {
+		if !fs_data.IsFactType(t) {
+			types = append(types, t)
+		}
 	}
+	sort.Slice(types, func(i, j int) bool {
+		if counts[types[i]] != counts[types[j]] {
+			return counts[types[i]] > counts[types[j]]
+		}
+		return types[i] < types[j]
+	})
+	return types
+}
 
-	bytes, err := ioutil.ReadAll(file)
-	check(err)
+func processFile(filename string) (map[string]int, error) {
+	eventTypes := make(map[string]int)
 
-	fsPersons := &fs_data.FamilySearchPersons{}
-	err = proto.Unmarshal(bytes, fsPersons)
-	check(err)
+	source, err := fs_reader.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer source.Close()
 
-	for _, person := range fsPersons.Persons {
-		for _, fact := range person.Facts {
+	for source.Next() {
+		person := source.Person()
+		for _, fact := range person.GetAllFacts() {
 			factType := "nil"
 			if fact.Type != nil {
 				factType = *fact.Type
@@ -54,34 +58,7 @@ func processFile(filename string) map[string]int {
 		}
 	}
 
-	return eventTypes
-}
-
-func processFiles(fileNames chan string, results chan map[string]int) {
-	for fileName := range fileNames {
-		results <- processFile(fileName)
-	}
-}
-
-func getFilenames(filename string) (int, chan string) {
-	numFiles := 0
-	fileNames := make(chan string, 100000)
-	fileInfo, err := os.Stat(filename)
-	check(err)
-	if fileInfo.IsDir() {
-		fileInfos, err := ioutil.ReadDir(filename)
-		check(err)
-		for _, fileInfo := range fileInfos {
-			fileNames <- filename + "/" + fileInfo.Name()
-			numFiles++
-		}
-	} else {
-		fileNames <- filename
-		numFiles++
-	}
-	close(fileNames)
-
-	return numFiles, fileNames
+	return eventTypes, source.Err()
 }
 
 var inFilename = flag.String("i", "", "input filename or directory")
@@ -91,38 +68,34 @@ var numWorkers = flag.Int("w", 1, "number of workers)")
 func main() {
 	flag.Parse()
 
-	numCPU := runtime.NumCPU()
-	fmt.Printf("Number of CPUs=%d\n", numCPU)
-	runtime.GOMAXPROCS(int(math.Min(float64(numCPU), float64(*numWorkers))))
+	fmt.Printf("Number of CPUs=%d\n", fs_parallel.SetMaxProcs(*numWorkers))
 
-	numFiles, fileNames := getFilenames(*inFilename)
+	fileNames, err := fs_reader.Filenames(*inFilename)
+	check(err)
 
 	fmt.Print("Processing files")
-	results := make(chan map[string]int)
-
-	for i := 0; i < *numWorkers; i++ {
-		go processFiles(fileNames, results)
-	}
-
 	totalCounts := make(map[string]int)
 
-	for i := 0; i < numFiles; i++ {
-		eventTypes := <-results
-		for k, v := range eventTypes {
-			totalCounts[k] = totalCounts[k] + v
-		}
-		if i%100 == 0 {
-			fmt.Print(".")
-		}
-	}
+	err = fs_parallel.Run(context.Background(), fileNames,
+		fs_parallel.Options{Workers: *numWorkers, Progress: fs_parallel.Dots(100)},
+		func(ctx context.Context, fileName string) (interface{}, error) {
+			return processFile(fileName)
+		},
+		func(fileName string, result interface{}) error {
+			for k, v := range result.(map[string]int) {
+				totalCounts[k] = totalCounts[k] + v
+			}
+			return nil
+		})
+	check(err)
 
 	out, err := os.Create(*outFilename)
 	check(err)
 	defer out.Close()
 	buf := bufio.NewWriter(out)
 
-	for k, v := range totalCounts {
-		buf.WriteString(fmt.Sprintf("%09d,%s\n", v, k))
+	for _, t := range sortedTypes(totalCounts) {
+		buf.WriteString(fmt.Sprintf("%09d,%s\n", totalCounts[t], t))
 	}
 	buf.Flush()
 	out.Sync()
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"github.com/rootsdev/fsbff/fs_data"
+	"strings"
+	"testing"
+)
+
+func TestSortedTypes(t *testing.T) {
+	counts := map[string]int{"Birth": 5, "Death": 5, "Marriage": 7, "nil": 1, "Custom": 1}
+	types := sortedTypes(counts)
+	if len(types) != len(fs_data.FactTypes)+2 {
+		t.Errorf("sortedTypes returned %d types; want %d", len(types), len(fs_data.FactTypes)+2)
+	}
+	if first := strings.Join(types[:5], ","); first != "Marriage,Birth,Death,Custom,nil" {
+		t.Errorf("sortedTypes = %s...; want Marriage,Birth,Death,Custom,nil...", first)
+	}
+	if types[5] != "Adoption" {
+		t.Errorf("sortedTypes[5] = %s; want Adoption", types[5])
+	}
+}
@@ -2,18 +2,13 @@ package main
 
 import (
 	"bufio"
-	"code.google.com/p/goprotobuf/proto"
-	"compress/gzip"
+	"context"
 	"flag"
 	"fmt"
-	"github.com/rootsdev/fsbff/fs_data"
-	"io"
-	"io/ioutil"
+	"github.com/rootsdev/fsbff/fs_parallel"
+	"github.com/rootsdev/fsbff/fs_reader"
 	"log"
-	"math"
 	"os"
-	"runtime"
-	"strings"
 )
 
 func check(err error) {
@@ -22,64 +17,25 @@ func check(err error) {
 	}
 }
 
-func processFile(filename string, personIds map[string]bool) []string {
-	var file io.ReadCloser
-	var err error
+func processFile(filename string, personIds map[string]bool) ([]string, error) {
 	ids := make([]string, 0, 1000)
 
-	file, err = os.Open(filename)
-	check(err)
-	defer file.Close()
-
-	if strings.HasSuffix(filename, ".gz") {
-		file, err = gzip.NewReader(file)
-		check(err)
-		defer file.Close()
+	source, err := fs_reader.Open(filename)
+	if err != nil {
+		return nil, err
 	}
+	defer source.Close()
 
-	bytes, err := ioutil.ReadAll(file)
-	check(err)
-
-	fsPersons := &fs_data.FamilySearchPersons{}
-	err = proto.Unmarshal(bytes, fsPersons)
-	check(err)
-
-	for _, person := range fsPersons.Persons {
+	for source.Next() {
+		person := source.Person()
 		if personIds == nil || personIds[*person.Id] {
-			for _, source := range person.Sources {
-				ids = append(ids, *source.SourceId)
+			for _, fsSource := range person.Sources {
+				ids = append(ids, *fsSource.SourceId)
 			}
 		}  
 	}
 
-	return ids
-}
-
-func processFiles(fileNames chan string, personIds map[string]bool, results chan []string) {
-	for fileName := range fileNames {
-		results <- processFile(fileName, personIds)
-	}
-}
-
-func getFilenames(filename string) (int, chan string) {
-	numFiles := 0
-	fileNames := make(chan string, 100000)
-	fileInfo, err := os.Stat(filename)
-	check(err)
-	if fileInfo.IsDir() {
-		fileInfos, err := ioutil.ReadDir(filename)
-		check(err)
-		for _, fileInfo := range fileInfos {
-			fileNames <- filename + "/" + fileInfo.Name()
-			numFiles++
-		}
-	} else {
-		fileNames <- filename
-		numFiles++
-	}
-	close(fileNames)
-
-	return numFiles, fileNames
+	return ids, source.Err()
 }
 
 var inFilename = flag.String("i", "", "input filename or directory")
@@ -106,40 +62,35 @@ func readPersonIds(filename string) map[string]bool {
 func main() {
 	flag.Parse()
 
-	numCPU := runtime.NumCPU()
-	fmt.Printf("Number of CPUs=%d\n", numCPU)
-	runtime.GOMAXPROCS(int(math.Min(float64(numCPU), float64(*numWorkers))))
+	fmt.Printf("Number of CPUs=%d\n", fs_parallel.SetMaxProcs(*numWorkers))
 
 	var personIds map[string]bool
 	if personIdsFilename != nil {
 		personIds = readPersonIds(*personIdsFilename)
 	}
 
-	numFiles, fileNames := getFilenames(*inFilename)
-
-	fmt.Print("Processing files")
-	results := make(chan []string)
-
-	var i int
-	for i = 0; i < *numWorkers; i++ {
-		go processFiles(fileNames, personIds, results)
-	}
+	fileNames, err := fs_reader.Filenames(*inFilename)
+	check(err)
 
 	out, err := os.Create(*outFilename)
 	check(err)
 	defer out.Close()
 	buf := bufio.NewWriter(out)
 
-	for i = 0; i < numFiles; i++ {
-		ids := <-results
-		for _, id := range ids {
-			buf.WriteString(id)
-			buf.WriteString("\n")
-		}
-		if i%100 == 0 {
-			fmt.Print(".")
-		}
-	}
+	fmt.Print("Processing files")
+	err = fs_parallel.Run(context.Background(), fileNames,
+		fs_parallel.Options{Workers: *numWorkers, Progress: fs_parallel.Dots(100)},
+		func(ctx context.Context, fileName string) (interface{}, error) {
+			return processFile(fileName, personIds)
+		},
+		func(fileName string, result interface{}) error {
+			for _, id := range result.([]string) {
+				buf.WriteString(id)
+				buf.WriteString("\n")
+			}
+			return nil
+		})
+	check(err)
 
 	buf.Flush()
 	out.Sync()
@@ -4,13 +4,13 @@ import (
 	"bufio"
 	"flag"
 	"fmt"
+	"github.com/rootsdev/fsbff/fs_parallel"
 	"log"
-	"math"
 	"os"
-	"runtime"
 	"net/http"
 	"encoding/json"
 	"io/ioutil"
+	"strings"
 	"time"
 )
 
@@ -34,6 +34,9 @@ type Value struct {
 	Value string `json:"value"`
 }
 
+// cleanField replaces the characters that would break an output line
+var cleanField = strings.NewReplacer("|", " ", "\n", " ", "\r", " ").Replace
+
 func fetch(client *http.Client, req *http.Request) ([]byte, error) {
 	res, err := client.Do(req)
 	if err != nil {
@@ -86,7 +89,11 @@ func fetchSources(accessToken string, sourceIds chan string, results chan string
 		if len(sourceDescription.Titles) > 0 {
 			title = sourceDescription.Titles[0].Value
 		}
-		results <- sourceId + "|" + sourceDescription.About + "|" + title
+		citation := ""
+		if len(sourceDescription.Citations) > 0 {
+			citation = sourceDescription.Citations[0].Value
+		}
+		results <- sourceId + "|" + cleanField(sourceDescription.About) + "|" + cleanField(title) + "|" + cleanField(citation)
 	}
 	results <- "__FINISHED__"
 }
@@ -113,9 +120,7 @@ var numWorkers = flag.Int("w", 1, "number of workers)")
 func main() {
 	flag.Parse()
 
-	numCPU := runtime.NumCPU()
-	fmt.Printf("Number of CPUs=%d\n", numCPU)
-	runtime.GOMAXPROCS(int(math.Min(float64(numCPU), float64(*numWorkers))))
+	fmt.Printf("Number of CPUs=%d\n", fs_parallel.SetMaxProcs(*numWorkers))
 
 	file, err := os.Open(*inFilename)
 	check(err)
@@ -2,20 +2,35 @@ package main
 
 import (
 	"bufio"
-	"code.google.com/p/goprotobuf/proto"
 	"compress/gzip"
+	"context"
 	"flag"
 	"fmt"
 	"github.com/rootsdev/fsbff/fs_data"
+	"github.com/rootsdev/fsbff/fs_parallel"
+	"github.com/rootsdev/fsbff/fs_query"
+	"github.com/rootsdev/fsbff/fs_reader"
+	"github.com/rootsdev/fsbff/fs_shard"
 	"io"
-	"io/ioutil"
 	"log"
-	"math"
 	"os"
-	"runtime"
+	"path/filepath"
 	"strings"
 )
 
+/*
+Writes the ids of the persons that match either a query (see fs_query), like
+	-q 'fact(type=Birth, place~"Ohio, United States", year 1850..1870) AND gender=FEMALE'
+or, without -q, the event type suffix, "|"-separated place suffixes and year range given by -t, -p, -s
+and -e, which must all hold on the same fact. With -r, the matching persons themselves are also written,
+as stream files named like the input files and gzipped like them, so the output directory is a subset of
+the input that the other tools can read; a sharded input directory gives a sharded output directory.
+
+Queries can refer to the facts of relatives, like persons whose father was born in Ireland:
+	-q 'parent(gender=MALE AND fact(type=Birth, place~Ireland))'
+which takes an extra pass over the input for each level of parent, spouse or child (see relations.go).
+*/
+
 func check(err error) {
 	if err != nil {
 		log.Fatal(err)
@@ -31,118 +46,256 @@ func isInPlace(place string, places []string) bool {
 	return false
 }
 
-func processFile(filename string, eventType string, places []string, startYear int32, endYear int32) []string {
-	var file io.ReadCloser
-	var err error
-	ids := make([]string, 0, 1000)
+// eventMatcher returns a function matching persons with a fact of an event type, in one of places,
+// between startYear and endYear
+func eventMatcher(eventType string, places []string, startYear int32, endYear int32) func(
+	person *fs_data.FamilySearchPerson) bool {
+	return func(person *fs_data.FamilySearchPerson) bool {
+		for _, fact := range person.GetAllFacts() {
+			if (eventType == "" || (fact.Type != nil && strings.HasSuffix(*fact.Type, eventType))) &&
+				(len(places) == 0 || (fact.Place != nil && isInPlace(*fact.Place, places))) &&
+				((startYear == 0 && endYear == 9999) || (fact.Year != nil && *fact.Year >= startYear && *fact.Year <= endYear)) {
+				return true
+			}
+		}
+		return false
+	}
+}
 
-	file, err = os.Open(filename)
-	check(err)
-	defer file.Close()
+// parseQuery parses a query, pointing at the position of any syntax error
+func parseQuery(query string) (fs_query.Expr, error) {
+	e, err := fs_query.Parse(query)
+	if syntaxErr, ok := err.(*fs_query.SyntaxError); ok {
+		return nil, fmt.Errorf("%v\n%s\n%s^", err, query, strings.Repeat(" ", syntaxErr.Pos))
+	}
+	return e, err
+}
 
+// recordWriter writes persons to a stream file, gzipped if its name ends in .gz
+type recordWriter struct {
+	filename string
+	file     *os.File
+	buf      *bufio.Writer
+	zw       *gzip.Writer
+	sw       *fs_data.StreamWriter
+}
+
+func createRecordWriter(filename string) (*recordWriter, error) {
+	file, err := os.Create(filename)
+	if err != nil {
+		return nil, err
+	}
+	w := &recordWriter{filename: filename, file: file, buf: bufio.NewWriter(file)}
+	var out io.Writer = w.buf
 	if strings.HasSuffix(filename, ".gz") {
-		file, err = gzip.NewReader(file)
-		check(err)
-		defer file.Close()
+		w.zw = gzip.NewWriter(w.buf)
+		out = w.zw
 	}
+	w.sw, err = fs_data.NewStreamWriter(out, "filterbyevent", filepath.Dir(filename))
+	if err != nil {
+		file.Close()
+		os.Remove(filename)
+		return nil, err
+	}
+	return w, nil
+}
 
-	bytes, err := ioutil.ReadAll(file)
-	check(err)
-
-	fsPersons := &fs_data.FamilySearchPersons{}
-	err = proto.Unmarshal(bytes, fsPersons)
-	check(err)
+func (w *recordWriter) Write(person *fs_data.FamilySearchPerson) error {
+	return w.sw.Write(person)
+}
 
-	for _, person := range fsPersons.Persons {
-		match := false
-		for _, fact := range person.Facts {
-			if (eventType == "" || (fact.Type != nil && strings.HasSuffix(*fact.Type, eventType))) &&
-			   (len(places) == 0 || (fact.Place != nil && isInPlace(*fact.Place, places))) &&
-			   ((startYear == 0 && endYear == 9999) || (fact.Year != nil && *fact.Year >= startYear && *fact.Year <= endYear)) {
-				match = true
-				break
-			}
-		}
-		if match {
-			ids = append(ids, *person.Id)
-		}
+// Close finishes writing the file, removing it on error
+func (w *recordWriter) Close() error {
+	err := w.sw.Close()
+	if w.zw != nil && err == nil {
+		err = w.zw.Close()
+	}
+	if err == nil {
+		err = w.buf.Flush()
 	}
+	if closeErr := w.file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(w.filename)
+	}
+	return err
+}
 
-	return ids
+// Abort removes the file
+func (w *recordWriter) Abort() {
+	w.sw.Abort()
+	w.file.Close()
+	os.Remove(w.filename)
 }
 
-func processFiles(fileNames chan string, eventType string, places []string, startYear int32, endYear int32, results chan []string) {
-	for fileName := range fileNames {
-		results <- processFile(fileName, eventType, places, startYear, endYear)
+// processFile returns the ids of the persons in a file that match, also writing the persons to
+// recordsFilename unless it is empty
+func processFile(filename string, match func(person *fs_data.FamilySearchPerson) bool, recordsFilename string) (
+	ids []string, err error) {
+	ids = make([]string, 0, 1000)
+
+	source, err := fs_reader.Open(filename)
+	if err != nil {
+		return nil, err
 	}
-}
+	defer source.Close()
 
-func getFilenames(filename string) (int, chan string) {
-	numFiles := 0
-	fileNames := make(chan string, 100000)
-	fileInfo, err := os.Stat(filename)
-	check(err)
-	if fileInfo.IsDir() {
-		fileInfos, err := ioutil.ReadDir(filename)
-		check(err)
-		for _, fileInfo := range fileInfos {
-			fileNames <- filename + "/" + fileInfo.Name()
-			numFiles++
+	var records *recordWriter
+	if recordsFilename != "" {
+		if records, err = createRecordWriter(recordsFilename); err != nil {
+			return nil, err
 		}
-	} else {
-		fileNames <- filename
-		numFiles++
+		defer func() {
+			if err != nil && records != nil {
+				records.Abort()
+			}
+		}()
 	}
-	close(fileNames)
 
-	return numFiles, fileNames
+	for source.Next() {
+		person := source.Person()
+		if match(person) {
+			ids = append(ids, *person.Id)
+			if records != nil {
+				if err = records.Write(person); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	if err = source.Err(); err != nil {
+		return nil, err
+	}
+	if records != nil {
+		if err = records.Close(); err != nil {
+			records = nil
+			return nil, err
+		}
+	}
+	return ids, nil
+}
+
+// writeManifest writes the manifest of the records written from a sharded input directory, whose shards
+// hold the persons of the same input shards
+func writeManifest(inDir, outDir string, persons map[string]int) error {
+	m, err := fs_shard.ReadManifest(inDir)
+	if err != nil {
+		return err
+	}
+	for i := range m.Files {
+		m.Files[i].Persons = int64(persons[m.Files[i].Name])
+	}
+	return m.Write(outDir)
 }
 
 var inFilename = flag.String("i", "", "input filename or directory")
-var outFilename = flag.String("o", "", "output filename")
+var outFilename = flag.String("o", "", "output filename of matching ids (optional with -r)")
+var recordsDir = flag.String("r", "", "output directory for the matching persons, written to files named like the input files")
 var eventType = flag.String("t", "", "event type")
 var place = flag.String("p", "", "place")
 var startYear = flag.Int("s", 0, "start year")
 var endYear = flag.Int("e", 9999, "start year")
 var numWorkers = flag.Int("w", 1, "number of workers)")
+var query = flag.String("q", "", "query selecting persons, used instead of -t, -p, -s and -e")
+var tmpDir = flag.String("tmp", "", "temporary directory for the IDs of relatives matched by -q")
+var sortLines = flag.Int("sortlines", 5000000, "number of IDs of relatives sorted in memory at a time")
 
-func main() {
-	flag.Parse()
-
-	numCPU := runtime.NumCPU()
-	fmt.Printf("Number of CPUs=%d\n", numCPU)
-	runtime.GOMAXPROCS(int(math.Min(float64(numCPU), float64(*numWorkers))))
-
-	numFiles, fileNames := getFilenames(*inFilename)
-
-	fmt.Print("Processing files")
-	results := make(chan []string)
+// run filters the persons; it returns errors rather than exiting, so that deferred cleanup, like removing
+// the relation sets' files, runs when it fails
+func run() error {
+	fileNames, err := fs_reader.Filenames(*inFilename)
+	if err != nil {
+		return err
+	}
 
 	var places []string
 	if place != nil {
 		places = strings.Split(*place, "|")
 	}
+	match := eventMatcher(*eventType, places, int32(*startYear), int32(*endYear))
+	var sets *relationSets
+	if *query != "" {
+		e, err := parseQuery(*query)
+		if err != nil {
+			return err
+		}
+		if sets, err = resolveRelations(fileNames, e, *numWorkers, *tmpDir, *sortLines); err != nil {
+			return err
+		}
+		defer sets.Close()
+		match = e.Match
+	}
 
-	for i := 0; i < *numWorkers; i++ {
-		go processFiles(fileNames, *eventType, places, int32(*startYear), int32(*endYear), results)
+	var out *os.File
+	var buf *bufio.Writer
+	if *outFilename != "" || *recordsDir == "" {
+		if out, err = os.Create(*outFilename); err != nil {
+			return err
+		}
+		defer out.Close()
+		buf = bufio.NewWriter(out)
+	}
+	if *recordsDir != "" {
+		if filepath.Clean(*recordsDir) == filepath.Clean(*inFilename) {
+			return fmt.Errorf("the output directory for persons must differ from the input directory")
+		}
+		if err = os.MkdirAll(*recordsDir, 0755); err != nil {
+			return err
+		}
 	}
 
-	out, err := os.Create(*outFilename)
-	check(err)
-	defer out.Close()
-	buf := bufio.NewWriter(out)
+	fmt.Print("Processing files")
+	persons := make(map[string]int)
+	err = fs_parallel.Run(context.Background(), fileNames,
+		fs_parallel.Options{Workers: *numWorkers, Progress: fs_parallel.Dots(100)},
+		func(ctx context.Context, fileName string) (interface{}, error) {
+			recordsFilename := ""
+			if *recordsDir != "" {
+				recordsFilename = filepath.Join(*recordsDir, filepath.Base(fileName))
+			}
+			return processFile(fileName, match, recordsFilename)
+		},
+		func(fileName string, result interface{}) error {
+			ids := result.([]string)
+			persons[filepath.Base(fileName)] = len(ids)
+			if buf == nil {
+				return nil
+			}
+			for _, id := range ids {
+				buf.WriteString(id)
+				buf.WriteString("\n")
+			}
+			return nil
+		})
+	if err != nil {
+		return err
+	}
+	if sets != nil {
+		if err = sets.Err(); err != nil {
+			return err
+		}
+	}
 
-	for i := 0; i < numFiles; i++ {
-		ids := <-results
-		for _, id := range ids {
-			buf.WriteString(id)
-			buf.WriteString("\n")
+	if *recordsDir != "" {
+		if _, err := os.Stat(filepath.Join(*inFilename, fs_shard.ManifestName)); err == nil {
+			if err = writeManifest(*inFilename, *recordsDir, persons); err != nil {
+				return err
+			}
 		}
-		if i%100 == 0 {
-			fmt.Print(".")
+	}
+	if buf != nil {
+		if err = buf.Flush(); err != nil {
+			return err
 		}
+		out.Sync()
 	}
+	return nil
+}
+
+func main() {
+	flag.Parse()
 
-	buf.Flush()
-	out.Sync()
+	fmt.Printf("Number of CPUs=%d\n", fs_parallel.SetMaxProcs(*numWorkers))
+	check(run())
 }
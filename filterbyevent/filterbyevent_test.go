@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bytes"
+	"code.google.com/p/goprotobuf/proto"
+	"compress/gzip"
+	"github.com/rootsdev/fsbff/fs_data"
+	"github.com/rootsdev/fsbff/fs_reader"
+	"github.com/rootsdev/fsbff/fs_shard"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestMatchers(t *testing.T) {
+	persons := []*fs_data.FamilySearchPerson{
+		{
+			Id:     proto.String("A"),
+			Gender: fs_data.FSGender_FEMALE.Enum(),
+			Facts: []*fs_data.FSFact{
+				{Type: proto.String("Birth"), Year: proto.Int32(1855), Place: proto.String("Franklin, Ohio, United States")},
+			},
+		},
+		{
+			Id:     proto.String("B"),
+			Gender: fs_data.FSGender_MALE.Enum(),
+			Facts: []*fs_data.FSFact{
+				{Type: proto.String("Birth"), Year: proto.Int32(1880), Place: proto.String("Ohio, United States")},
+				{Type: proto.String("Death"), Year: proto.Int32(1860), Place: proto.String("Utah, United States")},
+			},
+		},
+	}
+
+	query, err := parseQuery(`fact(type=Birth, place~"Ohio, United States", year 1850..1870)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tests = []struct {
+		name  string
+		match func(person *fs_data.FamilySearchPerson) bool
+		out   string
+	}{
+		{"event", eventMatcher("Birth", []string{"Ohio, United States"}, 1850, 1870), "A"},
+		{"any event", eventMatcher("", nil, 0, 9999), "AB"},
+		{"places", eventMatcher("", []string{"Idaho, United States", "Utah, United States"}, 0, 9999), "B"},
+		{"query", query.Match, "A"},
+	}
+	for _, test := range tests {
+		out := ""
+		for _, person := range persons {
+			if test.match(person) {
+				out += person.GetId()
+			}
+		}
+		if out != test.out {
+			t.Errorf("%s matched %q; want %q", test.name, out, test.out)
+		}
+	}
+
+	_, err = parseQuery("fact(type=Birth) AND")
+	want := "fs_query: expected a predicate, found end of query at position 20\nfact(type=Birth) AND\n" +
+		"                    ^"
+	if err == nil || err.Error() != want {
+		t.Errorf("parseQuery error %v; want %s", err, want)
+	}
+}
+
+func TestWriteRecords(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filterbyevent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	inDir := filepath.Join(dir, "in")
+	outDir := filepath.Join(dir, "out")
+	for _, d := range []string{inDir, outDir} {
+		if err = os.Mkdir(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// a sharded input directory of legacy files, one of them gzipped
+	m := fs_shard.NewManifest(2, false)
+	m.Files[1].Name += ".gz"
+	shardPersons := make([]*fs_data.FamilySearchPersons, 2)
+	for i := range shardPersons {
+		shardPersons[i] = &fs_data.FamilySearchPersons{}
+	}
+	for i, id := range []string{"A", "B", "C", "D", "E", "F"} {
+		gender := fs_data.FSGender_MALE
+		if i%2 == 0 {
+			gender = fs_data.FSGender_FEMALE
+		}
+		shard := fs_shard.Shard(id, 2)
+		shardPersons[shard].Persons = append(shardPersons[shard].Persons,
+			&fs_data.FamilySearchPerson{Id: proto.String(id), Gender: gender.Enum()})
+		m.Files[shard].Persons++
+	}
+	for i, filename := range m.Filenames(inDir) {
+		b, err := proto.Marshal(shardPersons[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		w := io.Writer(&buf)
+		var zw *gzip.Writer
+		if strings.HasSuffix(filename, ".gz") {
+			zw = gzip.NewWriter(&buf)
+			w = zw
+		}
+		w.Write(b)
+		if zw != nil {
+			zw.Close()
+		}
+		if err = ioutil.WriteFile(filename, buf.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = m.Write(inDir); err != nil {
+		t.Fatal(err)
+	}
+
+	query, err := parseQuery("gender=FEMALE")
+	if err != nil {
+		t.Fatal(err)
+	}
+	persons := make(map[string]int)
+	var matched []string
+	filenames, err := fs_reader.Filenames(inDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, filename := range filenames {
+		ids, err := processFile(filename, query.Match, filepath.Join(outDir, filepath.Base(filename)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		persons[filepath.Base(filename)] = len(ids)
+		matched = append(matched, ids...)
+	}
+	if err = writeManifest(inDir, outDir, persons); err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(matched)
+	if strings.Join(matched, ",") != "A,C,E" {
+		t.Errorf("processFile matched %v; want A,C,E", matched)
+	}
+
+	outManifest, err := fs_shard.ReadManifest(outDir)
+	if err != nil || outManifest.Persons() != 3 {
+		t.Fatalf("ReadManifest(out) = %v, %v; want 3 persons", outManifest, err)
+	}
+	for _, id := range []string{"A", "B", "C", "D", "E", "F"} {
+		person, err := fs_reader.FindPerson(outDir, id)
+		found := id == "A" || id == "C" || id == "E"
+		if err != nil || (person != nil) != found {
+			t.Errorf("FindPerson(out, %s) = %v, %v; want found %v", id, person, err, found)
+		}
+	}
+	if _, err = os.Stat(m.Filenames(outDir)[1]); err != nil {
+		t.Errorf("gzipped shard %s not written: %v", m.Files[1].Name, err)
+	}
+
+	if _, err = processFile(filepath.Join(inDir, "missing"), query.Match, filepath.Join(outDir, "missing")); err == nil {
+		t.Errorf("processFile(missing) returned no error")
+	}
+}
+
+func TestResolveRelations(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filterbyevent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	birth := func(place string) []*fs_data.FSFact {
+		return []*fs_data.FSFact{{Type: proto.String("Birth"), Place: proto.String(place)}}
+	}
+	files := map[string][]*fs_data.FamilySearchPerson{
+		"a.protobuf": {
+			{Id: proto.String("F"), Gender: fs_data.FSGender_MALE.Enum(), Facts: birth("Cork, Ireland"),
+				Spouses: []string{"M"}, Children: []string{"A"}},
+			{Id: proto.String("A"), Gender: fs_data.FSGender_FEMALE.Enum(), Parents: []string{"F", "M"}},
+		},
+		"b.protobuf": {
+			{Id: proto.String("M"), Gender: fs_data.FSGender_FEMALE.Enum(), Facts: birth("Dublin, Ireland"),
+				Spouses: []string{"F"}, Children: []string{"A", "B"}},
+			{Id: proto.String("B"), Gender: fs_data.FSGender_MALE.Enum(), Parents: []string{"M"}},
+			{Id: proto.String("C"), Gender: fs_data.FSGender_MALE.Enum(), Parents: []string{"X"}},
+		},
+	}
+	var fileNames []string
+	for name, persons := range files {
+		b, err := proto.Marshal(&fs_data.FamilySearchPersons{Persons: persons})
+		if err != nil {
+			t.Fatal(err)
+		}
+		fileNames = append(fileNames, filepath.Join(dir, name))
+		if err = ioutil.WriteFile(filepath.Join(dir, name), b, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sort.Strings(fileNames)
+
+	var tests = []struct {
+		query string
+		out   string
+	}{
+		{`parent(gender=MALE AND fact(type=Birth, place~Ireland))`, "A"},
+		{`parent(fact(type=Birth, place~Ireland))`, "A,B"},
+		{`spouse(fact(type=Birth, place~Ireland)) AND gender=FEMALE`, "M"},
+		{`child(parent(gender=MALE))`, "F,M"},
+		{`NOT parent(fact())`, "C,F,M"},
+	}
+	for _, test := range tests {
+		e, err := parseQuery(test.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sets, err := resolveRelations(fileNames, e, 2, dir, 2)
+		if err != nil {
+			t.Fatalf("resolveRelations(%s) error %v", test.query, err)
+		}
+		var matched []string
+		for _, fileName := range fileNames {
+			ids, err := processFile(fileName, e.Match, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			matched = append(matched, ids...)
+		}
+		if err = sets.Err(); err != nil {
+			t.Errorf("%s lookup error %v", test.query, err)
+		}
+		sets.Close()
+		sort.Strings(matched)
+		if strings.Join(matched, ",") != test.out {
+			t.Errorf("%s matched %v; want %s", test.query, matched, test.out)
+		}
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 2 {
+		t.Errorf("resolveRelations left %d files in the temporary directory; want 2 input files", len(files))
+	}
+
+	// a set whose index can't be read records the error instead of stopping the process
+	e, err := parseQuery(`parent(gender=MALE)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sets, err := resolveRelations(fileNames, e, 2, dir, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sets.Close()
+	sets.sets[0].index.Close()
+	if _, err = processFile(fileNames[0], e.Match, ""); err != nil {
+		t.Fatal(err)
+	}
+	if sets.Err() == nil {
+		t.Errorf("relationSets.Err() = nil after reading a closed index; want an error")
+	}
+}
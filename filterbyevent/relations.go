@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/rootsdev/fsbff/fs_index"
+	"github.com/rootsdev/fsbff/fs_parallel"
+	"github.com/rootsdev/fsbff/fs_query"
+	"github.com/rootsdev/fsbff/fs_reader"
+	"github.com/willf/bloom"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+/*
+Queries with parent, spouse or child refer to relatives by ID, so they need extra passes over the persons
+before the final one (see fs_query.Relations). Each pass writes the IDs of the persons that meet the queries
+of its relations to on-disk indexes. Each index is then fronted by a bloom filter, so most relatives that
+don't match are rejected without reading the index. The filters take about 10 bits per ID in memory, much
+less than the IDs themselves would. Errors reading an index are recorded by its set and reported by
+relationSets.Err, since fs_query matches persons without returning errors.
+*/
+
+// idSet is an on-disk set of person IDs; it is safe for concurrent use
+type idSet struct {
+	filter *bloom.BloomFilter
+	index  *fs_index.Index
+	lock   sync.Mutex
+	err    error // the first error looking up an ID
+}
+
+func openIDSet(filename string) (*idSet, error) {
+	index, err := fs_index.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	s := &idSet{filter: bloom.New(uint(10*index.Len())+64, 7), index: index}
+	err = index.ForEach(func(id, value string) error {
+		s.filter.Add([]byte(id))
+		return nil
+	})
+	if err != nil {
+		index.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *idSet) Contains(id string) bool {
+	if !s.filter.Test([]byte(id)) {
+		return false
+	}
+	values, err := s.index.Lookup(id)
+	if err != nil {
+		s.lock.Lock()
+		if s.err == nil {
+			s.err = err
+		}
+		s.lock.Unlock()
+		return false
+	}
+	return len(values) > 0
+}
+
+// Err returns the first error looking up an ID, or nil if there was none
+func (s *idSet) Err() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.err
+}
+
+// relationSets is the sets of IDs of the relations of a query
+type relationSets struct {
+	dir  string
+	sets []*idSet
+}
+
+// Err returns the first error looking up an ID in any of the sets; persons matched while there was an
+// error may have been matched wrongly
+func (r *relationSets) Err() error {
+	for _, set := range r.sets {
+		if err := set.Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close closes the sets and removes their files
+func (r *relationSets) Close() {
+	for _, set := range r.sets {
+		set.index.Close()
+	}
+	os.RemoveAll(r.dir)
+}
+
+// matchRelations returns the ids of the persons in a file that meet the query of each relation
+func matchRelations(filename string, relations []*fs_query.Relation) ([][]string, error) {
+	source, err := fs_reader.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer source.Close()
+
+	ids := make([][]string, len(relations))
+	for source.Next() {
+		person := source.Person()
+		for i, relation := range relations {
+			if relation.Expr.Match(person) {
+				ids[i] = append(ids[i], person.GetId())
+			}
+		}
+	}
+	return ids, source.Err()
+}
+
+// resolveRelations sets the sets of the relations of a query, making a pass over the files for each pass
+// of relations; the returned sets must be closed when the query is no longer matched
+func resolveRelations(fileNames []string, e fs_query.Expr, numWorkers int, tmpDir string, sortLines int) (
+	*relationSets, error) {
+	dir, err := ioutil.TempDir(tmpDir, "filterbyevent")
+	if err != nil {
+		return nil, err
+	}
+	r := &relationSets{dir: dir}
+
+	for pass, relations := range fs_query.Relations(e) {
+		fmt.Printf("Finding relatives, pass %d", pass+1)
+		builders := make([]*fs_index.Builder, len(relations))
+		for i := range builders {
+			builders[i] = fs_index.NewBuilder(dir, sortLines)
+		}
+		closeBuilders := func() {
+			for _, b := range builders {
+				b.Close()
+			}
+		}
+		counts := make([]int, len(relations))
+		err = fs_parallel.Run(context.Background(), fileNames,
+			fs_parallel.Options{Workers: numWorkers, Progress: fs_parallel.Dots(100)},
+			func(ctx context.Context, fileName string) (interface{}, error) {
+				return matchRelations(fileName, relations)
+			},
+			func(fileName string, result interface{}) error {
+				for i, ids := range result.([][]string) {
+					for _, id := range ids {
+						if err := builders[i].Add(id, ""); err != nil {
+							return err
+						}
+					}
+					counts[i] += len(ids)
+				}
+				return nil
+			})
+		if err == nil {
+			err = r.Err()
+		}
+		if err != nil {
+			closeBuilders()
+			r.Close()
+			return nil, err
+		}
+		fmt.Println()
+
+		for i, relation := range relations {
+			filename := filepath.Join(dir, fmt.Sprintf("pass%d-%d.index", pass+1, i))
+			err = builders[i].Write(filename)
+			builders[i].Close()
+			if err != nil {
+				closeBuilders()
+				r.Close()
+				return nil, err
+			}
+			set, err := openIDSet(filename)
+			if err != nil {
+				closeBuilders()
+				r.Close()
+				return nil, err
+			}
+			r.sets = append(r.sets, set)
+			relation.Set = set
+			fmt.Printf("%s persons=%d\n", relation, counts[i])
+		}
+	}
+	return r, nil
+}
@@ -2,19 +2,14 @@ package main
 
 import (
 	"bufio"
-	"code.google.com/p/goprotobuf/proto"
-	"compress/gzip"
+	"context"
 	"flag"
 	"fmt"
 	"github.com/rootsdev/fsbff/fs_data"
-	"io"
-	"io/ioutil"
+	"github.com/rootsdev/fsbff/fs_parallel"
+	"github.com/rootsdev/fsbff/fs_reader"
 	"log"
-	"math"
 	"os"
-	"runtime"
-	"sync"
-	"strings"
 )
 
 /*
@@ -34,32 +29,29 @@ large that the person map will not all fit into memory.
 This package instead implements the algorithm as follows:
   1. Read all the descendants into a set
   2. Read a single proto file of FS people
-  3. If the person is in the descendants set, add all its children to the set
-  4. Repeat steps 2 and 3 until all the proto files have been processed
+  3. If the person is in the descendants set, collect all its children that aren't in the set
+  4. Repeat steps 2 and 3 until all the proto files have been processed, then add the collected children
+     to the set
   5. Iterate steps 2-4 until maxIterations has been reached or no new descendents have been added
   6. Write the descendants to the output file
 */
 
-// global descendants map with a read-write mutex
+// global descendants map; it is only read while files are processed, and added to between iterations
 type descendantsType map[string]bool
-var (
-	descendants descendantsType
-	desdendantsMutex sync.RWMutex
-)
+var descendants descendantsType
 
-func addDescendants(persons []*fs_data.FamilySearchPerson) {
+// newDescendants returns the children of the descendants among persons that aren't descendants yet
+func newDescendants(persons []*fs_data.FamilySearchPerson) (children []string) {
 	for _, person := range persons {
-		desdendantsMutex.RLock()
-		found := descendants[person.GetId()]
-		desdendantsMutex.RUnlock()
-		if found {
-			desdendantsMutex.Lock()
+		if descendants[person.GetId()] {
 			for _, child := range person.GetChildren() {
-				descendants[child] = true
+				if !descendants[child] {
+					children = append(children, child)
+				}
 			}
-			desdendantsMutex.Unlock()
 		}
 	}
+	return
 }
 
 func readDescendants(file *os.File) descendantsType {
@@ -78,34 +70,13 @@ func check(err error) {
 	}
 }
 
-func processFile(filename string) {
-	var file io.ReadCloser
-	var err error
-	file, err = os.Open(filename)
-	check(err)
-	defer file.Close()
-
-	if strings.HasSuffix(filename, ".gz") {
-		file, err = gzip.NewReader(file)
-		check(err)
-		defer file.Close()
+func processFile(filename string) ([]string, error) {
+	fsPersons, err := fs_reader.ReadFile(filename)
+	if err != nil {
+		return nil, err
 	}
 
-	protoBytes, err := ioutil.ReadAll(file)
-	check(err)
-
-	fsPersons := &fs_data.FamilySearchPersons{}
-	err = proto.Unmarshal(protoBytes, fsPersons)
-	check(err)
-
-	addDescendants(fsPersons.GetPersons())
-}
-
-func processFiles(fileNames chan string, results chan int) {
-	for fileName := range fileNames {
-		processFile(fileName)
-		results <- 0 // dummy value to signify file processing is complete
-	}
+	return newDescendants(fsPersons.GetPersons()), nil
 }
 
 var descendantsFilename = flag.String("d", "", "descendants filename")
@@ -117,23 +88,10 @@ var numWorkers = flag.Int("w", 1, "number of workers")
 func main() {
 	flag.Parse()
 
-	numCPU := runtime.NumCPU()
-	fmt.Printf("Number of CPUs=%d\n", numCPU)
-	runtime.GOMAXPROCS(int(math.Min(float64(numCPU), float64(*numWorkers))))
+	fmt.Printf("Number of CPUs=%d\n", fs_parallel.SetMaxProcs(*numWorkers))
 
-	fileNames := make([]string, 0, 100000)
-
-	fileInfo, err := os.Stat(*personsFilename)
+	fileNames, err := fs_reader.Filenames(*personsFilename)
 	check(err)
-	if fileInfo.IsDir() {
-		fileInfos, err := ioutil.ReadDir(*personsFilename)
-		check(err)
-		for _, fileInfo := range fileInfos {
-			fileNames = append(fileNames, *personsFilename + "/" + fileInfo.Name())
-		}
-	} else {
-		fileNames = append(fileNames, *personsFilename)
-	}
 
 	fmt.Println("Reading descendants")
 	descendantsFile, err := os.Open(*descendantsFilename)
@@ -141,29 +99,27 @@ func main() {
 	defer descendantsFile.Close()
 	descendants = readDescendants(descendantsFile)
 
-	results := make(chan int)
-	fileNamesCh := make(chan string, 100000)
-	for i := 0; i < *numWorkers; i++ {
-		go processFiles(fileNamesCh, results)
-	}
-
 	for iter := 0; iter < *maxIterations; iter++ {
 		descendantsCount := len(descendants)
 		fmt.Printf("Processing iteration %d #descendants=%d", iter, descendantsCount)
 
-		// fill up the input channel
-		for i := 0; i < len(fileNames); i++ {
-			fileNamesCh <- fileNames[i]
-		}
-
-		// drain the output channel
-		for i := 0; i < len(fileNames); i++ {
-			<-results
-			if i%1000 == 0 {
-				fmt.Print(".")
-			}
-		}
+		found := make(descendantsType)
+		err = fs_parallel.Run(context.Background(), fileNames,
+			fs_parallel.Options{Workers: *numWorkers, Progress: fs_parallel.Dots(1000)},
+			func(ctx context.Context, fileName string) (interface{}, error) {
+				return processFile(fileName)
+			},
+			func(fileName string, result interface{}) error {
+				for _, child := range result.([]string) {
+					found[child] = true
+				}
+				return nil
+			})
+		check(err)
 		fmt.Println()
+		for child := range found {
+			descendants[child] = true
+		}
 
 		// check if we should end early
 		if descendantsCount == len(descendants) {
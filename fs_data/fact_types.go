@@ -0,0 +1,102 @@
+package fs_data
+
+// FactTypes is the vocabulary of fact types: the GEDCOM X person, couple and parent-child fact types
+// without their http://gedcomx.org/ prefix, and OTHER for custom types that aren't mapped to one of them.
+// Facts of other GEDCOM X types, and of custom types that are URIs, keep the last segment of their type.
+var FactTypes = []string{
+	"Adoption",
+	"AdoptiveParent",
+	"AdultChristening",
+	"Amnesty",
+	"Annulment",
+	"Apprenticeship",
+	"Arrest",
+	"Baptism",
+	"BarMitzvah",
+	"BatMitzvah",
+	"BiologicalParent",
+	"Birth",
+	"Blessing",
+	"Burial",
+	"Caste",
+	"Census",
+	"Christening",
+	"Circumcision",
+	"CivilUnion",
+	"Clan",
+	"CommonLawMarriage",
+	"Confirmation",
+	"Cremation",
+	"Death",
+	"Divorce",
+	"DivorceFiling",
+	"DomesticPartnership",
+	"Education",
+	"EducationEnrollment",
+	"Emigration",
+	"Engagement",
+	"Excommunication",
+	"FirstCommunion",
+	"FosterParent",
+	"Funeral",
+	"GenderChange",
+	"GuardianParent",
+	"Heimat",
+	"Immigration",
+	"Imprisonment",
+	"LandTransaction",
+	"Language",
+	"Living",
+	"MaritalStatus",
+	"Marriage",
+	"MarriageBanns",
+	"MarriageContract",
+	"MarriageLicense",
+	"MarriageNotice",
+	"Medical",
+	"MilitaryAward",
+	"MilitaryDischarge",
+	"MilitaryDraftRegistration",
+	"MilitaryInduction",
+	"MilitaryService",
+	"Mission",
+	"MoveFrom",
+	"MoveTo",
+	"MultipleBirth",
+	"NationalId",
+	"Nationality",
+	"Naturalization",
+	"NumberOfChildren",
+	"NumberOfMarriages",
+	"Occupation",
+	"Ordination",
+	"Pardon",
+	"PhysicalDescription",
+	"Probate",
+	"Property",
+	"Religion",
+	"Residence",
+	"Retirement",
+	"Separation",
+	"SociologicalParent",
+	"StepParent",
+	"Stillbirth",
+	"SurrogateParent",
+	"Visit",
+	"Will",
+	"Yahrzeit",
+	"OTHER",
+}
+
+var factTypeSet = make(map[string]bool, len(FactTypes))
+
+func init() {
+	for _, t := range FactTypes {
+		factTypeSet[t] = true
+	}
+}
+
+// IsFactType reports whether t is in the FactTypes vocabulary
+func IsFactType(t string) bool {
+	return factTypeSet[t]
+}
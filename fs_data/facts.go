@@ -0,0 +1,18 @@
+package fs_data
+
+// GetAllFacts returns the person's own facts followed by the facts recorded on the person's relationships
+// (marriages, divorces, adoptions, ...)
+func (m *FamilySearchPerson) GetAllFacts() []*FSFact {
+	if m == nil {
+		return nil
+	}
+	if len(m.Relationships) == 0 {
+		return m.Facts
+	}
+	facts := make([]*FSFact, 0, len(m.Facts))
+	facts = append(facts, m.Facts...)
+	for _, relationship := range m.Relationships {
+		facts = append(facts, relationship.Facts...)
+	}
+	return facts
+}
@@ -9,10 +9,15 @@ It is generated from these files:
 	fs_data.proto
 
 It has these top-level messages:
+	FSPlaceLevel
+	FSPlace
 	FSFact
+	FSName
 	FSSource
+	FSRelationship
 	FamilySearchPerson
 	FamilySearchPersons
+	FamilySearchPersonsHeader
 */
 package fs_data
 
@@ -59,14 +64,166 @@ func (x *FSGender) UnmarshalJSON(data []byte) error {
 	return nil
 }
 
-type FSFact struct {
-	Type             *string `protobuf:"bytes,1,opt,name=type" json:"type,omitempty"`
-	Year             *int32  `protobuf:"varint,2,opt,name=year" json:"year,omitempty"`
-	Place            *string `protobuf:"bytes,3,opt,name=place" json:"place,omitempty"`
-	Value            *string `protobuf:"bytes,4,opt,name=value" json:"value,omitempty"`
+type FSDateModifier int32
+
+const (
+	FSDateModifier_EXACT      FSDateModifier = 0
+	FSDateModifier_ABOUT      FSDateModifier = 1
+	FSDateModifier_BEFORE     FSDateModifier = 2
+	FSDateModifier_AFTER      FSDateModifier = 3
+	FSDateModifier_BETWEEN    FSDateModifier = 4
+	FSDateModifier_CALCULATED FSDateModifier = 5
+)
+
+var FSDateModifier_name = map[int32]string{
+	0: "EXACT",
+	1: "ABOUT",
+	2: "BEFORE",
+	3: "AFTER",
+	4: "BETWEEN",
+	5: "CALCULATED",
+}
+var FSDateModifier_value = map[string]int32{
+	"EXACT":      0,
+	"ABOUT":      1,
+	"BEFORE":     2,
+	"AFTER":      3,
+	"BETWEEN":    4,
+	"CALCULATED": 5,
+}
+
+func (x FSDateModifier) Enum() *FSDateModifier {
+	p := new(FSDateModifier)
+	*p = x
+	return p
+}
+func (x FSDateModifier) String() string {
+	return proto.EnumName(FSDateModifier_name, int32(x))
+}
+func (x *FSDateModifier) UnmarshalJSON(data []byte) error {
+	value, err := proto.UnmarshalJSONEnum(FSDateModifier_value, data, "FSDateModifier")
+	if err != nil {
+		return err
+	}
+	*x = FSDateModifier(value)
+	return nil
+}
+
+type FSRelationshipType int32
+
+const (
+	FSRelationshipType_PARENT FSRelationshipType = 1
+	FSRelationshipType_CHILD  FSRelationshipType = 2
+	FSRelationshipType_SPOUSE FSRelationshipType = 3
+)
+
+var FSRelationshipType_name = map[int32]string{
+	1: "PARENT",
+	2: "CHILD",
+	3: "SPOUSE",
+}
+var FSRelationshipType_value = map[string]int32{
+	"PARENT": 1,
+	"CHILD":  2,
+	"SPOUSE": 3,
+}
+
+func (x FSRelationshipType) Enum() *FSRelationshipType {
+	p := new(FSRelationshipType)
+	*p = x
+	return p
+}
+func (x FSRelationshipType) String() string {
+	return proto.EnumName(FSRelationshipType_name, int32(x))
+}
+func (x *FSRelationshipType) UnmarshalJSON(data []byte) error {
+	value, err := proto.UnmarshalJSONEnum(FSRelationshipType_value, data, "FSRelationshipType")
+	if err != nil {
+		return err
+	}
+	*x = FSRelationshipType(value)
+	return nil
+}
+
+type FSPlaceLevel struct {
+	Name             *string `protobuf:"bytes,1,opt,name=name" json:"name,omitempty"`
+	Type             *string `protobuf:"bytes,2,opt,name=type" json:"type,omitempty"`
 	XXX_unrecognized []byte  `json:"-"`
 }
 
+func (m *FSPlaceLevel) Reset()         { *m = FSPlaceLevel{} }
+func (m *FSPlaceLevel) String() string { return proto.CompactTextString(m) }
+func (*FSPlaceLevel) ProtoMessage()    {}
+
+func (m *FSPlaceLevel) GetName() string {
+	if m != nil && m.Name != nil {
+		return *m.Name
+	}
+	return ""
+}
+
+func (m *FSPlaceLevel) GetType() string {
+	if m != nil && m.Type != nil {
+		return *m.Type
+	}
+	return ""
+}
+
+type FSPlace struct {
+	Id               *string         `protobuf:"bytes,1,opt,name=id" json:"id,omitempty"`
+	Levels           []*FSPlaceLevel `protobuf:"bytes,2,rep,name=levels" json:"levels,omitempty"`
+	Latitude         *float64        `protobuf:"fixed64,3,opt,name=latitude" json:"latitude,omitempty"`
+	Longitude        *float64        `protobuf:"fixed64,4,opt,name=longitude" json:"longitude,omitempty"`
+	XXX_unrecognized []byte          `json:"-"`
+}
+
+func (m *FSPlace) Reset()         { *m = FSPlace{} }
+func (m *FSPlace) String() string { return proto.CompactTextString(m) }
+func (*FSPlace) ProtoMessage()    {}
+
+func (m *FSPlace) GetId() string {
+	if m != nil && m.Id != nil {
+		return *m.Id
+	}
+	return ""
+}
+
+func (m *FSPlace) GetLevels() []*FSPlaceLevel {
+	if m != nil {
+		return m.Levels
+	}
+	return nil
+}
+
+func (m *FSPlace) GetLatitude() float64 {
+	if m != nil && m.Latitude != nil {
+		return *m.Latitude
+	}
+	return 0
+}
+
+func (m *FSPlace) GetLongitude() float64 {
+	if m != nil && m.Longitude != nil {
+		return *m.Longitude
+	}
+	return 0
+}
+
+type FSFact struct {
+	Type             *string         `protobuf:"bytes,1,opt,name=type" json:"type,omitempty"`
+	Year             *int32          `protobuf:"varint,2,opt,name=year" json:"year,omitempty"`
+	Place            *string         `protobuf:"bytes,3,opt,name=place" json:"place,omitempty"`
+	Value            *string         `protobuf:"bytes,4,opt,name=value" json:"value,omitempty"`
+	Month            *int32          `protobuf:"varint,5,opt,name=month" json:"month,omitempty"`
+	Day              *int32          `protobuf:"varint,6,opt,name=day" json:"day,omitempty"`
+	Modifier         *FSDateModifier `protobuf:"varint,7,opt,name=modifier,enum=fs_data.FSDateModifier" json:"modifier,omitempty"`
+	EndYear          *int32          `protobuf:"varint,8,opt,name=end_year" json:"end_year,omitempty"`
+	EndMonth         *int32          `protobuf:"varint,9,opt,name=end_month" json:"end_month,omitempty"`
+	EndDay           *int32          `protobuf:"varint,10,opt,name=end_day" json:"end_day,omitempty"`
+	StdPlace         *FSPlace        `protobuf:"bytes,11,opt,name=std_place" json:"std_place,omitempty"`
+	XXX_unrecognized []byte          `json:"-"`
+}
+
 func (m *FSFact) Reset()         { *m = FSFact{} }
 func (m *FSFact) String() string { return proto.CompactTextString(m) }
 func (*FSFact) ProtoMessage()    {}
@@ -99,9 +256,108 @@ func (m *FSFact) GetValue() string {
 	return ""
 }
 
+func (m *FSFact) GetMonth() int32 {
+	if m != nil && m.Month != nil {
+		return *m.Month
+	}
+	return 0
+}
+
+func (m *FSFact) GetDay() int32 {
+	if m != nil && m.Day != nil {
+		return *m.Day
+	}
+	return 0
+}
+
+func (m *FSFact) GetModifier() FSDateModifier {
+	if m != nil && m.Modifier != nil {
+		return *m.Modifier
+	}
+	return FSDateModifier_EXACT
+}
+
+func (m *FSFact) GetEndYear() int32 {
+	if m != nil && m.EndYear != nil {
+		return *m.EndYear
+	}
+	return 0
+}
+
+func (m *FSFact) GetEndMonth() int32 {
+	if m != nil && m.EndMonth != nil {
+		return *m.EndMonth
+	}
+	return 0
+}
+
+func (m *FSFact) GetEndDay() int32 {
+	if m != nil && m.EndDay != nil {
+		return *m.EndDay
+	}
+	return 0
+}
+
+func (m *FSFact) GetStdPlace() *FSPlace {
+	if m != nil {
+		return m.StdPlace
+	}
+	return nil
+}
+
+type FSName struct {
+	Given            *string `protobuf:"bytes,1,opt,name=given" json:"given,omitempty"`
+	Surname          *string `protobuf:"bytes,2,opt,name=surname" json:"surname,omitempty"`
+	FullText         *string `protobuf:"bytes,3,opt,name=full_text" json:"full_text,omitempty"`
+	Type             *string `protobuf:"bytes,4,opt,name=type" json:"type,omitempty"`
+	Preferred        *bool   `protobuf:"varint,5,opt,name=preferred" json:"preferred,omitempty"`
+	XXX_unrecognized []byte  `json:"-"`
+}
+
+func (m *FSName) Reset()         { *m = FSName{} }
+func (m *FSName) String() string { return proto.CompactTextString(m) }
+func (*FSName) ProtoMessage()    {}
+
+func (m *FSName) GetGiven() string {
+	if m != nil && m.Given != nil {
+		return *m.Given
+	}
+	return ""
+}
+
+func (m *FSName) GetSurname() string {
+	if m != nil && m.Surname != nil {
+		return *m.Surname
+	}
+	return ""
+}
+
+func (m *FSName) GetFullText() string {
+	if m != nil && m.FullText != nil {
+		return *m.FullText
+	}
+	return ""
+}
+
+func (m *FSName) GetType() string {
+	if m != nil && m.Type != nil {
+		return *m.Type
+	}
+	return ""
+}
+
+func (m *FSName) GetPreferred() bool {
+	if m != nil && m.Preferred != nil {
+		return *m.Preferred
+	}
+	return false
+}
+
 type FSSource struct {
 	SourceId         *string `protobuf:"bytes,1,opt,name=source_id" json:"source_id,omitempty"`
 	Title            *string `protobuf:"bytes,2,opt,name=title" json:"title,omitempty"`
+	About            *string `protobuf:"bytes,3,opt,name=about" json:"about,omitempty"`
+	Citation         *string `protobuf:"bytes,4,opt,name=citation" json:"citation,omitempty"`
 	XXX_unrecognized []byte  `json:"-"`
 }
 
@@ -123,16 +379,73 @@ func (m *FSSource) GetTitle() string {
 	return ""
 }
 
+func (m *FSSource) GetAbout() string {
+	if m != nil && m.About != nil {
+		return *m.About
+	}
+	return ""
+}
+
+func (m *FSSource) GetCitation() string {
+	if m != nil && m.Citation != nil {
+		return *m.Citation
+	}
+	return ""
+}
+
+type FSRelationship struct {
+	Type             *FSRelationshipType `protobuf:"varint,1,opt,name=type,enum=fs_data.FSRelationshipType" json:"type,omitempty"`
+	RelatedId        *string             `protobuf:"bytes,2,opt,name=related_id" json:"related_id,omitempty"`
+	Facts            []*FSFact           `protobuf:"bytes,3,rep,name=facts" json:"facts,omitempty"`
+	Contributors     []string            `protobuf:"bytes,4,rep,name=contributors" json:"contributors,omitempty"`
+	XXX_unrecognized []byte              `json:"-"`
+}
+
+func (m *FSRelationship) Reset()         { *m = FSRelationship{} }
+func (m *FSRelationship) String() string { return proto.CompactTextString(m) }
+func (*FSRelationship) ProtoMessage()    {}
+
+func (m *FSRelationship) GetType() FSRelationshipType {
+	if m != nil && m.Type != nil {
+		return *m.Type
+	}
+	return FSRelationshipType_PARENT
+}
+
+func (m *FSRelationship) GetRelatedId() string {
+	if m != nil && m.RelatedId != nil {
+		return *m.RelatedId
+	}
+	return ""
+}
+
+func (m *FSRelationship) GetFacts() []*FSFact {
+	if m != nil {
+		return m.Facts
+	}
+	return nil
+}
+
+func (m *FSRelationship) GetContributors() []string {
+	if m != nil {
+		return m.Contributors
+	}
+	return nil
+}
+
 type FamilySearchPerson struct {
-	Id               *string     `protobuf:"bytes,1,opt,name=id" json:"id,omitempty"`
-	Gender           *FSGender   `protobuf:"varint,2,opt,name=gender,enum=fs_data.FSGender" json:"gender,omitempty"`
-	Facts            []*FSFact   `protobuf:"bytes,3,rep,name=facts" json:"facts,omitempty"`
-	Contributors     []string    `protobuf:"bytes,4,rep,name=contributors" json:"contributors,omitempty"`
-	Sources          []*FSSource `protobuf:"bytes,5,rep,name=sources" json:"sources,omitempty"`
-	Parents          []string    `protobuf:"bytes,6,rep,name=parents" json:"parents,omitempty"`
-	Spouses          []string    `protobuf:"bytes,7,rep,name=spouses" json:"spouses,omitempty"`
-	Children         []string    `protobuf:"bytes,8,rep,name=children" json:"children,omitempty"`
-	XXX_unrecognized []byte      `json:"-"`
+	Id               *string           `protobuf:"bytes,1,opt,name=id" json:"id,omitempty"`
+	Gender           *FSGender         `protobuf:"varint,2,opt,name=gender,enum=fs_data.FSGender" json:"gender,omitempty"`
+	Facts            []*FSFact         `protobuf:"bytes,3,rep,name=facts" json:"facts,omitempty"`
+	Contributors     []string          `protobuf:"bytes,4,rep,name=contributors" json:"contributors,omitempty"`
+	Sources          []*FSSource       `protobuf:"bytes,5,rep,name=sources" json:"sources,omitempty"`
+	Parents          []string          `protobuf:"bytes,6,rep,name=parents" json:"parents,omitempty"`
+	Spouses          []string          `protobuf:"bytes,7,rep,name=spouses" json:"spouses,omitempty"`
+	Children         []string          `protobuf:"bytes,8,rep,name=children" json:"children,omitempty"`
+	Names            []*FSName         `protobuf:"bytes,9,rep,name=names" json:"names,omitempty"`
+	Relationships    []*FSRelationship `protobuf:"bytes,10,rep,name=relationships" json:"relationships,omitempty"`
+	Modified         *int64            `protobuf:"varint,11,opt,name=modified" json:"modified,omitempty"`
+	XXX_unrecognized []byte            `json:"-"`
 }
 
 func (m *FamilySearchPerson) Reset()         { *m = FamilySearchPerson{} }
@@ -195,6 +508,27 @@ func (m *FamilySearchPerson) GetChildren() []string {
 	return nil
 }
 
+func (m *FamilySearchPerson) GetNames() []*FSName {
+	if m != nil {
+		return m.Names
+	}
+	return nil
+}
+
+func (m *FamilySearchPerson) GetRelationships() []*FSRelationship {
+	if m != nil {
+		return m.Relationships
+	}
+	return nil
+}
+
+func (m *FamilySearchPerson) GetModified() int64 {
+	if m != nil && m.Modified != nil {
+		return *m.Modified
+	}
+	return 0
+}
+
 type FamilySearchPersons struct {
 	Persons          []*FamilySearchPerson `protobuf:"bytes,1,rep,name=persons" json:"persons,omitempty"`
 	XXX_unrecognized []byte                `json:"-"`
@@ -211,6 +545,40 @@ func (m *FamilySearchPersons) GetPersons() []*FamilySearchPerson {
 	return nil
 }
 
+type FamilySearchPersonsHeader struct {
+	Version          *int32  `protobuf:"varint,1,opt,name=version" json:"version,omitempty"`
+	RecordCount      *int64  `protobuf:"varint,2,opt,name=record_count" json:"record_count,omitempty"`
+	Producer         *string `protobuf:"bytes,3,opt,name=producer" json:"producer,omitempty"`
+	XXX_unrecognized []byte  `json:"-"`
+}
+
+func (m *FamilySearchPersonsHeader) Reset()         { *m = FamilySearchPersonsHeader{} }
+func (m *FamilySearchPersonsHeader) String() string { return proto.CompactTextString(m) }
+func (*FamilySearchPersonsHeader) ProtoMessage()    {}
+
+func (m *FamilySearchPersonsHeader) GetVersion() int32 {
+	if m != nil && m.Version != nil {
+		return *m.Version
+	}
+	return 0
+}
+
+func (m *FamilySearchPersonsHeader) GetRecordCount() int64 {
+	if m != nil && m.RecordCount != nil {
+		return *m.RecordCount
+	}
+	return 0
+}
+
+func (m *FamilySearchPersonsHeader) GetProducer() string {
+	if m != nil && m.Producer != nil {
+		return *m.Producer
+	}
+	return ""
+}
+
 func init() {
 	proto.RegisterEnum("fs_data.FSGender", FSGender_name, FSGender_value)
+	proto.RegisterEnum("fs_data.FSDateModifier", FSDateModifier_name, FSDateModifier_value)
+	proto.RegisterEnum("fs_data.FSRelationshipType", FSRelationshipType_name, FSRelationshipType_value)
 }
@@ -0,0 +1,20 @@
+package fs_data
+
+import (
+	"encoding/json"
+)
+
+// The generated enums unmarshal from JSON names but marshal as numbers; marshal them as names
+// so that persons written as JSON are readable and unmarshal back to the same values.
+
+func (x FSGender) MarshalJSON() ([]byte, error) {
+	return json.Marshal(x.String())
+}
+
+func (x FSDateModifier) MarshalJSON() ([]byte, error) {
+	return json.Marshal(x.String())
+}
+
+func (x FSRelationshipType) MarshalJSON() ([]byte, error) {
+	return json.Marshal(x.String())
+}
@@ -0,0 +1,29 @@
+package fs_data
+
+import (
+	"code.google.com/p/goprotobuf/proto"
+	"encoding/json"
+	"testing"
+)
+
+func TestJSON(t *testing.T) {
+	person := &FamilySearchPerson{
+		Id:     proto.String("A"),
+		Gender: FSGender_FEMALE.Enum(),
+		Facts: []*FSFact{
+			{Type: proto.String("Birth"), Year: proto.Int32(1850), Modifier: FSDateModifier_ABOUT.Enum()},
+		},
+	}
+	b, err := json.Marshal(person)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"id":"A","gender":"FEMALE","facts":[{"type":"Birth","year":1850,"modifier":"ABOUT"}]}`
+	if string(b) != want {
+		t.Errorf("json.Marshal = %s; want %s", b, want)
+	}
+	actual := &FamilySearchPerson{}
+	if err = json.Unmarshal(b, actual); err != nil || !proto.Equal(actual, person) {
+		t.Errorf("json.Unmarshal(%s) = %v, %v; want %v", b, actual, err, person)
+	}
+}
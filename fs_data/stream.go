@@ -0,0 +1,233 @@
+package fs_data
+
+import (
+	"bufio"
+	"bytes"
+	proto "code.google.com/p/goprotobuf/proto"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+/*
+A stream file holds FamilySearchPerson messages one after another instead of in a single
+FamilySearchPersons message, so readers can process one person at a time:
+
+	"FSPS" magic
+	varint length, FamilySearchPersonsHeader
+	varint length, FamilySearchPerson
+	varint length, FamilySearchPerson
+	...
+
+A serialized FamilySearchPersons message never starts with 'F' (wire type 6 is invalid), so the
+magic distinguishes stream files from legacy FamilySearchPersons files.
+*/
+
+// StreamMagic begins every stream file
+const StreamMagic = "FSPS"
+
+// StreamVersion is the version of the stream format written by StreamWriter
+const StreamVersion = 1
+
+// maximum size of a single message; guards against reading garbage as a huge length
+const maxMessageSize = 64 << 20
+
+// IsStream reports whether the first bytes of a file are the stream magic
+func IsStream(prefix []byte) bool {
+	return bytes.HasPrefix(prefix, []byte(StreamMagic))
+}
+
+func writeMessage(w io.Writer, pb proto.Message) (int, error) {
+	b, err := proto.Marshal(pb)
+	if err != nil {
+		return 0, err
+	}
+	n, err := w.Write(proto.EncodeVarint(uint64(len(b))))
+	if err != nil {
+		return n, err
+	}
+	m, err := w.Write(b)
+	return n + m, err
+}
+
+// StreamWriter writes a stream file.
+// Persons are spooled to a temporary file until Close so the header can carry the record count.
+type StreamWriter struct {
+	w        io.Writer
+	producer string
+	spool    *os.File
+	spoolBuf *bufio.Writer
+	count    int64
+}
+
+// NewStreamWriter returns a StreamWriter writing to w; the spool file is created in tmpDir (or the
+// default temporary directory if tmpDir is "")
+func NewStreamWriter(w io.Writer, producer string, tmpDir string) (*StreamWriter, error) {
+	spool, err := ioutil.TempFile(tmpDir, "fsps")
+	if err != nil {
+		return nil, err
+	}
+	return &StreamWriter{
+		w:        w,
+		producer: producer,
+		spool:    spool,
+		spoolBuf: bufio.NewWriter(spool),
+	}, nil
+}
+
+// Write writes a single person
+func (s *StreamWriter) Write(person *FamilySearchPerson) error {
+	b, err := proto.Marshal(person)
+	if err != nil {
+		return err
+	}
+	return s.WriteMarshaled(b)
+}
+
+// WriteMarshaled writes a single person that the caller has already marshaled
+func (s *StreamWriter) WriteMarshaled(b []byte) error {
+	if s.spool == nil {
+		return errors.New("fs_data: write to closed StreamWriter")
+	}
+	if _, err := s.spoolBuf.Write(proto.EncodeVarint(uint64(len(b)))); err != nil {
+		return err
+	}
+	if _, err := s.spoolBuf.Write(b); err != nil {
+		return err
+	}
+	s.count++
+	return nil
+}
+
+// Count returns the number of persons written so far
+func (s *StreamWriter) Count() int64 {
+	return s.count
+}
+
+// Close writes the header and the spooled persons to the underlying writer.
+// It does not close the underlying writer.
+func (s *StreamWriter) Close() error {
+	if s.spool == nil {
+		return nil
+	}
+	defer func() {
+		s.spool.Close()
+		os.Remove(s.spool.Name())
+		s.spool = nil
+	}()
+
+	err := s.spoolBuf.Flush()
+	if err == nil {
+		_, err = s.spool.Seek(0, 0)
+	}
+	if err == nil {
+		_, err = io.WriteString(s.w, StreamMagic)
+	}
+	if err == nil {
+		header := &FamilySearchPersonsHeader{
+			Version:     proto.Int32(StreamVersion),
+			RecordCount: proto.Int64(s.count),
+			Producer:    proto.String(s.producer),
+		}
+		_, err = writeMessage(s.w, header)
+	}
+	if err == nil {
+		_, err = io.Copy(s.w, s.spool)
+	}
+	return err
+}
+
+// Abort discards the spooled persons without writing anything
+func (s *StreamWriter) Abort() {
+	if s.spool != nil {
+		s.spool.Close()
+		os.Remove(s.spool.Name())
+		s.spool = nil
+	}
+}
+
+// StreamReader reads a stream file one person at a time
+type StreamReader struct {
+	r      *bufio.Reader
+	header *FamilySearchPersonsHeader
+	offset int64
+	buf    []byte
+}
+
+// NewStreamReader reads the magic and header from r
+func NewStreamReader(r io.Reader) (*StreamReader, error) {
+	br, ok := r.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+	s := &StreamReader{r: br}
+
+	magic := make([]byte, len(StreamMagic))
+	if _, err := io.ReadFull(br, magic); err != nil || !IsStream(magic) {
+		return nil, errors.New("fs_data: not a stream file")
+	}
+	s.offset = int64(len(magic))
+
+	s.header = &FamilySearchPersonsHeader{}
+	if err := s.readMessage(s.header); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, fmt.Errorf("fs_data: reading stream header: %v", err)
+	}
+	if s.header.GetVersion() > StreamVersion {
+		return nil, fmt.Errorf("fs_data: unsupported stream version %d", s.header.GetVersion())
+	}
+	return s, nil
+}
+
+func (s *StreamReader) readMessage(pb proto.Message) error {
+	start := s.offset
+	length, err := binary.ReadUvarint(s.r)
+	if err != nil {
+		if err == io.EOF {
+			return io.EOF
+		}
+		return fmt.Errorf("offset %d: %v", start, err)
+	}
+	if length > maxMessageSize {
+		return fmt.Errorf("offset %d: message length %d too large", start, length)
+	}
+	if uint64(cap(s.buf)) < length {
+		s.buf = make([]byte, length)
+	}
+	b := s.buf[:length]
+	if _, err = io.ReadFull(s.r, b); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return fmt.Errorf("offset %d: %v", start, err)
+	}
+	s.offset += int64(len(proto.EncodeVarint(length))) + int64(length)
+	if err = proto.Unmarshal(b, pb); err != nil {
+		return fmt.Errorf("offset %d: %v", start, err)
+	}
+	return nil
+}
+
+// Header returns the stream header
+func (s *StreamReader) Header() *FamilySearchPersonsHeader {
+	return s.header
+}
+
+// Offset returns the byte offset of the next person within the (uncompressed) stream
+func (s *StreamReader) Offset() int64 {
+	return s.offset
+}
+
+// Read returns the next person, or io.EOF at the end of the stream
+func (s *StreamReader) Read() (*FamilySearchPerson, error) {
+	person := &FamilySearchPerson{}
+	if err := s.readMessage(person); err != nil {
+		return nil, err
+	}
+	return person, nil
+}
@@ -0,0 +1,74 @@
+package fs_data
+
+import (
+	"bytes"
+	proto "code.google.com/p/goprotobuf/proto"
+	"io"
+	"testing"
+)
+
+func TestStream(t *testing.T) {
+	ids := []string{"A", "B", "C"}
+	var buf bytes.Buffer
+	w, err := NewStreamWriter(&buf, "test", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range ids {
+		if err = w.Write(&FamilySearchPerson{Id: proto.String(id), Gender: FSGender_FEMALE.Enum()}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if buf.Len() != 0 {
+		t.Errorf("StreamWriter wrote %d bytes before Close", buf.Len())
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !IsStream(buf.Bytes()) {
+		t.Fatalf("IsStream(%q) = false", buf.Bytes()[:4])
+	}
+
+	r, err := NewStreamReader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := r.Header()
+	if header.GetVersion() != StreamVersion || header.GetRecordCount() != int64(len(ids)) || header.GetProducer() != "test" {
+		t.Errorf("header = %v; want version %d count %d producer test", header, StreamVersion, len(ids))
+	}
+	var actual []string
+	for {
+		person, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		actual = append(actual, person.GetId())
+	}
+	if len(actual) != len(ids) || actual[0] != "A" || actual[2] != "C" {
+		t.Errorf("read %v; want %v", actual, ids)
+	}
+	if r.Offset() != int64(buf.Len()) {
+		t.Errorf("Offset() = %d; want %d", r.Offset(), buf.Len())
+	}
+
+	// truncated
+	r, _ = NewStreamReader(bytes.NewReader(buf.Bytes()[:buf.Len()-2]))
+	r.Read()
+	r.Read()
+	if _, err = r.Read(); err == nil || err == io.EOF {
+		t.Errorf("truncated stream Read() error %v; want unexpected EOF", err)
+	}
+
+	// legacy FamilySearchPersons
+	legacy, _ := proto.Marshal(&FamilySearchPersons{Persons: []*FamilySearchPerson{{Id: proto.String("A")}}})
+	if IsStream(legacy) {
+		t.Errorf("IsStream(legacy) = true")
+	}
+	if _, err = NewStreamReader(bytes.NewReader(legacy)); err == nil {
+		t.Errorf("NewStreamReader(legacy) returned no error")
+	}
+}
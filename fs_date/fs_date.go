@@ -0,0 +1,270 @@
+/*
+Package fs_date parses user-entered genealogical dates like "25 Apr 1888", "Abt 1880",
+"Bet 1850 and 1860" or "1850-60", as found in BFF XML, GEDCOM X JSON and GEDCOM files.
+
+	date := fs_date.Parse("Abt Apr 1880")
+	date.SetFact(fsFact)
+*/
+package fs_date
+
+import (
+	"github.com/rootsdev/fsbff/fs_data"
+	"strings"
+	"unicode"
+)
+
+// Date is a parsed genealogical date.
+// Zero values mean the component is unknown; an EXACT (zero) modifier means the date is exact.
+type Date struct {
+	Year, Month, Day          int32
+	Modifier                  fs_data.FSDateModifier
+	EndYear, EndMonth, EndDay int32
+}
+
+var monthNames = []string{"january", "february", "march", "april", "may", "june",
+	"july", "august", "september", "october", "november", "december"}
+
+var modifierWords = map[string]fs_data.FSDateModifier{
+	"abt":           fs_data.FSDateModifier_ABOUT,
+	"about":         fs_data.FSDateModifier_ABOUT,
+	"approx":        fs_data.FSDateModifier_ABOUT,
+	"approximately": fs_data.FSDateModifier_ABOUT,
+	"around":        fs_data.FSDateModifier_ABOUT,
+	"c":             fs_data.FSDateModifier_ABOUT,
+	"ca":            fs_data.FSDateModifier_ABOUT,
+	"circa":         fs_data.FSDateModifier_ABOUT,
+	"est":           fs_data.FSDateModifier_ABOUT,
+	"estimated":     fs_data.FSDateModifier_ABOUT,
+	"~":             fs_data.FSDateModifier_ABOUT,
+	"bef":           fs_data.FSDateModifier_BEFORE,
+	"before":        fs_data.FSDateModifier_BEFORE,
+	"<":             fs_data.FSDateModifier_BEFORE,
+	"aft":           fs_data.FSDateModifier_AFTER,
+	"after":         fs_data.FSDateModifier_AFTER,
+	">":             fs_data.FSDateModifier_AFTER,
+	"bet":           fs_data.FSDateModifier_BETWEEN,
+	"btw":           fs_data.FSDateModifier_BETWEEN,
+	"between":       fs_data.FSDateModifier_BETWEEN,
+	"from":          fs_data.FSDateModifier_BETWEEN,
+	"cal":           fs_data.FSDateModifier_CALCULATED,
+	"calc":          fs_data.FSDateModifier_CALCULATED,
+	"calculated":    fs_data.FSDateModifier_CALCULATED,
+}
+
+// words separating the two ends of a range; "-" is only treated as a range separator
+// when both sides contain a year, so that 1880-04-25 stays a single date
+var rangeWords = map[string]bool{"and": true, "&": true, "to": true, "until": true}
+
+type dateToken struct {
+	text   string
+	number bool
+}
+
+func tokenizeDate(text string) (tokens []dateToken) {
+	runes := []rune(strings.ToLower(text))
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		j := i + 1
+		switch {
+		case unicode.IsDigit(r):
+			for j < len(runes) && unicode.IsDigit(runes[j]) {
+				j++
+			}
+			tokens = append(tokens, dateToken{string(runes[i:j]), true})
+		case unicode.IsLetter(r):
+			for j < len(runes) && unicode.IsLetter(runes[j]) {
+				j++
+			}
+			tokens = append(tokens, dateToken{string(runes[i:j]), false})
+		case r == '-' || r == '&' || r == '~' || r == '<' || r == '>':
+			tokens = append(tokens, dateToken{string(r), false})
+		}
+		i = j
+	}
+	return
+}
+
+func isYear(token dateToken) bool {
+	return token.number && len(token.text) == 4
+}
+
+func hasYear(tokens []dateToken) bool {
+	for _, token := range tokens {
+		if isYear(token) {
+			return true
+		}
+	}
+	return false
+}
+
+func getMonth(word string) int32 {
+	if len(word) < 3 {
+		return 0
+	}
+	if word == "sept" {
+		return 9
+	}
+	for i, name := range monthNames {
+		if strings.HasPrefix(name, word) {
+			return int32(i + 1)
+		}
+	}
+	return 0
+}
+
+func atoi(s string) (n int32) {
+	for _, r := range s {
+		n = n*10 + int32(r-'0')
+	}
+	return
+}
+
+// isShortYearRange reports whether the tokens are of the form 1850-60
+func isShortYearRange(start, end []dateToken) bool {
+	return len(start) == 1 && isYear(start[0]) && len(end) == 1 && end[0].number && len(end[0].text) == 2
+}
+
+var daysInMonth = []int32{31, 29, 31, 30, 31, 30, 31, 31, 30, 31, 30, 31}
+
+// parseSimpleDate parses a single (non-range) date from its tokens
+func parseSimpleDate(tokens []dateToken) (year, month, day int32) {
+	var small []int32
+	for _, token := range tokens {
+		switch {
+		case isYear(token):
+			if year == 0 {
+				year = atoi(token.text)
+			}
+		case token.number:
+			if len(token.text) <= 2 {
+				small = append(small, atoi(token.text))
+			}
+		case month == 0:
+			month = getMonth(token.text)
+		}
+	}
+
+	if month != 0 {
+		// 25 Apr 1888; any second small number is a two-digit year, which is ambiguous
+		if len(small) > 0 {
+			day = small[0]
+		}
+	} else if len(small) >= 2 {
+		// 3/24/2010 or 24/3/2010 or 1880-04-25; month first unless it can't be a month
+		month, day = small[0], small[1]
+		if month > 12 && day <= 12 {
+			month, day = day, month
+		}
+	} else if len(small) == 1 && year != 0 {
+		// 4/1880
+		month = small[0]
+	}
+
+	if month < 1 || month > 12 {
+		month, day = 0, 0
+	}
+	if day < 1 || (month != 0 && day > daysInMonth[month-1]) || (month == 0 && day > 31) {
+		day = 0
+	}
+	return
+}
+
+// Parse parses user-entered date text, ignoring anything it doesn't recognize
+func Parse(text string) (date Date) {
+	tokens := tokenizeDate(text)
+
+	// pull out modifiers and find the range separator
+	var dateTokens []dateToken
+	var dashes []int
+	rangeAt := -1
+	for _, token := range tokens {
+		if modifier, ok := modifierWords[token.text]; ok {
+			if date.Modifier == fs_data.FSDateModifier_EXACT {
+				date.Modifier = modifier
+			}
+		} else if token.text == "-" {
+			dashes = append(dashes, len(dateTokens))
+		} else if rangeWords[token.text] {
+			if rangeAt < 0 {
+				rangeAt = len(dateTokens)
+			}
+		} else {
+			dateTokens = append(dateTokens, token)
+		}
+	}
+	for _, dash := range dashes {
+		if rangeAt >= 0 {
+			break
+		}
+		left, right := dateTokens[:dash], dateTokens[dash:]
+		if (hasYear(left) && hasYear(right)) || isShortYearRange(left, right) {
+			rangeAt = dash
+		}
+	}
+
+	start, end := dateTokens, []dateToken(nil)
+	if rangeAt >= 0 {
+		start, end = dateTokens[:rangeAt], dateTokens[rangeAt:]
+	}
+	date.Year, date.Month, date.Day = parseSimpleDate(start)
+
+	if len(end) > 0 {
+		date.EndYear, date.EndMonth, date.EndDay = parseSimpleDate(end)
+		if isShortYearRange(start, end) {
+			// 1850-60
+			date.EndYear = date.Year - date.Year%100 + atoi(end[0].text)
+		}
+		if date.EndYear == 0 || date.EndYear < date.Year {
+			date.EndYear, date.EndMonth, date.EndDay = 0, 0, 0
+		}
+	}
+
+	if date.EndYear != 0 {
+		date.Modifier = fs_data.FSDateModifier_BETWEEN
+	} else if date.Modifier == fs_data.FSDateModifier_BETWEEN {
+		// "from 1850" without an end
+		date.Modifier = fs_data.FSDateModifier_AFTER
+	}
+	if date.Year == 0 && date.Month == 0 {
+		date.Modifier = fs_data.FSDateModifier_EXACT
+	}
+	return
+}
+
+// SetFact sets the date fields of a fact, leaving unknown components unset
+func (date Date) SetFact(fsFact *fs_data.FSFact) {
+	if date.Year != 0 {
+		fsFact.Year = &date.Year
+	}
+	if date.Month != 0 {
+		fsFact.Month = &date.Month
+	}
+	if date.Day != 0 {
+		fsFact.Day = &date.Day
+	}
+	if date.Modifier != fs_data.FSDateModifier_EXACT {
+		fsFact.Modifier = &date.Modifier
+	}
+	if date.EndYear != 0 {
+		fsFact.EndYear = &date.EndYear
+	}
+	if date.EndMonth != 0 {
+		fsFact.EndMonth = &date.EndMonth
+	}
+	if date.EndDay != 0 {
+		fsFact.EndDay = &date.EndDay
+	}
+}
+
+// FromFact returns the date of a fact, the reverse of SetFact
+func FromFact(fsFact *fs_data.FSFact) Date {
+	return Date{
+		Year:     fsFact.GetYear(),
+		Month:    fsFact.GetMonth(),
+		Day:      fsFact.GetDay(),
+		Modifier: fsFact.GetModifier(),
+		EndYear:  fsFact.GetEndYear(),
+		EndMonth: fsFact.GetEndMonth(),
+		EndDay:   fsFact.GetEndDay(),
+	}
+}
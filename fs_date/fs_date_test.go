@@ -0,0 +1,127 @@
+package fs_date
+
+import (
+	"github.com/rootsdev/fsbff/fs_data"
+	"testing"
+)
+
+func TestParseDate(t *testing.T) {
+	const (
+		about      = fs_data.FSDateModifier_ABOUT
+		before     = fs_data.FSDateModifier_BEFORE
+		after      = fs_data.FSDateModifier_AFTER
+		between    = fs_data.FSDateModifier_BETWEEN
+		calculated = fs_data.FSDateModifier_CALCULATED
+	)
+	var tests = []struct {
+		in  string
+		out Date
+	}{
+		{"", Date{}},
+		{"unknown", Date{}},
+		{"Deceased", Date{}},
+		{"1880", Date{Year: 1880}},
+		{" 1880 ", Date{Year: 1880}},
+		{"1880s", Date{Year: 1880}},
+		{"12345", Date{}},
+
+		// day month year
+		{"25 April 1888", Date{Year: 1888, Month: 4, Day: 25}},
+		{"25 Apr 1888", Date{Year: 1888, Month: 4, Day: 25}},
+		{"25Apr1888", Date{Year: 1888, Month: 4, Day: 25}},
+		{"25-Apr-1888", Date{Year: 1888, Month: 4, Day: 25}},
+		{"25 APR. 1888", Date{Year: 1888, Month: 4, Day: 25}},
+		{"25th April 1888", Date{Year: 1888, Month: 4, Day: 25}},
+		{"April 25, 1888", Date{Year: 1888, Month: 4, Day: 25}},
+		{"Apr 1888", Date{Year: 1888, Month: 4}},
+		{"Sept 1888", Date{Year: 1888, Month: 9}},
+		{"9 Sep 1888", Date{Year: 1888, Month: 9, Day: 9}},
+		{"1 Mar 1900", Date{Year: 1900, Month: 3, Day: 1}},
+		{"31 May 1900", Date{Year: 1900, Month: 5, Day: 31}},
+		{"31 June 1900", Date{Year: 1900, Month: 6}},
+		{"29 Feb 1904", Date{Year: 1904, Month: 2, Day: 29}},
+		{"30 Feb 1904", Date{Year: 1904, Month: 2}},
+		{"June", Date{Month: 6}},
+		{"Ma 1900", Date{Year: 1900}},
+
+		// two-digit years are ambiguous
+		{"25Apr18", Date{Month: 4, Day: 25}},
+		{"Apr 18", Date{Month: 4, Day: 18}},
+
+		// numeric dates
+		{"3/24/2010", Date{Year: 2010, Month: 3, Day: 24}},
+		{"24/3/2010", Date{Year: 2010, Month: 3, Day: 24}},
+		{"3/4/2010", Date{Year: 2010, Month: 3, Day: 4}},
+		{"24.3.2010", Date{Year: 2010, Month: 3, Day: 24}},
+		{"1880-04-25", Date{Year: 1880, Month: 4, Day: 25}},
+		{"4/1880", Date{Year: 1880, Month: 4}},
+		{"13/1880", Date{Year: 1880}},
+		{"32/13/1880", Date{Year: 1880}},
+
+		// modifiers
+		{"Abt 1880", Date{Year: 1880, Modifier: about}},
+		{"Abt. 1880", Date{Year: 1880, Modifier: about}},
+		{"about 1880", Date{Year: 1880, Modifier: about}},
+		{"ABOUT 1880", Date{Year: 1880, Modifier: about}},
+		{"c. 1880", Date{Year: 1880, Modifier: about}},
+		{"ca 1880", Date{Year: 1880, Modifier: about}},
+		{"circa 1880", Date{Year: 1880, Modifier: about}},
+		{"~1880", Date{Year: 1880, Modifier: about}},
+		{"Est 1880", Date{Year: 1880, Modifier: about}},
+		{"Abt Apr 1880", Date{Year: 1880, Month: 4, Modifier: about}},
+		{"Bef 1700", Date{Year: 1700, Modifier: before}},
+		{"before 12 Mar 1700", Date{Year: 1700, Month: 3, Day: 12, Modifier: before}},
+		{"<1700", Date{Year: 1700, Modifier: before}},
+		{"Aft 1700", Date{Year: 1700, Modifier: after}},
+		{"after 1700", Date{Year: 1700, Modifier: after}},
+		{">1700", Date{Year: 1700, Modifier: after}},
+		{"from 1850", Date{Year: 1850, Modifier: after}},
+		{"Cal 1850", Date{Year: 1850, Modifier: calculated}},
+		{"calculated 1850", Date{Year: 1850, Modifier: calculated}},
+		{"Abt", Date{}},
+
+		// ranges
+		{"1850-1860", Date{Year: 1850, Modifier: between, EndYear: 1860}},
+		{"1850 - 1860", Date{Year: 1850, Modifier: between, EndYear: 1860}},
+		{"1850-60", Date{Year: 1850, Modifier: between, EndYear: 1860}},
+		{"1850-40", Date{Year: 1850}},
+		{"1860-1850", Date{Year: 1860}},
+		{"Bet 1850 and 1860", Date{Year: 1850, Modifier: between, EndYear: 1860}},
+		{"Bet. 1850 & 1860", Date{Year: 1850, Modifier: between, EndYear: 1860}},
+		{"between 1850 and 1860", Date{Year: 1850, Modifier: between, EndYear: 1860}},
+		{"from 1850 to 1860", Date{Year: 1850, Modifier: between, EndYear: 1860}},
+		{"1850 to 1860", Date{Year: 1850, Modifier: between, EndYear: 1860}},
+		{"Abt 1850-1860", Date{Year: 1850, Modifier: between, EndYear: 1860}},
+		{"Bet Mar 1850 and 5 Apr 1851", Date{Year: 1850, Month: 3, Modifier: between, EndYear: 1851, EndMonth: 4, EndDay: 5}},
+		{"1 Jan 1850 - 31 Dec 1850", Date{Year: 1850, Month: 1, Day: 1, Modifier: between, EndYear: 1850, EndMonth: 12, EndDay: 31}},
+		{"Bet 1850 and", Date{Year: 1850, Modifier: after}},
+		{"Bet 1850 and unknown", Date{Year: 1850, Modifier: after}},
+
+		// GEDCOM dates
+		{"ABT 1850", Date{Year: 1850, Modifier: about}},
+		{"BET 1850 AND 1860", Date{Year: 1850, Modifier: between, EndYear: 1860}},
+		{"FROM 12 MAR 1850 TO 1860", Date{Year: 1850, Month: 3, Day: 12, Modifier: between, EndYear: 1860}},
+		{"@#DJULIAN@ 1 JAN 1700", Date{Year: 1700, Month: 1, Day: 1}},
+	}
+	for _, test := range tests {
+		actual := Parse(test.in)
+		if actual != test.out {
+			t.Errorf("Parse(%q) = %+v; want %+v", test.in, actual, test.out)
+		}
+	}
+}
+
+func TestFact(t *testing.T) {
+	for _, in := range []string{"1850", "Abt 1850", "Bet Mar 1850 and 1851"} {
+		date := Parse(in)
+		fsFact := &fs_data.FSFact{}
+		date.SetFact(fsFact)
+		if (fsFact.Modifier == nil) != (date.Modifier == fs_data.FSDateModifier_EXACT) ||
+			fsFact.GetModifier() != date.Modifier || FromFact(fsFact) != date {
+			t.Errorf("FromFact(SetFact(%q)) = %+v modifier %v; want %+v", in, FromFact(fsFact), fsFact.Modifier, date)
+		}
+	}
+	if modifier := (&fs_data.FSFact{}).GetModifier(); modifier != fs_data.FSDateModifier_EXACT {
+		t.Errorf("GetModifier() without a modifier = %v; want EXACT", modifier)
+	}
+}
@@ -0,0 +1,240 @@
+/*
+Package fs_extsort sorts more lines than fit in memory.
+
+Lines are buffered in memory and written to temporary files as sorted runs whenever the buffer fills;
+Sort merges the runs. Lines are compared as plain strings and must not contain newlines.
+
+	sorter := fs_extsort.New(tmpDir, 1000000)
+	defer sorter.Close()
+	for ... {
+		if err := sorter.Add(line); err != nil {
+			return err
+		}
+	}
+	r, err := sorter.Sort()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	for r.Next() {
+		line := r.Line()
+		...
+	}
+	return r.Err()
+*/
+package fs_extsort
+
+import (
+	"bufio"
+	"container/heap"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Sorter collects lines to be sorted; it is not safe for concurrent use
+type Sorter struct {
+	tmpDir   string
+	maxLines int
+	lines    []string
+	runs     []string
+}
+
+// New returns a Sorter that writes runs of at most maxLines lines to tmpDir (or the default temporary directory if empty)
+func New(tmpDir string, maxLines int) *Sorter {
+	if maxLines < 1 {
+		maxLines = 1
+	}
+	return &Sorter{tmpDir: tmpDir, maxLines: maxLines}
+}
+
+// Add adds a line, writing a sorted run if the buffer is full
+func (s *Sorter) Add(line string) error {
+	s.lines = append(s.lines, line)
+	if len(s.lines) >= s.maxLines {
+		return s.writeRun()
+	}
+	return nil
+}
+
+func (s *Sorter) writeRun() error {
+	sort.Strings(s.lines)
+	file, err := ioutil.TempFile(s.tmpDir, "extsort")
+	if err != nil {
+		return err
+	}
+	s.runs = append(s.runs, file.Name())
+	w := bufio.NewWriter(file)
+	for _, line := range s.lines {
+		if _, err = w.WriteString(line + "\n"); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	s.lines = s.lines[:0]
+	return err
+}
+
+// Sort returns a Reader over all of the lines added so far, in sorted order.
+// The Reader takes over the temporary files, so the Sorter should not be used afterwards.
+func (s *Sorter) Sort() (*Reader, error) {
+	r := &Reader{}
+	if len(s.runs) == 0 {
+		sort.Strings(s.lines)
+		r.lines = s.lines
+		s.lines = nil
+		return r, nil
+	}
+
+	if len(s.lines) > 0 {
+		if err := s.writeRun(); err != nil {
+			return nil, err
+		}
+	}
+	r.runFilenames = s.runs
+	s.runs = nil
+	for _, filename := range r.runFilenames {
+		file, err := os.Open(filename)
+		if err != nil {
+			r.Close()
+			return nil, err
+		}
+		run := &run{file: file, r: bufio.NewReader(file)}
+		r.runs = append(r.runs, run)
+		if run.next() {
+			r.heap = append(r.heap, run)
+		} else if run.err != nil {
+			r.Close()
+			return nil, run.err
+		}
+	}
+	heap.Init(&r.heap)
+	return r, nil
+}
+
+// Close removes any temporary files that have not been handed to a Reader
+func (s *Sorter) Close() error {
+	var err error
+	for _, filename := range s.runs {
+		if removeErr := os.Remove(filename); err == nil {
+			err = removeErr
+		}
+	}
+	s.runs = nil
+	s.lines = nil
+	return err
+}
+
+// run is a sorted temporary file being merged
+type run struct {
+	file *os.File
+	r    *bufio.Reader
+	line string
+	err  error
+}
+
+func (r *run) next() bool {
+	line, err := r.r.ReadString('\n')
+	if err == io.EOF && line == "" {
+		return false
+	}
+	if err != nil && err != io.EOF {
+		r.err = err
+		return false
+	}
+	r.line = strings.TrimSuffix(line, "\n")
+	return true
+}
+
+type runHeap []*run
+
+func (h runHeap) Len() int            { return len(h) }
+func (h runHeap) Less(i, j int) bool  { return h[i].line < h[j].line }
+func (h runHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *runHeap) Push(x interface{}) { *h = append(*h, x.(*run)) }
+func (h *runHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// Reader returns sorted lines
+type Reader struct {
+	lines        []string
+	pos          int
+	runFilenames []string
+	runs         []*run
+	heap         runHeap
+	current      *run
+	line         string
+	err          error
+}
+
+// Next advances to the next line, returning false at the end of the lines or on error
+func (r *Reader) Next() bool {
+	if r.err != nil {
+		return false
+	}
+	if r.runs == nil {
+		if r.pos >= len(r.lines) {
+			return false
+		}
+		r.line = r.lines[r.pos]
+		r.pos++
+		return true
+	}
+
+	// advance the run that returned the previous line before taking the smallest
+	if r.current != nil {
+		if r.current.next() {
+			heap.Push(&r.heap, r.current)
+		} else if r.current.err != nil {
+			r.err = r.current.err
+			return false
+		}
+		r.current = nil
+	}
+	if len(r.heap) == 0 {
+		return false
+	}
+	r.current = heap.Pop(&r.heap).(*run)
+	r.line = r.current.line
+	return true
+}
+
+// Line returns the current line
+func (r *Reader) Line() string {
+	return r.line
+}
+
+// Err returns the first error encountered while reading
+func (r *Reader) Err() error {
+	return r.err
+}
+
+// Close closes and removes the temporary files
+func (r *Reader) Close() error {
+	var err error
+	for _, run := range r.runs {
+		run.file.Close()
+	}
+	for _, filename := range r.runFilenames {
+		if removeErr := os.Remove(filename); err == nil {
+			err = removeErr
+		}
+	}
+	r.runs = nil
+	r.runFilenames = nil
+	r.heap = nil
+	r.lines = nil
+	r.current = nil
+	return err
+}
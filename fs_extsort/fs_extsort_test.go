@@ -0,0 +1,64 @@
+package fs_extsort
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestSort(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fs_extsort")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var tests = []struct {
+		maxLines int
+		in       string
+		out      string
+	}{
+		{10, "", ""},
+		{10, "c,a,b", "a,b,c"},
+		{2, "c,a,b", "a,b,c"},
+		{1, "b,a,b,,a", ",a,a,b,b"},
+		{3, "k,j,i,h,g,f,e,d,c,b,a", "a,b,c,d,e,f,g,h,i,j,k"},
+		{4, "id2\t03,id1\t01,id2\t01,id10\t05", "id1\t01,id10\t05,id2\t01,id2\t03"},
+	}
+	for _, test := range tests {
+		sorter := New(dir, test.maxLines)
+		if test.in != "" {
+			for _, line := range strings.Split(test.in, ",") {
+				if err = sorter.Add(line); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		r, err := sorter.Sort()
+		if err != nil {
+			t.Errorf("Sort(%d, %q) error %v", test.maxLines, test.in, err)
+			continue
+		}
+		var lines []string
+		for r.Next() {
+			lines = append(lines, r.Line())
+		}
+		if r.Err() != nil || strings.Join(lines, ",") != test.out {
+			t.Errorf("Sort(%d, %q) = %q err %v; want %q", test.maxLines, test.in, strings.Join(lines, ","), r.Err(), test.out)
+		}
+		if err = r.Close(); err != nil {
+			t.Errorf("Close(%d, %q) error %v", test.maxLines, test.in, err)
+		}
+	}
+
+	sorter := New(dir, 2)
+	for i := 0; i < 5; i++ {
+		sorter.Add(fmt.Sprint(i))
+	}
+	sorter.Close()
+	if fileInfos, _ := ioutil.ReadDir(dir); len(fileInfos) != 0 {
+		t.Errorf("%d temporary files left; want 0", len(fileInfos))
+	}
+}
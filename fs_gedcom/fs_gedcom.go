@@ -0,0 +1,122 @@
+/*
+Package fs_gedcom holds the GEDCOM vocabulary shared by gedcom2protobuf and protobuf2gedcom:
+the mapping between GEDCOM tags and the GEDCOM X fact and name types that fsxml2protobuf writes,
+and a writer for GEDCOM lines.
+
+	w := fs_gedcom.NewWriter(out)
+	w.Line(0, "I1", "INDI", "")
+	w.Line(1, "", fs_gedcom.FactTag("Birth", false), "")
+	w.Line(2, "", "DATE", fs_gedcom.FormatDate(fs_date.FromFact(fsFact)))
+	if err := w.Flush(); err != nil {
+		...
+	}
+*/
+package fs_gedcom
+
+// PersonFactTypes maps individual event and attribute tags to fact types
+var PersonFactTypes = map[string]string{
+	"ADOP":  "Adoption",
+	"BAPM":  "Baptism",
+	"BARM":  "BarMitzvah",
+	"BASM":  "BatMitzvah",
+	"BIRT":  "Birth",
+	"BLES":  "Blessing",
+	"BURI":  "Burial",
+	"CAST":  "Caste",
+	"CENS":  "Census",
+	"CHR":   "Christening",
+	"CHRA":  "AdultChristening",
+	"CONF":  "Confirmation",
+	"CREM":  "Cremation",
+	"DEAT":  "Death",
+	"DSCR":  "PhysicalDescription",
+	"EDUC":  "Education",
+	"EMIG":  "Emigration",
+	"FCOM":  "FirstCommunion",
+	"GRAD":  "Education",
+	"IDNO":  "NationalId",
+	"IMMI":  "Immigration",
+	"NATI":  "Nationality",
+	"NATU":  "Naturalization",
+	"NCHI":  "NumberOfChildren",
+	"NMR":   "NumberOfMarriages",
+	"OCCU":  "Occupation",
+	"ORDN":  "Ordination",
+	"PROB":  "Probate",
+	"PROP":  "Property",
+	"RELI":  "Religion",
+	"RESI":  "Residence",
+	"RETI":  "Retirement",
+	"SSN":   "NationalId",
+	"WILL":  "Will",
+	"_MILT": "MilitaryService",
+}
+
+// FamilyFactTypes maps family event tags to fact types of the couple relationship
+var FamilyFactTypes = map[string]string{
+	"ANUL": "Annulment",
+	"DIV":  "Divorce",
+	"DIVF": "DivorceFiling",
+	"ENGA": "Engagement",
+	"MARB": "MarriageBanns",
+	"MARC": "MarriageContract",
+	"MARL": "MarriageLicense",
+	"MARR": "Marriage",
+}
+
+// NameTypes maps GEDCOM name types to name types
+var NameTypes = map[string]string{
+	"aka":       "AlsoKnownAs",
+	"birth":     "BirthName",
+	"maiden":    "BirthName",
+	"married":   "MarriedName",
+	"nickname":  "Nickname",
+	"adopted":   "AdoptiveName",
+	"formal":    "FormalName",
+	"religious": "ReligiousName",
+}
+
+// AttributeTags are the tags whose value is the value of the fact rather than Y
+var AttributeTags = map[string]bool{
+	"CAST": true,
+	"DSCR": true,
+	"EDUC": true,
+	"IDNO": true,
+	"NATI": true,
+	"NCHI": true,
+	"NMR":  true,
+	"OCCU": true,
+	"PROP": true,
+	"RELI": true,
+	"SSN":  true,
+}
+
+// tags to write for types that more than one tag maps to
+var preferredTags = map[string]bool{"EDUC": true, "IDNO": true, "birth": true}
+
+var personFactTags, familyFactTags, nameTags = reverse(PersonFactTypes), reverse(FamilyFactTypes), reverse(NameTypes)
+
+func reverse(types map[string]string) map[string]string {
+	tags := make(map[string]string, len(types))
+	for tag, t := range types {
+		if _, ok := tags[t]; ok && !preferredTags[tag] {
+			continue
+		}
+		tags[t] = tag
+	}
+	return tags
+}
+
+// FactTag returns the tag for a fact type of a person, or of a couple if family is set,
+// or "" if the fact must be written as an EVEN with a TYPE
+func FactTag(factType string, family bool) string {
+	if family {
+		return familyFactTags[factType]
+	}
+	return personFactTags[factType]
+}
+
+// NameType returns the GEDCOM TYPE of a name type, or "" if there is none
+func NameType(nameType string) string {
+	return nameTags[nameType]
+}
@@ -0,0 +1,84 @@
+package fs_gedcom
+
+import (
+	"bytes"
+	"github.com/rootsdev/fsbff/fs_data"
+	"github.com/rootsdev/fsbff/fs_date"
+	"strings"
+	"testing"
+)
+
+func TestFactTag(t *testing.T) {
+	var tests = []struct {
+		factType string
+		family   bool
+		tag      string
+	}{
+		{"Birth", false, "BIRT"},
+		{"Education", false, "EDUC"},
+		{"NationalId", false, "IDNO"},
+		{"MilitaryService", false, "_MILT"},
+		{"Marriage", true, "MARR"},
+		{"Marriage", false, ""},
+		{"Birth", true, ""},
+		{"OTHER", false, ""},
+	}
+	for _, test := range tests {
+		if tag := FactTag(test.factType, test.family); tag != test.tag {
+			t.Errorf("FactTag(%q, %v) = %q; want %q", test.factType, test.family, tag, test.tag)
+		}
+	}
+	if nameType := NameType("BirthName"); nameType != "birth" {
+		t.Errorf("NameType(%q) = %q; want %q", "BirthName", nameType, "birth")
+	}
+}
+
+func TestFormatDate(t *testing.T) {
+	var tests = []struct {
+		in  fs_date.Date
+		out string
+	}{
+		{fs_date.Date{}, ""},
+		{fs_date.Date{Month: 4, Day: 25}, ""},
+		{fs_date.Date{Year: 1888}, "1888"},
+		{fs_date.Date{Year: 1888, Month: 4}, "APR 1888"},
+		{fs_date.Date{Year: 1888, Month: 4, Day: 25}, "25 APR 1888"},
+		{fs_date.Date{Year: 1880, Modifier: fs_data.FSDateModifier_ABOUT}, "ABT 1880"},
+		{fs_date.Date{Year: 1880, Modifier: fs_data.FSDateModifier_BEFORE}, "BEF 1880"},
+		{fs_date.Date{Year: 1880, Modifier: fs_data.FSDateModifier_AFTER}, "AFT 1880"},
+		{fs_date.Date{Year: 1880, Modifier: fs_data.FSDateModifier_CALCULATED}, "CAL 1880"},
+		{fs_date.Date{Year: 1850, Modifier: fs_data.FSDateModifier_BETWEEN, EndYear: 1860, EndMonth: 6},
+			"BET 1850 AND JUN 1860"},
+		{fs_date.Date{Year: 1850, Modifier: fs_data.FSDateModifier_BETWEEN}, "1850"},
+	}
+	for _, test := range tests {
+		if out := FormatDate(test.in); out != test.out {
+			t.Errorf("FormatDate(%v) = %q; want %q", test.in, out, test.out)
+		}
+	}
+}
+
+func TestWriter(t *testing.T) {
+	long := strings.Repeat("abcd ", 50) // 250 characters
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.Line(0, "I1", "INDI", "")
+	w.Line(1, "", "NOTE", "first\nsecond")
+	w.Line(1, "", "NOTE", long+"\n"+long)
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	// splits aren't next to a space
+	part1, part2 := long[:198], long[198:]
+	want := "0 @I1@ INDI\n" +
+		"1 NOTE first\n" +
+		"2 CONT second\n" +
+		"1 NOTE " + part1 + "\n" +
+		"2 CONC " + part2 + "\n" +
+		"2 CONT " + part1 + "\n" +
+		"2 CONC " + part2 + "\n"
+	if buf.String() != want {
+		t.Errorf("Writer wrote\n%s\nwant\n%s", buf.String(), want)
+	}
+}
@@ -0,0 +1,119 @@
+package fs_gedcom
+
+import (
+	"bufio"
+	"github.com/rootsdev/fsbff/fs_data"
+	"github.com/rootsdev/fsbff/fs_date"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxValueLen is the longest value written on one line; GEDCOM lines are limited to 255 characters
+const maxValueLen = 200
+
+// Writer writes GEDCOM lines. Once a write fails, later writes do nothing and Flush returns the error.
+type Writer struct {
+	w   *bufio.Writer
+	err error
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: bufio.NewWriter(w)}
+}
+
+// Line writes a line with an optional xref (without the @s) and value.
+// Values with newlines are continued on CONT lines and values too long for one line on CONC lines.
+func (w *Writer) Line(level int, xref, tag, value string) {
+	lineLevel, lineTag := level, tag
+	for i, text := range strings.Split(value, "\n") {
+		if i > 0 {
+			lineLevel, xref, lineTag = level+1, "", "CONT"
+		}
+		for len(text) > maxValueLen {
+			n := concSplit(text)
+			w.writeLine(lineLevel, xref, lineTag, text[:n])
+			text = text[n:]
+			lineLevel, xref, lineTag = level+1, "", "CONC"
+		}
+		w.writeLine(lineLevel, xref, lineTag, text)
+	}
+}
+
+// concSplit returns where to split a long value: not inside a character and not next to a space,
+// because some readers trim the values of CONC lines
+func concSplit(text string) int {
+	for n := maxValueLen; n > 0; n-- {
+		if utf8.RuneStart(text[n]) && text[n] != ' ' && text[n-1] != ' ' {
+			return n
+		}
+	}
+	n := maxValueLen
+	for !utf8.RuneStart(text[n]) {
+		n--
+	}
+	return n
+}
+
+func (w *Writer) writeLine(level int, xref, tag, value string) {
+	if w.err != nil {
+		return
+	}
+	line := strconv.Itoa(level)
+	if xref != "" {
+		line += " @" + xref + "@"
+	}
+	line += " " + tag
+	if value != "" {
+		line += " " + value
+	}
+	_, w.err = w.w.WriteString(line + "\n")
+}
+
+// Flush writes any buffered lines and returns the first error
+func (w *Writer) Flush() error {
+	if w.err != nil {
+		return w.err
+	}
+	return w.w.Flush()
+}
+
+var monthTags = []string{"JAN", "FEB", "MAR", "APR", "MAY", "JUN", "JUL", "AUG", "SEP", "OCT", "NOV", "DEC"}
+
+var modifierTags = map[fs_data.FSDateModifier]string{
+	fs_data.FSDateModifier_ABOUT:      "ABT ",
+	fs_data.FSDateModifier_BEFORE:     "BEF ",
+	fs_data.FSDateModifier_AFTER:      "AFT ",
+	fs_data.FSDateModifier_CALCULATED: "CAL ",
+}
+
+func formatSimpleDate(year, month, day int32) string {
+	if year == 0 {
+		return ""
+	}
+	text := strconv.Itoa(int(year))
+	if month >= 1 && month <= 12 {
+		text = monthTags[month-1] + " " + text
+		if day > 0 {
+			text = strconv.Itoa(int(day)) + " " + text
+		}
+	}
+	return text
+}
+
+// FormatDate returns a date as a GEDCOM date value like "ABT 1850" or "BET 12 JUN 1875 AND 1880",
+// or "" if the year is unknown
+func FormatDate(date fs_date.Date) string {
+	start := formatSimpleDate(date.Year, date.Month, date.Day)
+	if start == "" {
+		return ""
+	}
+	if date.Modifier == fs_data.FSDateModifier_BETWEEN {
+		if end := formatSimpleDate(date.EndYear, date.EndMonth, date.EndDay); end != "" {
+			return "BET " + start + " AND " + end
+		}
+		return start
+	}
+	return modifierTags[date.Modifier] + start
+}
@@ -0,0 +1,309 @@
+/*
+Package fs_index is an on-disk index of key/value pairs, for lookups that would otherwise need a map
+larger than memory, like the source references of every person.
+
+An index file holds the pairs as sorted "key\tvalue" lines, followed by a sparse index of the first key
+and offset of each block of lines, and a footer locating the sparse index. Open reads only the sparse
+index; Lookup binary searches it and reads the lines of the blocks that can hold the key.
+
+	b := fs_index.NewBuilder(tmpDir, 1000000)
+	defer b.Close()
+	for ... {
+		if err := b.Add(key, value); err != nil {
+			return err
+		}
+	}
+	if err := b.Write(filename); err != nil {
+		return err
+	}
+
+	index, err := fs_index.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer index.Close()
+	values, err := index.Lookup(key)
+*/
+package fs_index
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/rootsdev/fsbff/fs_extsort"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const magic = "FSINDEX1"
+
+// footer: offset of the sparse index, number of pairs, magic
+const footerSize = 8 + 8 + len(magic)
+
+// blockSize is the number of bytes of lines between sparse index entries
+var blockSize int64 = 32 * 1024
+
+// Builder collects the pairs of an index; it is not safe for concurrent use
+type Builder struct {
+	sorter *fs_extsort.Sorter
+}
+
+// NewBuilder returns a Builder that sorts at most maxLines pairs in memory at a time, writing sorted runs
+// to tmpDir (or the default temporary directory if empty)
+func NewBuilder(tmpDir string, maxLines int) *Builder {
+	return &Builder{sorter: fs_extsort.New(tmpDir, maxLines)}
+}
+
+// Add adds a pair; keys can't contain tabs or newlines, and values can't contain newlines
+func (b *Builder) Add(key, value string) error {
+	if strings.ContainsAny(key, "\t\n") || strings.Contains(value, "\n") {
+		return fmt.Errorf("fs_index: invalid pair %q %q", key, value)
+	}
+	return b.sorter.Add(key + "\t" + value)
+}
+
+// Write writes the index file; the Builder should not be used afterwards
+func (b *Builder) Write(filename string) (err error) {
+	r, err := b.sorter.Sort()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			os.Remove(filename)
+		}
+	}()
+	w := bufio.NewWriter(file)
+
+	var offset, blockStart, pairs int64
+	var sparse []string
+	for r.Next() {
+		line := r.Line()
+		if pairs == 0 || offset-blockStart >= blockSize {
+			key := line[:strings.IndexByte(line, '\t')]
+			sparse = append(sparse, key+"\t"+strconv.FormatInt(offset, 10))
+			blockStart = offset
+		}
+		if _, err = w.WriteString(line + "\n"); err != nil {
+			return err
+		}
+		offset += int64(len(line)) + 1
+		pairs++
+	}
+	if err = r.Err(); err != nil {
+		return err
+	}
+
+	for _, entry := range sparse {
+		if _, err = w.WriteString(entry + "\n"); err != nil {
+			return err
+		}
+	}
+	footer := make([]byte, footerSize)
+	binary.BigEndian.PutUint64(footer, uint64(offset))
+	binary.BigEndian.PutUint64(footer[8:], uint64(pairs))
+	copy(footer[16:], magic)
+	if _, err = w.Write(footer); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// Close removes any temporary files
+func (b *Builder) Close() error {
+	return b.sorter.Close()
+}
+
+// Index is an open index file; it is safe for concurrent use
+type Index struct {
+	file         *os.File
+	dataSize     int64 // bytes of lines
+	pairs        int64
+	blockKeys    []string
+	blockOffsets []int64
+}
+
+// Open opens an index file and reads its sparse index
+func Open(filename string) (*Index, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	index, err := readSparseIndex(file)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("fs_index: %s: %v", filename, err)
+	}
+	return index, nil
+}
+
+func readSparseIndex(file *os.File) (*Index, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	size := info.Size()
+	if size < int64(footerSize) {
+		return nil, errors.New("not an index file")
+	}
+	footer := make([]byte, footerSize)
+	if _, err = file.ReadAt(footer, size-int64(footerSize)); err != nil {
+		return nil, err
+	}
+	if string(footer[16:]) != magic {
+		return nil, errors.New("not an index file")
+	}
+	index := &Index{
+		file:     file,
+		dataSize: int64(binary.BigEndian.Uint64(footer)),
+		pairs:    int64(binary.BigEndian.Uint64(footer[8:])),
+	}
+	sparseSize := size - int64(footerSize) - index.dataSize
+	if sparseSize < 0 {
+		return nil, errors.New("bad footer")
+	}
+
+	scanner := bufio.NewScanner(io.NewSectionReader(file, index.dataSize, sparseSize))
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		tab := strings.LastIndexByte(line, '\t')
+		if tab < 0 {
+			return nil, errors.New("bad sparse index")
+		}
+		offset, err := strconv.ParseInt(line[tab+1:], 10, 64)
+		if err != nil || offset < 0 || offset >= index.dataSize {
+			return nil, errors.New("bad sparse index")
+		}
+		index.blockKeys = append(index.blockKeys, line[:tab])
+		index.blockOffsets = append(index.blockOffsets, offset)
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+	return index, nil
+}
+
+// Len returns the number of pairs
+func (index *Index) Len() int64 {
+	return index.pairs
+}
+
+// Lookup returns the values of a key, in sorted order
+func (index *Index) Lookup(key string) ([]string, error) {
+	// the first block whose first key isn't less than the key; the key's lines can start in the block before it
+	i := sort.SearchStrings(index.blockKeys, key)
+	if i > 0 {
+		i--
+	}
+	if i >= len(index.blockOffsets) {
+		return nil, nil
+	}
+	offset := index.blockOffsets[i]
+	r := bufio.NewReader(io.NewSectionReader(index.file, offset, index.dataSize-offset))
+
+	var values []string
+	for {
+		line, err := r.ReadString('\n')
+		if err == io.EOF && line == "" {
+			break
+		}
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		line = strings.TrimSuffix(line, "\n")
+		tab := strings.IndexByte(line, '\t')
+		if tab < 0 {
+			return nil, errors.New("fs_index: bad line")
+		}
+		if k := line[:tab]; k == key {
+			values = append(values, line[tab+1:])
+		} else if k > key {
+			break
+		}
+	}
+	return values, nil
+}
+
+// ForEach calls fn with every pair in sorted order, stopping at the first error
+func (index *Index) ForEach(fn func(key, value string) error) error {
+	r := bufio.NewReader(io.NewSectionReader(index.file, 0, index.dataSize))
+	for {
+		line, err := r.ReadString('\n')
+		if err == io.EOF && line == "" {
+			return nil
+		}
+		if err != nil && err != io.EOF {
+			return err
+		}
+		line = strings.TrimSuffix(line, "\n")
+		tab := strings.IndexByte(line, '\t')
+		if tab < 0 {
+			return errors.New("fs_index: bad line")
+		}
+		if err = fn(line[:tab], line[tab+1:]); err != nil {
+			return err
+		}
+	}
+}
+
+// Verify reads the whole index, checking that the lines are sorted, that the sparse index points to the
+// first lines of blocks, and that the number of pairs matches the footer
+func (index *Index) Verify() error {
+	r := bufio.NewReader(io.NewSectionReader(index.file, 0, index.dataSize))
+	var offset, pairs int64
+	var prev string
+	block := 0
+	for {
+		line, err := r.ReadString('\n')
+		if err == io.EOF && line == "" {
+			break
+		}
+		if err != nil || !strings.HasSuffix(line, "\n") {
+			return fmt.Errorf("fs_index: truncated line at offset %d", offset)
+		}
+		line = line[:len(line)-1]
+		tab := strings.IndexByte(line, '\t')
+		if tab < 0 {
+			return fmt.Errorf("fs_index: line without a tab at offset %d", offset)
+		}
+		if pairs > 0 && line < prev {
+			return fmt.Errorf("fs_index: line out of order at offset %d", offset)
+		}
+		if block < len(index.blockOffsets) && index.blockOffsets[block] == offset {
+			if index.blockKeys[block] != line[:tab] {
+				return fmt.Errorf("fs_index: sparse index key %q doesn't match line at offset %d",
+					index.blockKeys[block], offset)
+			}
+			block++
+		}
+		prev = line
+		offset += int64(len(line)) + 1
+		pairs++
+	}
+	if block != len(index.blockOffsets) {
+		return fmt.Errorf("fs_index: sparse index entry %d doesn't point to the start of a line", block)
+	}
+	if pairs != index.pairs {
+		return fmt.Errorf("fs_index: %d pairs; footer says %d", pairs, index.pairs)
+	}
+	return nil
+}
+
+// Close closes the index file
+func (index *Index) Close() error {
+	return index.file.Close()
+}
@@ -0,0 +1,119 @@
+package fs_index
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestIndex(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fs_index")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(size int64) { blockSize = size }(blockSize)
+	blockSize = 20
+
+	filename := filepath.Join(dir, "index")
+	b := NewBuilder(dir, 3)
+	pairs := []string{"p2,s1", "p1,s2", "p10,s3", "p2,s4", "p1,s1", "p3,s5", "p2,s2", "p2,s3", "p4,", "p5,s5"}
+	for i := 0; i < 30; i++ {
+		pairs = append(pairs, fmt.Sprintf("q%02d,s%d", i, i))
+	}
+	for _, pair := range pairs {
+		fields := strings.SplitN(pair, ",", 2)
+		if err = b.Add(fields[0], fields[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = b.Add("bad\tkey", "s"); err == nil {
+		t.Errorf("Add(bad key) returned no error")
+	}
+	if err = b.Write(filename); err != nil {
+		t.Fatal(err)
+	}
+	b.Close()
+
+	index, err := Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer index.Close()
+	if len(index.blockKeys) < 5 {
+		t.Errorf("Open read %d blocks; want several", len(index.blockKeys))
+	}
+	if index.Len() != int64(len(pairs)) {
+		t.Errorf("Len() = %d; want %d", index.Len(), len(pairs))
+	}
+	if err = index.Verify(); err != nil {
+		t.Errorf("Verify() error %v", err)
+	}
+
+	var tests = []struct {
+		key    string
+		values string
+	}{
+		{"p1", "s1,s2"},
+		{"p2", "s1,s2,s3,s4"},
+		{"p10", "s3"},
+		{"p3", "s5"},
+		{"p4", ""},
+		{"p", ""},
+		{"a", ""},
+		{"p0", ""},
+		{"q00", "s0"},
+		{"q17", "s17"},
+		{"q29", "s29"},
+		{"z", ""},
+	}
+	for _, test := range tests {
+		values, err := index.Lookup(test.key)
+		if err != nil || strings.Join(values, ",") != test.values {
+			t.Errorf("Lookup(%q) = %q err %v; want %q", test.key, strings.Join(values, ","), err, test.values)
+		}
+	}
+	if values, _ := index.Lookup("p4"); len(values) != 1 {
+		t.Errorf("Lookup(%q) = %d values; want 1 empty value", "p4", len(values))
+	}
+
+	var keys []string
+	err = index.ForEach(func(key, value string) error {
+		keys = append(keys, key)
+		return nil
+	})
+	if err != nil || len(keys) != len(pairs) || keys[0] != "p1" || keys[len(keys)-1] != "q29" {
+		t.Errorf("ForEach() = %d keys %v err %v; want %d sorted keys", len(keys), keys, err, len(pairs))
+	}
+
+	// corrupt a line
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	corrupt := filepath.Join(dir, "corrupt")
+	if err = ioutil.WriteFile(corrupt, []byte(strings.Replace(string(data), "p3\ts5", "a3\ts5", 1)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	corruptIndex, err := Open(corrupt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer corruptIndex.Close()
+	if err = corruptIndex.Verify(); err == nil {
+		t.Errorf("Verify(corrupt) returned no error")
+	}
+
+	if _, err = Open(filepath.Join(dir, "corrupt") + "x"); err == nil {
+		t.Errorf("Open(missing) returned no error")
+	}
+	if err = ioutil.WriteFile(corrupt, []byte("p1\ts1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Open(corrupt); err == nil {
+		t.Errorf("Open(not an index) returned no error")
+	}
+}
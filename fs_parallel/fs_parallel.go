@@ -0,0 +1,193 @@
+/*
+Package fs_parallel processes a list of files with a pool of workers.
+
+Each file is handed to a map function running in one of the workers; the results are handed to a
+merge function running in the caller's goroutine, in the same order as the input files, so the merge
+function needs no locking and the output is deterministic regardless of the number of workers.
+
+	totals := make(map[string]int)
+	err := fs_parallel.Run(context.Background(), filenames, fs_parallel.Options{Workers: 4},
+		func(ctx context.Context, filename string) (interface{}, error) {
+			return countFile(filename)
+		},
+		func(filename string, result interface{}) error {
+			for k, v := range result.(map[string]int) {
+				totals[k] += v
+			}
+			return nil
+		})
+*/
+package fs_parallel
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// MapFunc processes a single file in a worker goroutine
+type MapFunc func(ctx context.Context, filename string) (interface{}, error)
+
+// MergeFunc merges the result of a single file; it is called from the caller's goroutine in input order
+type MergeFunc func(filename string, result interface{}) error
+
+// ProgressFunc is called after each file has been merged (or has failed); done counts from 1 to total
+type ProgressFunc func(done, total int, filename string, err error)
+
+// Options control how files are processed
+type Options struct {
+	Workers     int          // number of worker goroutines; defaults to 1
+	StopOnError bool         // stop processing at the first failed file instead of collecting errors
+	Progress    ProgressFunc // optional per-file progress callback
+}
+
+// FileError records a failure processing a single file
+type FileError struct {
+	Filename string
+	Err      error
+}
+
+func (e *FileError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Filename, e.Err)
+}
+
+// Errors is returned by Run when one or more files could not be processed
+type Errors []*FileError
+
+func (e Errors) Error() string {
+	messages := make([]string, 0, len(e))
+	for _, err := range e {
+		messages = append(messages, err.Error())
+	}
+	return fmt.Sprintf("%d files failed: %s", len(e), strings.Join(messages, "; "))
+}
+
+// SetMaxProcs limits GOMAXPROCS to the smaller of the number of CPUs and workers, and returns the number of CPUs
+func SetMaxProcs(workers int) int {
+	numCPU := runtime.NumCPU()
+	runtime.GOMAXPROCS(int(math.Max(1, math.Min(float64(numCPU), float64(workers)))))
+	return numCPU
+}
+
+// Dots returns a ProgressFunc that prints a dot every n files
+func Dots(n int) ProgressFunc {
+	return func(done, total int, filename string, err error) {
+		if (done-1)%n == 0 {
+			fmt.Print(".")
+		}
+	}
+}
+
+type job struct {
+	index    int
+	filename string
+}
+
+type result struct {
+	index int
+	value interface{}
+	err   error
+}
+
+// Run maps every file and merges the results in input order.
+// Files that fail to map are collected and returned as Errors once all files have been processed,
+// unless StopOnError is set. An error from mergeFn stops processing and is returned immediately.
+// Cancelling ctx stops handing out new files; files already being mapped see the cancelled context.
+func Run(ctx context.Context, filenames []string, options Options, mapFn MapFunc, mergeFn MergeFunc) error {
+	workers := options.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	jobs := make(chan job)
+	results := make(chan result, workers)
+	// bound the number of results waiting for an earlier, slower file to be merged
+	window := make(chan bool, workers*4)
+
+	go func() {
+		defer close(jobs)
+		for i, filename := range filenames {
+			select {
+			case window <- true:
+			case <-runCtx.Done():
+				return
+			}
+			select {
+			case jobs <- job{i, filename}:
+			case <-runCtx.Done():
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				value, err := mapFn(runCtx, j.filename)
+				results <- result{j.index, value, err}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	var errs Errors
+	var stopErr error
+	pending := make(map[int]result)
+	next := 0
+	for r := range results {
+		pending[r.index] = r
+		for {
+			r, found := pending[next]
+			if !found {
+				break
+			}
+			delete(pending, next)
+			filename := filenames[next]
+			next++
+			<-window
+
+			if stopErr != nil {
+				continue
+			}
+			err := r.err
+			if err != nil {
+				if runCtx.Err() != nil && err == runCtx.Err() {
+					continue
+				}
+				errs = append(errs, &FileError{filename, err})
+				if options.StopOnError {
+					stopErr = errs[len(errs)-1]
+					cancel()
+				}
+			} else if err = mergeFn(filename, r.value); err != nil {
+				stopErr = &FileError{filename, err}
+				cancel()
+			}
+			if options.Progress != nil {
+				options.Progress(next, len(filenames), filename, err)
+			}
+		}
+	}
+
+	if stopErr != nil {
+		return stopErr
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
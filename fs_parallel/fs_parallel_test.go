@@ -0,0 +1,103 @@
+package fs_parallel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func makeFilenames(n int) []string {
+	filenames := make([]string, n)
+	for i := range filenames {
+		filenames[i] = fmt.Sprintf("f%02d", i)
+	}
+	return filenames
+}
+
+// slowMap takes longer for earlier files so that results arrive out of order
+func slowMap(n int) MapFunc {
+	return func(ctx context.Context, filename string) (interface{}, error) {
+		var i int
+		fmt.Sscanf(filename, "f%d", &i)
+		time.Sleep(time.Duration(n-i) * time.Millisecond)
+		if strings.HasSuffix(filename, "3") {
+			return nil, errors.New("bad file")
+		}
+		return strings.ToUpper(filename), nil
+	}
+}
+
+func TestRunOrder(t *testing.T) {
+	for _, workers := range []int{0, 1, 3, 8} {
+		filenames := makeFilenames(20)
+		var merged []string
+		progress := 0
+		err := Run(context.Background(), filenames, Options{
+			Workers: workers,
+			Progress: func(done, total int, filename string, err error) {
+				progress++
+				if done != progress || total != len(filenames) || filename != filenames[done-1] {
+					t.Errorf("workers=%d progress(%d, %d, %s) out of order", workers, done, total, filename)
+				}
+			},
+		}, slowMap(len(filenames)), func(filename string, result interface{}) error {
+			merged = append(merged, result.(string))
+			return nil
+		})
+
+		want := "F00,F01,F02,F04,F05,F06,F07,F08,F09,F10,F11,F12,F14,F15,F16,F17,F18,F19"
+		if strings.Join(merged, ",") != want {
+			t.Errorf("workers=%d merged %v; want %s", workers, merged, want)
+		}
+		if progress != len(filenames) {
+			t.Errorf("workers=%d progress called %d times; want %d", workers, progress, len(filenames))
+		}
+		errs, ok := err.(Errors)
+		if !ok || len(errs) != 2 || errs[0].Filename != "f03" || errs[1].Filename != "f13" {
+			t.Errorf("workers=%d err %v; want errors for f03 and f13", workers, err)
+		}
+	}
+}
+
+func TestRunStop(t *testing.T) {
+	filenames := makeFilenames(20)
+	var merged []string
+	err := Run(context.Background(), filenames, Options{Workers: 4, StopOnError: true},
+		slowMap(len(filenames)), func(filename string, result interface{}) error {
+			merged = append(merged, result.(string))
+			return nil
+		})
+	if fileErr, ok := err.(*FileError); !ok || fileErr.Filename != "f03" {
+		t.Errorf("StopOnError err %v; want f03 error", err)
+	}
+	if strings.Join(merged, ",") != "F00,F01,F02" {
+		t.Errorf("StopOnError merged %v; want F00,F01,F02", merged)
+	}
+
+	merged = nil
+	err = Run(context.Background(), filenames, Options{Workers: 4},
+		slowMap(len(filenames)), func(filename string, result interface{}) error {
+			if filename == "f01" {
+				return errors.New("merge failed")
+			}
+			merged = append(merged, result.(string))
+			return nil
+		})
+	if fileErr, ok := err.(*FileError); !ok || fileErr.Filename != "f01" {
+		t.Errorf("merge err %v; want f01 error", err)
+	}
+	if strings.Join(merged, ",") != "F00" {
+		t.Errorf("merge error merged %v; want F00", merged)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = Run(ctx, filenames, Options{Workers: 4}, slowMap(len(filenames)),
+		func(filename string, result interface{}) error { return nil })
+	if err != context.Canceled {
+		t.Errorf("cancelled err %v; want %v", err, context.Canceled)
+	}
+}
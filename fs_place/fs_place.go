@@ -0,0 +1,367 @@
+/*
+Package fs_place standardizes user-entered place texts against the standardized places file, whose lines
+map a place text to its standardized name, like "Boston, Suffolk, Mass.\tBoston, Suffolk, Massachusetts, United States".
+Lines of the extended file go on with the standard place id, latitude, longitude and the types of the
+levels of the name, like "...\t12345\t42.36\t-71.06\tCity,County,State,Country"; places standardized
+to those names get the details as an FSPlace.
+A place text is standardized by the first of these that succeeds:
+  1. An exact lookup of the text
+  2. A lookup of the normalized text, which is lower case without punctuation, with abbreviations
+     expanded and words like "county" dropped, so "Boston, Suffolk Co., MASS" matches the example
+  3. A hierarchical match of the normalized levels against the levels of the standardized names:
+     the first level must match, and the remaining levels must match later levels in the same order,
+     so "Boston, Mass." and "Boston, MA, USA" match the example. Leading levels like street addresses
+     are dropped until something matches, as long as two levels remain. When several standardized
+     names match equally well the place is ambiguous and isn't standardized.
+Texts that can't be standardized are kept as they are, and passed to the matcher's Unmatched function.
+So are texts standardized only after dropping leading levels, since the dropped level may be a place
+missing from the standardized places file, like a town standardized to its county.
+
+	m := fs_place.NewMatcher(fs_place.LevelAbbreviations, fs_place.WordAbbreviations)
+	if err := fs_place.ReadStdPlaces(file, m); err != nil {
+		return err
+	}
+	name, details := m.Standardize("Boston, Mass.")
+*/
+package fs_place
+
+import (
+	"bufio"
+	"code.google.com/p/goprotobuf/proto"
+	"fmt"
+	"github.com/rootsdev/fsbff/fs_data"
+	"io"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// LevelAbbreviations are the default abbreviations expanded when they are a whole level of a place
+var LevelAbbreviations = map[string]string{
+	"al": "alabama", "ala": "alabama",
+	"ak": "alaska",
+	"az": "arizona", "ariz": "arizona",
+	"ar": "arkansas", "ark": "arkansas",
+	"ca": "california", "cal": "california", "calif": "california",
+	"co": "colorado", "colo": "colorado",
+	"ct": "connecticut", "conn": "connecticut",
+	"de": "delaware", "del": "delaware",
+	"dc": "district of columbia", "d c": "district of columbia",
+	"fl": "florida", "fla": "florida",
+	"ga": "georgia",
+	"hi": "hawaii",
+	"id": "idaho",
+	"il": "illinois", "ill": "illinois",
+	"in": "indiana", "ind": "indiana",
+	"ia": "iowa",
+	"ks": "kansas", "kan": "kansas", "kans": "kansas",
+	"ky": "kentucky",
+	"la": "louisiana",
+	"me": "maine",
+	"md": "maryland",
+	"ma": "massachusetts", "mass": "massachusetts",
+	"mi": "michigan", "mich": "michigan",
+	"mn": "minnesota", "minn": "minnesota",
+	"ms": "mississippi", "miss": "mississippi",
+	"mo": "missouri",
+	"mt": "montana", "mont": "montana",
+	"ne": "nebraska", "neb": "nebraska", "nebr": "nebraska",
+	"nv": "nevada", "nev": "nevada",
+	"nh": "new hampshire", "n h": "new hampshire",
+	"nj": "new jersey", "n j": "new jersey",
+	"nm": "new mexico", "n m": "new mexico",
+	"ny": "new york", "n y": "new york",
+	"nc": "north carolina", "n c": "north carolina",
+	"nd": "north dakota", "n d": "north dakota",
+	"oh": "ohio",
+	"ok": "oklahoma", "okla": "oklahoma",
+	"or": "oregon", "ore": "oregon",
+	"pa": "pennsylvania", "penn": "pennsylvania", "penna": "pennsylvania",
+	"ri": "rhode island", "r i": "rhode island",
+	"sc": "south carolina", "s c": "south carolina",
+	"sd": "south dakota", "s d": "south dakota",
+	"tn": "tennessee", "tenn": "tennessee",
+	"tx": "texas", "tex": "texas",
+	"ut": "utah",
+	"vt": "vermont",
+	"va": "virginia",
+	"wa": "washington", "wash": "washington",
+	"wv": "west virginia", "w va": "west virginia",
+	"wi": "wisconsin", "wis": "wisconsin",
+	"wy": "wyoming", "wyo": "wyoming",
+
+	"us": "united states", "u s": "united states", "usa": "united states", "u s a": "united states",
+	"uk": "united kingdom", "u k": "united kingdom",
+	"eng":  "england",
+	"scot": "scotland",
+	"can":  "canada",
+
+	"united states of america": "united states",
+}
+
+// WordAbbreviations are the default abbreviations expanded wherever they are a word of a place
+var WordAbbreviations = map[string]string{
+	"co":   "county",
+	"cnty": "county",
+	"twp":  "township",
+	"par":  "parish",
+	"st":   "saint",
+	"ste":  "sainte",
+	"ft":   "fort",
+	"mt":   "mount",
+	"pt":   "point",
+}
+
+// jurisdictionWords are dropped from levels, so "Suffolk County" matches "Suffolk". City and town are kept,
+// since they are part of names like "Salt Lake City", and dropping them would match the county instead.
+var jurisdictionWords = map[string]bool{
+	"county":   true,
+	"parish":   true,
+	"township": true,
+	"borough":  true,
+	"of":       true,
+}
+
+// stdPlace is a standardized place name and its normalized levels, smallest first
+type stdPlace struct {
+	name   string
+	levels []string
+}
+
+// Matcher standardizes place texts; it is safe for concurrent use once the standardized places are added
+type Matcher struct {
+	levelAbbreviations map[string]string
+	wordAbbreviations  map[string]string
+	exact              map[string]string
+	normalized         map[string]string
+	byFirstLevel       map[string][]*stdPlace // standardized places by their first level
+	seen               map[string]bool
+	details            map[string]*fs_data.FSPlace // details of standardized places from the extended file
+
+	// Unmatched, if set, is called with each non-blank place text that can't be standardized, or that is
+	// standardized only after dropping leading levels; it must be safe for concurrent use
+	Unmatched func(text string)
+}
+
+// NewMatcher returns a matcher without standardized places that expands the given abbreviations
+func NewMatcher(levelAbbreviations, wordAbbreviations map[string]string) *Matcher {
+	return &Matcher{
+		levelAbbreviations: levelAbbreviations,
+		wordAbbreviations:  wordAbbreviations,
+		exact:              make(map[string]string),
+		normalized:         make(map[string]string),
+		byFirstLevel:       make(map[string][]*stdPlace),
+		seen:               make(map[string]bool),
+		details:            make(map[string]*fs_data.FSPlace),
+	}
+}
+
+// splitWords returns the lower-case words of a text, dropping punctuation
+func splitWords(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// normalize returns the normalized levels of a place text, smallest first
+func (m *Matcher) normalize(text string) (levels []string) {
+	for _, level := range strings.Split(text, ",") {
+		words := splitWords(level)
+		if expansion, ok := m.levelAbbreviations[strings.Join(words, " ")]; ok {
+			words = strings.Fields(expansion)
+		}
+		var kept []string
+		for _, word := range words {
+			if expansion, ok := m.wordAbbreviations[word]; ok {
+				word = expansion
+			}
+			for _, w := range strings.Fields(word) {
+				if !jurisdictionWords[w] {
+					kept = append(kept, w)
+				}
+			}
+		}
+		if len(kept) > 0 {
+			levels = append(levels, strings.Join(kept, " "))
+		}
+	}
+	return levels
+}
+
+// add adds a line of the standardized places file
+func (m *Matcher) add(text, name string) {
+	m.exact[text] = name
+	if key := strings.Join(m.normalize(text), ","); key != "" && m.normalized[key] == "" {
+		m.normalized[key] = name
+	}
+	if m.seen[name] {
+		return
+	}
+	m.seen[name] = true
+	levels := m.normalize(name)
+	if len(levels) == 0 {
+		return
+	}
+	key := strings.Join(levels, ",")
+	if m.normalized[key] == "" {
+		m.normalized[key] = name
+	}
+	m.byFirstLevel[levels[0]] = append(m.byFirstLevel[levels[0]], &stdPlace{name: name, levels: levels})
+}
+
+// skippedLevels returns how many levels of the standardized place aren't matched by the levels,
+// or -1 if the levels don't match
+func skippedLevels(levels []string, place *stdPlace) int {
+	if levels[0] != place.levels[0] {
+		return -1
+	}
+	j := 1
+	for _, level := range levels[1:] {
+		for j < len(place.levels) && place.levels[j] != level {
+			j++
+		}
+		if j == len(place.levels) {
+			return -1
+		}
+		j++
+	}
+	return len(place.levels) - len(levels)
+}
+
+// matchLevels returns the standardized place that the levels match with the fewest skipped levels,
+// or "" if none does or several do
+func (m *Matcher) matchLevels(levels []string) string {
+	best, bestSkipped := "", -1
+	for _, place := range m.byFirstLevel[levels[0]] {
+		skipped := skippedLevels(levels, place)
+		if skipped < 0 {
+			continue
+		}
+		if bestSkipped < 0 || skipped < bestSkipped {
+			best, bestSkipped = place.name, skipped
+		} else if skipped == bestSkipped {
+			best = "" // ambiguous
+		}
+	}
+	return best
+}
+
+// match returns the standardized name of a place text and the number of leading levels dropped to match it,
+// or -1 if it doesn't match
+func (m *Matcher) match(text string) (string, int) {
+	if name, ok := m.exact[text]; ok {
+		return name, 0
+	}
+	levels := m.normalize(text)
+	if len(levels) == 0 {
+		return "", -1
+	}
+	if name := m.normalized[strings.Join(levels, ",")]; name != "" {
+		return name, 0
+	}
+	for start := 0; start == 0 || start <= len(levels)-2; start++ {
+		if name := m.matchLevels(levels[start:]); name != "" {
+			return name, start
+		}
+	}
+	return "", -1
+}
+
+// Standardize returns the standardized name of a place text and its details if there are any,
+// or the text itself if it can't be standardized
+func (m *Matcher) Standardize(text string) (string, *fs_data.FSPlace) {
+	if name, dropped := m.match(text); dropped >= 0 {
+		if dropped > 0 && m.Unmatched != nil {
+			m.Unmatched(text)
+		}
+		return name, m.details[name]
+	}
+	if m.Unmatched != nil && strings.TrimSpace(text) != "" {
+		m.Unmatched(text)
+	}
+	return text, nil
+}
+
+// getPlaceDetails returns the details of a standardized place from the extra fields of an extended
+// standardized places line: id, latitude, longitude and level types
+func getPlaceDetails(name string, fields []string) (*fs_data.FSPlace, error) {
+	for len(fields) < 4 {
+		fields = append(fields, "")
+	}
+	place := &fs_data.FSPlace{}
+	if id := strings.TrimSpace(fields[0]); id != "" {
+		place.Id = &id
+	}
+	if fields[1] != "" || fields[2] != "" {
+		latitude, err := strconv.ParseFloat(strings.TrimSpace(fields[1]), 64)
+		if err != nil {
+			return nil, err
+		}
+		longitude, err := strconv.ParseFloat(strings.TrimSpace(fields[2]), 64)
+		if err != nil {
+			return nil, err
+		}
+		place.Latitude, place.Longitude = &latitude, &longitude
+	}
+	levels := strings.Split(name, ",")
+	types := strings.Split(fields[3], ",")
+	if len(types) != len(levels) {
+		types = nil
+	}
+	for i, level := range levels {
+		placeLevel := &fs_data.FSPlaceLevel{Name: proto.String(strings.TrimSpace(level))}
+		if types != nil && strings.TrimSpace(types[i]) != "" {
+			placeLevel.Type = proto.String(strings.TrimSpace(types[i]))
+		}
+		place.Levels = append(place.Levels, placeLevel)
+	}
+	return place, nil
+}
+
+// ReadStdPlaces reads the standardized places file: lines of place text and standardized name separated by a tab,
+// followed in the extended file by the details of the standardized place. Lines without a tab are skipped.
+func ReadStdPlaces(r io.Reader, m *Matcher) error {
+	scanner := bufio.NewScanner(r)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		fields := strings.Split(scanner.Text(), "\t")
+		if len(fields) < 2 {
+			continue
+		}
+		m.add(fields[0], fields[1])
+		if len(fields) > 2 && m.details[fields[1]] == nil {
+			place, err := getPlaceDetails(fields[1], fields[2:])
+			if err != nil {
+				return fmt.Errorf("standardized places line %d: %v", lineNumber, err)
+			}
+			m.details[fields[1]] = place
+		}
+	}
+	return scanner.Err()
+}
+
+// ReadAbbreviations reads an abbreviations file into the abbreviation tables, overriding their entries.
+// Lines have three tab-separated fields: "level" or "word", the abbreviation and its expansion.
+func ReadAbbreviations(r io.Reader, levelAbbreviations, wordAbbreviations map[string]string) error {
+	scanner := bufio.NewScanner(r)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, "\t")
+		if len(fields) != 3 {
+			return fmt.Errorf("abbreviations line %d: want 3 tab-separated fields", lineNumber)
+		}
+		abbreviation := strings.Join(splitWords(fields[1]), " ")
+		expansion := strings.Join(splitWords(fields[2]), " ")
+		switch fields[0] {
+		case "level":
+			levelAbbreviations[abbreviation] = expansion
+		case "word":
+			wordAbbreviations[abbreviation] = expansion
+		default:
+			return fmt.Errorf("abbreviations line %d: unknown table %q", lineNumber, fields[0])
+		}
+	}
+	return scanner.Err()
+}
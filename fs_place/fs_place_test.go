@@ -0,0 +1,67 @@
+package fs_place
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMatcher(t *testing.T) {
+	const stdPlacesFile = "Boston, Suffolk, Massachusetts\tBoston, Suffolk, Massachusetts, United States\n" +
+		"Springfield, Ill\tSpringfield, Sangamon, Illinois, United States\n" +
+		"Springfield, Hampden, Mass\tSpringfield, Hampden, Massachusetts, United States\n" +
+		"London\tLondon, England\n" +
+		"Salt Lake City, Utah\tSalt Lake City, Salt Lake, Utah, United States\n" +
+		"Salt Lake, Utah\tSalt Lake, Utah, United States\n" +
+		"Cache, Utah\tCache, Utah, United States\n" +
+		"bad line\n"
+	m := NewMatcher(LevelAbbreviations, WordAbbreviations)
+	if err := ReadStdPlaces(strings.NewReader(stdPlacesFile), m); err != nil {
+		t.Fatal(err)
+	}
+	var unmatched []string
+	m.Unmatched = func(text string) { unmatched = append(unmatched, text) }
+
+	var tests = []struct {
+		in  string
+		out string
+	}{
+		{"Boston, Suffolk, Massachusetts", "Boston, Suffolk, Massachusetts, United States"},
+		{"boston,  suffolk co., MASS.", "Boston, Suffolk, Massachusetts, United States"},
+		{"Boston, Mass.", "Boston, Suffolk, Massachusetts, United States"},
+		{"Boston, MA, USA", "Boston, Suffolk, Massachusetts, United States"},
+		{"Springfield, Sangamon Co, Illinois", "Springfield, Sangamon, Illinois, United States"},
+		{"12 Main St, Springfield, Hampden, Mass", "Springfield, Hampden, Massachusetts, United States"},
+		{"London, Eng.", "London, England"},
+		{"Salt Lake City, UT", "Salt Lake City, Salt Lake, Utah, United States"},
+		{"Salt Lake County, Utah", "Salt Lake, Utah, United States"},
+		{"Smithfield, Cache, Utah", "Cache, Utah, United States"}, // reported, since Smithfield isn't known
+		{"Springfield, USA", "Springfield, USA"}, // ambiguous
+		{"Paris, France", "Paris, France"},
+		{"Paris, France", "Paris, France"},
+		{"Boston, England", "Boston, England"},
+		{"", ""},
+	}
+	for _, test := range tests {
+		if out, _ := m.Standardize(test.in); out != test.out {
+			t.Errorf("standardize(%q) = %q; want %q", test.in, out, test.out)
+		}
+	}
+
+	want := "12 Main St, Springfield, Hampden, Mass|Smithfield, Cache, Utah|Springfield, USA|" +
+		"Paris, France|Paris, France|Boston, England"
+	if strings.Join(unmatched, "|") != want {
+		t.Errorf("Unmatched called with %q; want %q", unmatched, want)
+	}
+
+	levels, words := map[string]string{}, map[string]string{}
+	abbreviations := "# comment\nlevel\tBos.\tBoston\nword\tSprgfld\tSpringfield\n"
+	if err := ReadAbbreviations(strings.NewReader(abbreviations), levels, words); err != nil {
+		t.Fatal(err)
+	}
+	if levels["bos"] != "boston" || words["sprgfld"] != "springfield" {
+		t.Errorf("ReadAbbreviations = %v %v", levels, words)
+	}
+	if err := ReadAbbreviations(strings.NewReader("town\tx\ty\n"), levels, words); err == nil {
+		t.Errorf("ReadAbbreviations(unknown table) returned no error")
+	}
+}
@@ -0,0 +1,326 @@
+/*
+Package fs_query parses and evaluates queries that select FamilySearchPersons, like
+
+	fact(type=Birth, place~"Ohio, United States", year 1850..1870) AND NOT fact(type=Death) AND gender=FEMALE
+
+A query combines predicates with AND, OR, NOT and parentheses; AND binds more tightly than OR.
+The predicates are
+
+	fact(conditions)  some fact of the person, including the facts of their relationships, meets every
+	                  condition; fact() matches any fact
+	gender=G          the person's gender is MALE, FEMALE or UNKNOWN (also gender!=G)
+	id=ID             the person's ID is ID (also id!=ID)
+	parent(query)     some parent of the person meets the query; father is parent(gender=MALE AND ...)
+	spouse(query)     some spouse of the person meets the query
+	child(query)      some child of the person meets the query
+
+and the conditions of a fact are
+
+	type=T       the fact type is T, one of fs_data.FactTypes (also type!=T)
+	place=P      the place is P (also place!=P)
+	place~P      the place is P or a place within P, like "Franklin, Ohio, United States" within "Ohio, United States"
+	value=V      the value is V (also value!=V); value~V: the value contains V
+	year=Y       the year is Y (also year!=Y, year<Y, year<=Y, year>Y, year>=Y)
+	year Y1..Y2  the year is between Y1 and Y2 inclusive
+
+Facts without a place, value or year never meet conditions on them. Values are identifiers, numbers or
+double-quoted strings; keywords are case-insensitive.
+
+Relatives are referred to only by ID, so a query with parent, spouse or child needs more than one pass over
+the persons: Relations returns the relations of a query in the order they must be resolved, and each
+relation's Set must be set to the IDs of the persons that meet its query before the query is matched.
+
+	q, err := fs_query.Parse(query)
+	if err != nil {
+		return err
+	}
+	if q.Match(person) {
+		...
+	}
+*/
+package fs_query
+
+import (
+	"fmt"
+	"github.com/rootsdev/fsbff/fs_data"
+	"strconv"
+	"strings"
+)
+
+// Expr is a node of a parsed query
+type Expr interface {
+	// Match reports whether a person meets the query
+	Match(person *fs_data.FamilySearchPerson) bool
+	// String returns the query in canonical form
+	String() string
+}
+
+// And matches persons that meet both queries
+type And struct {
+	Left, Right Expr
+}
+
+func (e *And) Match(person *fs_data.FamilySearchPerson) bool {
+	return e.Left.Match(person) && e.Right.Match(person)
+}
+
+func (e *And) String() string {
+	return group(e.Left, "AND") + " AND " + group(e.Right, "AND")
+}
+
+// Or matches persons that meet either query
+type Or struct {
+	Left, Right Expr
+}
+
+func (e *Or) Match(person *fs_data.FamilySearchPerson) bool {
+	return e.Left.Match(person) || e.Right.Match(person)
+}
+
+func (e *Or) String() string {
+	return group(e.Left, "OR") + " OR " + group(e.Right, "OR")
+}
+
+// Not matches persons that don't meet a query
+type Not struct {
+	Expr Expr
+}
+
+func (e *Not) Match(person *fs_data.FamilySearchPerson) bool {
+	return !e.Expr.Match(person)
+}
+
+func (e *Not) String() string {
+	return "NOT " + group(e.Expr, "NOT")
+}
+
+// group parenthesizes an operand of op that would otherwise bind differently
+func group(e Expr, op string) string {
+	switch e.(type) {
+	case *Or:
+		if op != "OR" {
+			return "(" + e.String() + ")"
+		}
+	case *And:
+		if op == "NOT" {
+			return "(" + e.String() + ")"
+		}
+	}
+	return e.String()
+}
+
+// Gender matches persons by gender; persons without a gender are UNKNOWN
+type Gender struct {
+	Op     string // = or !=
+	Gender fs_data.FSGender
+}
+
+func (e *Gender) Match(person *fs_data.FamilySearchPerson) bool {
+	gender := fs_data.FSGender_UNKNOWN
+	if person.Gender != nil {
+		gender = person.GetGender()
+	}
+	return (gender == e.Gender) == (e.Op == "=")
+}
+
+func (e *Gender) String() string {
+	return "gender" + e.Op + e.Gender.String()
+}
+
+// ID matches persons by ID
+type ID struct {
+	Op string // = or !=
+	ID string
+}
+
+func (e *ID) Match(person *fs_data.FamilySearchPerson) bool {
+	return (person.GetId() == e.ID) == (e.Op == "=")
+}
+
+func (e *ID) String() string {
+	return "id" + e.Op + quote(e.ID)
+}
+
+// IDSet is a set of person IDs
+type IDSet interface {
+	Contains(id string) bool
+}
+
+// Relation matches persons with a relative of a kind (parent, spouse or child) that meets a query.
+// Set must hold the IDs of the persons that meet the query before Match is called.
+type Relation struct {
+	Kind string
+	Expr Expr
+	Set  IDSet
+}
+
+func (e *Relation) Match(person *fs_data.FamilySearchPerson) bool {
+	if e.Set == nil {
+		panic("fs_query: " + e.String() + " matched before its set of IDs was resolved")
+	}
+	var ids []string
+	switch e.Kind {
+	case "parent":
+		ids = person.GetParents()
+	case "spouse":
+		ids = person.GetSpouses()
+	case "child":
+		ids = person.GetChildren()
+	}
+	for _, id := range ids {
+		if e.Set.Contains(id) {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *Relation) String() string {
+	return e.Kind + "(" + e.Expr.String() + ")"
+}
+
+// Relations returns the relations of a query by pass: the relations whose queries have no relations come
+// first, followed by those whose queries' relations are all in earlier passes, and so on
+func Relations(e Expr) [][]*Relation {
+	var passes [][]*Relation
+	collectRelations(e, &passes)
+	return passes
+}
+
+// collectRelations adds the relations in e to passes, returning the number of passes they need
+func collectRelations(e Expr, passes *[][]*Relation) int {
+	switch e := e.(type) {
+	case *And:
+		return max(collectRelations(e.Left, passes), collectRelations(e.Right, passes))
+	case *Or:
+		return max(collectRelations(e.Left, passes), collectRelations(e.Right, passes))
+	case *Not:
+		return collectRelations(e.Expr, passes)
+	case *Relation:
+		pass := collectRelations(e.Expr, passes)
+		if pass == len(*passes) {
+			*passes = append(*passes, nil)
+		}
+		(*passes)[pass] = append((*passes)[pass], e)
+		return pass + 1
+	}
+	return 0
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// Fact matches persons with a fact that meets every condition
+type Fact struct {
+	Conds []*Cond
+}
+
+func (e *Fact) Match(person *fs_data.FamilySearchPerson) bool {
+	for _, fact := range person.GetAllFacts() {
+		if e.MatchFact(fact) {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchFact reports whether a fact meets every condition
+func (e *Fact) MatchFact(fact *fs_data.FSFact) bool {
+	for _, cond := range e.Conds {
+		if !cond.Match(fact) {
+			return false
+		}
+	}
+	return true
+}
+
+func (e *Fact) String() string {
+	conds := make([]string, len(e.Conds))
+	for i, cond := range e.Conds {
+		conds[i] = cond.String()
+	}
+	return "fact(" + strings.Join(conds, ", ") + ")"
+}
+
+// Cond is a condition on a field of a fact
+type Cond struct {
+	Field string // type, place, value or year
+	Op    string // =, !=, ~, <, <=, >, >= or .. for a year range
+	Value string // the value of type, place and value conditions
+	Year  int32  // the year of year conditions, or the first year of a range
+	End   int32  // the last year of a range
+}
+
+// Match reports whether a fact meets the condition
+func (c *Cond) Match(fact *fs_data.FSFact) bool {
+	if c.Field == "year" {
+		if fact.Year == nil {
+			return false
+		}
+		year := fact.GetYear()
+		switch c.Op {
+		case "=":
+			return year == c.Year
+		case "!=":
+			return year != c.Year
+		case "<":
+			return year < c.Year
+		case "<=":
+			return year <= c.Year
+		case ">":
+			return year > c.Year
+		case ">=":
+			return year >= c.Year
+		case "..":
+			return year >= c.Year && year <= c.End
+		}
+		return false
+	}
+
+	var value *string
+	switch c.Field {
+	case "type":
+		value = fact.Type
+	case "place":
+		value = fact.Place
+	case "value":
+		value = fact.Value
+	}
+	if value == nil {
+		return false
+	}
+	switch c.Op {
+	case "=":
+		return *value == c.Value
+	case "!=":
+		return *value != c.Value
+	case "~":
+		if c.Field == "place" {
+			return *value == c.Value || strings.HasSuffix(*value, ", "+c.Value)
+		}
+		return strings.Contains(*value, c.Value)
+	}
+	return false
+}
+
+func (c *Cond) String() string {
+	if c.Field != "year" {
+		return c.Field + c.Op + quote(c.Value)
+	}
+	if c.Op == ".." {
+		return fmt.Sprintf("year %d..%d", c.Year, c.End)
+	}
+	return fmt.Sprintf("year%s%d", c.Op, c.Year)
+}
+
+// quote returns a value as an identifier if it is one, otherwise as a string
+func quote(value string) string {
+	if isIdent(value) && !isKeyword(value) {
+		return value
+	}
+	return strconv.Quote(value)
+}
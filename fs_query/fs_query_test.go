@@ -0,0 +1,218 @@
+package fs_query
+
+import (
+	"code.google.com/p/goprotobuf/proto"
+	"fmt"
+	"github.com/rootsdev/fsbff/fs_data"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	var tests = []struct {
+		in  string
+		out string
+	}{
+		{`fact(type=Birth, place~"Ohio, United States", year 1850..1870) AND NOT fact(type=Death) AND gender=FEMALE`,
+			`fact(type=Birth, place~"Ohio, United States", year 1850..1870) AND NOT fact(type=Death) AND gender=FEMALE`},
+		{`fact()`, `fact()`},
+		{`FACT(TYPE = Birth,year>=1900)`, `fact(type=Birth, year>=1900)`},
+		{`gender=male or gender=female and id!=KWJ1-ABC`, `gender=MALE OR gender=FEMALE AND id!=KWJ1-ABC`},
+		{`(gender=MALE or gender=FEMALE) and id=1234`, `(gender=MALE OR gender=FEMALE) AND id="1234"`},
+		{`not (fact(type=Birth) and fact(type=Death))`, `NOT (fact(type=Birth) AND fact(type=Death))`},
+		{`not not fact(value~"farm \"x\"")`, `NOT NOT fact(value~"farm \"x\"")`},
+		{`fact(place=Ohio, value=and, year=1850, year!=1851, year<1900, year<=1900, year>1800)`,
+			`fact(place=Ohio, value="and", year=1850, year!=1851, year<1900, year<=1900, year>1800)`},
+		{`((id=A))`, `id=A`},
+	}
+	for _, test := range tests {
+		e, err := Parse(test.in)
+		if err != nil || e.String() != test.out {
+			t.Errorf("Parse(%s) = %v, %v; want %s", test.in, e, err, test.out)
+			continue
+		}
+		// the canonical form parses to itself
+		if again, err := Parse(e.String()); err != nil || again.String() != test.out {
+			t.Errorf("Parse(%s) = %v, %v; want %s", e.String(), again, err, test.out)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	var tests = []struct {
+		in  string
+		pos int
+		msg string
+	}{
+		{``, 0, `expected a predicate, found end of query`},
+		{`fact(type=Birth`, 15, `expected , or ), found end of query`},
+		{`fact(type=Birth) fact()`, 17, `expected AND, OR or end of query, found "fact"`},
+		{`fact(type=Brith)`, 10, `unknown fact type "Brith"`},
+		{`fact(type~Birth)`, 9, `expected = or !=, found "~"`},
+		{`fact(year 1870..1850)`, 10, `year range 1870..1850 ends before it starts`},
+		{`fact(year=abc)`, 10, `expected a year, found "abc"`},
+		{`fact(year 1850)`, 14, `expected .., found ")"`},
+		{`fact(color=red)`, 5, `expected type, place, value or year, found "color"`},
+		{`gender=OTHER`, 7, `unknown gender "OTHER"`},
+		{`name=Smith`, 0, `unknown predicate "name"`},
+		{`parent(gender=MALE`, 18, `expected ), found end of query`},
+		{`spouse gender=MALE`, 7, `expected (, found "gender"`},
+		{`id=A AND (id=B`, 14, `expected ), found end of query`},
+		{`fact(place~"Ohio)`, 11, `unterminated string`},
+		{`id=A & id=B`, 5, `unexpected character '&'`},
+		{`NOT`, 3, `expected a predicate, found end of query`},
+	}
+	for _, test := range tests {
+		_, err := Parse(test.in)
+		syntaxErr, ok := err.(*SyntaxError)
+		if !ok || syntaxErr.Pos != test.pos || syntaxErr.Msg != test.msg {
+			t.Errorf("Parse(%s) error %v; want %q at position %d", test.in, err, test.msg, test.pos)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	persons := map[string]*fs_data.FamilySearchPerson{
+		"A": {
+			Id:     proto.String("A"),
+			Gender: fs_data.FSGender_FEMALE.Enum(),
+			Facts: []*fs_data.FSFact{
+				{Type: proto.String("Birth"), Year: proto.Int32(1855), Place: proto.String("Franklin, Ohio, United States")},
+				{Type: proto.String("Death"), Year: proto.Int32(1920), Place: proto.String("Utah, United States")},
+			},
+		},
+		"B": {
+			Id:     proto.String("B"),
+			Gender: fs_data.FSGender_MALE.Enum(),
+			Facts: []*fs_data.FSFact{
+				{Type: proto.String("Birth"), Year: proto.Int32(1880), Place: proto.String("Ohio, United States")},
+				{Type: proto.String("Occupation"), Value: proto.String("dairy farmer")},
+			},
+			Relationships: []*fs_data.FSRelationship{
+				{Facts: []*fs_data.FSFact{{Type: proto.String("Marriage"), Place: proto.String("New Ohio, United States")}}},
+			},
+		},
+		"C": {Id: proto.String("C")},
+	}
+
+	var tests = []struct {
+		query string
+		out   string
+	}{
+		{`fact(type=Birth, place~"Ohio, United States", year 1850..1870) AND NOT fact(type=Death) AND gender=FEMALE`, ""},
+		{`fact(type=Birth, place~"Ohio, United States", year 1850..1870) AND fact(type=Death) AND gender=FEMALE`, "A"},
+		{`fact(type=Birth, place~"Ohio, United States")`, "AB"},
+		{`fact(type=Birth, place="Ohio, United States")`, "B"},
+		{`fact(type=Marriage, place~"Ohio, United States")`, ""},
+		{`fact(type=Marriage, place~"New Ohio, United States")`, "B"},
+		{`fact(type=Death, place~"Ohio, United States")`, ""},
+		{`fact(value~farm)`, "B"},
+		{`fact(value!=x)`, "B"},
+		{`fact(year>1900)`, "A"},
+		{`fact(year<=1855)`, "A"},
+		{`fact()`, "AB"},
+		{`NOT fact()`, "C"},
+		{`gender=UNKNOWN`, "C"},
+		{`gender!=MALE`, "AC"},
+		{`id=A OR id=C`, "AC"},
+		{`fact(type=Birth) AND (gender=MALE OR fact(year=1920))`, "AB"},
+	}
+	for _, test := range tests {
+		e, err := Parse(test.query)
+		if err != nil {
+			t.Errorf("Parse(%s) error %v", test.query, err)
+			continue
+		}
+		out := ""
+		for _, id := range []string{"A", "B", "C"} {
+			if e.Match(persons[id]) {
+				out += id
+			}
+		}
+		if out != test.out {
+			t.Errorf("%s matched %q; want %q", test.query, out, test.out)
+		}
+	}
+}
+
+type idSet map[string]bool
+
+func (s idSet) Contains(id string) bool {
+	return s[id]
+}
+
+func TestRelations(t *testing.T) {
+	persons := map[string]*fs_data.FamilySearchPerson{
+		"F": {
+			Id:       proto.String("F"),
+			Gender:   fs_data.FSGender_MALE.Enum(),
+			Facts:    []*fs_data.FSFact{{Type: proto.String("Birth"), Place: proto.String("Cork, Ireland")}},
+			Parents:  []string{"G"},
+			Spouses:  []string{"M"},
+			Children: []string{"A", "B"},
+		},
+		"G": {
+			Id:       proto.String("G"),
+			Gender:   fs_data.FSGender_MALE.Enum(),
+			Facts:    []*fs_data.FSFact{{Type: proto.String("Birth"), Place: proto.String("Ireland")}},
+			Children: []string{"F"},
+		},
+		"M": {
+			Id:       proto.String("M"),
+			Gender:   fs_data.FSGender_FEMALE.Enum(),
+			Facts:    []*fs_data.FSFact{{Type: proto.String("Birth"), Place: proto.String("Utah, United States")}},
+			Spouses:  []string{"F"},
+			Children: []string{"A", "B"},
+		},
+		"A": {Id: proto.String("A"), Gender: fs_data.FSGender_FEMALE.Enum(), Parents: []string{"F", "M"}},
+		"B": {Id: proto.String("B"), Gender: fs_data.FSGender_MALE.Enum(), Parents: []string{"F", "M"}},
+	}
+	ids := []string{"A", "B", "F", "G", "M"}
+
+	var tests = []struct {
+		query  string
+		passes string
+		out    string
+	}{
+		{`parent(gender=MALE AND fact(type=Birth, place~Ireland))`, "1", "ABF"},
+		{`gender=FEMALE AND parent(gender=MALE AND fact(type=Birth, place~Ireland))`, "1", "A"},
+		{`spouse(fact(place~"United States")) OR child(id=A)`, "2", "FM"},
+		{`parent(parent(fact(place=Ireland)))`, "1,1", "AB"},
+		{`NOT parent(fact()) AND child(parent(id=M) AND NOT id=A)`, "2,1", "M"},
+		{`id=G`, "", "G"},
+	}
+	for _, test := range tests {
+		e, err := Parse(test.query)
+		if err != nil {
+			t.Errorf("Parse(%s) error %v", test.query, err)
+			continue
+		}
+		if again, err := Parse(e.String()); err != nil || again.String() != e.String() {
+			t.Errorf("Parse(%s) = %v, %v; want %s", e.String(), again, err, e.String())
+		}
+
+		// resolve the relations a pass at a time, as a caller scanning the persons would
+		var passes []string
+		for _, relations := range Relations(e) {
+			passes = append(passes, fmt.Sprint(len(relations)))
+			for _, relation := range relations {
+				set := make(idSet)
+				for _, id := range ids {
+					if relation.Expr.Match(persons[id]) {
+						set[id] = true
+					}
+				}
+				relation.Set = set
+			}
+		}
+		out := ""
+		for _, id := range ids {
+			if e.Match(persons[id]) {
+				out += id
+			}
+		}
+		if strings.Join(passes, ",") != test.passes || out != test.out {
+			t.Errorf("%s passes %v matched %q; want passes %s matched %q", test.query, passes, out, test.passes, test.out)
+		}
+	}
+}
@@ -0,0 +1,404 @@
+package fs_query
+
+import (
+	"fmt"
+	"github.com/rootsdev/fsbff/fs_data"
+	"strconv"
+	"strings"
+)
+
+// SyntaxError is an error parsing a query
+type SyntaxError struct {
+	Pos int // byte offset of the error in the query
+	Msg string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("fs_query: %s at position %d", e.Msg, e.Pos)
+}
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenNumber
+	tokenString
+	tokenOp    // = != ~ < <= > >=
+	tokenRange // ..
+	tokenLeft
+	tokenRight
+	tokenComma
+)
+
+type token struct {
+	kind tokenKind
+	text string // the text of the token, unquoted for strings
+	pos  int
+}
+
+func (t token) String() string {
+	if t.kind == tokenEOF {
+		return "end of query"
+	}
+	if t.kind == tokenString {
+		return strconv.Quote(t.text)
+	}
+	return fmt.Sprintf("%q", t.text)
+}
+
+func isWordChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-'
+}
+
+func isDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// isIdent reports whether a value can be written without quotes
+func isIdent(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if !isWordChar(s[i]) {
+			return false
+		}
+	}
+	return s != "" && !isDigits(s)
+}
+
+func isKeyword(s string) bool {
+	switch strings.ToUpper(s) {
+	case "AND", "OR", "NOT":
+		return true
+	}
+	return false
+}
+
+// lex splits a query into tokens, ending with a tokenEOF
+func lex(query string) ([]token, error) {
+	var tokens []token
+	for pos := 0; pos < len(query); {
+		c := query[pos]
+		start := pos
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			pos++
+			continue
+		case isWordChar(c):
+			for pos < len(query) && isWordChar(query[pos]) {
+				pos++
+			}
+			kind := tokenIdent
+			if isDigits(query[start:pos]) {
+				kind = tokenNumber
+			}
+			tokens = append(tokens, token{kind, query[start:pos], start})
+		case c == '"':
+			pos++
+			for pos < len(query) && query[pos] != '"' {
+				if query[pos] == '\\' {
+					pos++
+				}
+				pos++
+			}
+			if pos >= len(query) {
+				return nil, &SyntaxError{start, "unterminated string"}
+			}
+			pos++
+			text, err := strconv.Unquote(query[start:pos])
+			if err != nil {
+				return nil, &SyntaxError{start, "invalid string"}
+			}
+			tokens = append(tokens, token{tokenString, text, start})
+		case strings.HasPrefix(query[pos:], ".."):
+			pos += 2
+			tokens = append(tokens, token{tokenRange, "..", start})
+		case strings.HasPrefix(query[pos:], "!=") || strings.HasPrefix(query[pos:], "<=") ||
+			strings.HasPrefix(query[pos:], ">="):
+			pos += 2
+			tokens = append(tokens, token{tokenOp, query[start:pos], start})
+		case c == '=' || c == '~' || c == '<' || c == '>':
+			pos++
+			tokens = append(tokens, token{tokenOp, query[start:pos], start})
+		case c == '(':
+			pos++
+			tokens = append(tokens, token{tokenLeft, "(", start})
+		case c == ')':
+			pos++
+			tokens = append(tokens, token{tokenRight, ")", start})
+		case c == ',':
+			pos++
+			tokens = append(tokens, token{tokenComma, ",", start})
+		default:
+			return nil, &SyntaxError{start, fmt.Sprintf("unexpected character %q", c)}
+		}
+	}
+	return append(tokens, token{tokenEOF, "", len(query)}), nil
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+// keyword reports whether the next token is a keyword, consuming it if so
+func (p *parser) keyword(keyword string) bool {
+	if t := p.peek(); t.kind == tokenIdent && strings.EqualFold(t.text, keyword) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(kind tokenKind, what string) (token, error) {
+	t := p.next()
+	if t.kind != kind {
+		return t, &SyntaxError{t.pos, fmt.Sprintf("expected %s, found %s", what, t)}
+	}
+	return t, nil
+}
+
+// Parse parses a query
+func Parse(query string) (Expr, error) {
+	tokens, err := lex(query)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	e, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokenEOF {
+		return nil, &SyntaxError{t.pos, fmt.Sprintf("expected AND, OR or end of query, found %s", t)}
+	}
+	return e, nil
+}
+
+func (p *parser) parseOr() (Expr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("OR") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &Or{left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (Expr, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("AND") {
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &And{left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseUnary() (Expr, error) {
+	if p.keyword("NOT") {
+		e, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &Not{e}, nil
+	}
+	if p.peek().kind == tokenLeft {
+		p.next()
+		e, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if _, err = p.expect(tokenRight, ")"); err != nil {
+			return nil, err
+		}
+		return e, nil
+	}
+	return p.parsePredicate()
+}
+
+func (p *parser) parsePredicate() (Expr, error) {
+	t := p.next()
+	if t.kind != tokenIdent {
+		return nil, &SyntaxError{t.pos, fmt.Sprintf("expected a predicate, found %s", t)}
+	}
+	switch strings.ToLower(t.text) {
+	case "fact":
+		return p.parseFact()
+	case "gender":
+		op, value, err := p.parseComparison("=", "!=")
+		if err != nil {
+			return nil, err
+		}
+		gender, ok := fs_data.FSGender_value[strings.ToUpper(value.text)]
+		if !ok {
+			return nil, &SyntaxError{value.pos, fmt.Sprintf("unknown gender %s", value)}
+		}
+		return &Gender{op, fs_data.FSGender(gender)}, nil
+	case "id":
+		op, value, err := p.parseComparison("=", "!=")
+		if err != nil {
+			return nil, err
+		}
+		return &ID{op, value.text}, nil
+	case "parent", "spouse", "child":
+		if _, err := p.expect(tokenLeft, "("); err != nil {
+			return nil, err
+		}
+		e, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if _, err = p.expect(tokenRight, ")"); err != nil {
+			return nil, err
+		}
+		return &Relation{Kind: strings.ToLower(t.text), Expr: e}, nil
+	}
+	return nil, &SyntaxError{t.pos, fmt.Sprintf("unknown predicate %s", t)}
+}
+
+// parseComparison parses one of the given operators followed by a value
+func (p *parser) parseComparison(ops ...string) (string, token, error) {
+	op, err := p.expect(tokenOp, strings.Join(ops, " or "))
+	if err != nil {
+		return "", op, err
+	}
+	if !containsString(ops, op.text) {
+		return "", op, &SyntaxError{op.pos, fmt.Sprintf("expected %s, found %s", strings.Join(ops, " or "), op)}
+	}
+	value := p.next()
+	if value.kind != tokenIdent && value.kind != tokenNumber && value.kind != tokenString {
+		return "", value, &SyntaxError{value.pos, fmt.Sprintf("expected a value, found %s", value)}
+	}
+	return op.text, value, nil
+}
+
+func containsString(values []string, s string) bool {
+	for _, value := range values {
+		if value == s {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *parser) parseFact() (Expr, error) {
+	if _, err := p.expect(tokenLeft, "("); err != nil {
+		return nil, err
+	}
+	e := &Fact{}
+	if p.peek().kind == tokenRight {
+		p.next()
+		return e, nil
+	}
+	for {
+		cond, err := p.parseCond()
+		if err != nil {
+			return nil, err
+		}
+		e.Conds = append(e.Conds, cond)
+		t := p.next()
+		if t.kind == tokenRight {
+			return e, nil
+		}
+		if t.kind != tokenComma {
+			return nil, &SyntaxError{t.pos, fmt.Sprintf("expected , or ), found %s", t)}
+		}
+	}
+}
+
+func (p *parser) parseCond() (*Cond, error) {
+	t := p.next()
+	field := strings.ToLower(t.text)
+	switch {
+	case t.kind == tokenIdent && field == "year":
+		return p.parseYear()
+	case t.kind == tokenIdent && (field == "type" || field == "place" || field == "value"):
+	default:
+		return nil, &SyntaxError{t.pos, fmt.Sprintf("expected type, place, value or year, found %s", t)}
+	}
+
+	ops := []string{"=", "!=", "~"}
+	if field == "type" {
+		ops = ops[:2]
+	}
+	op, value, err := p.parseComparison(ops...)
+	if err != nil {
+		return nil, err
+	}
+	if field == "type" && !fs_data.IsFactType(value.text) {
+		return nil, &SyntaxError{value.pos, fmt.Sprintf("unknown fact type %s", value)}
+	}
+	return &Cond{Field: field, Op: op, Value: value.text}, nil
+}
+
+func (p *parser) parseYear() (*Cond, error) {
+	cond := &Cond{Field: "year", Op: ".."}
+	if p.peek().kind == tokenOp {
+		op, value, err := p.parseComparison("=", "!=", "<", "<=", ">", ">=")
+		if err != nil {
+			return nil, err
+		}
+		if cond.Year, err = parseYear(value); err != nil {
+			return nil, err
+		}
+		cond.Op = op
+		return cond, nil
+	}
+
+	start, err := p.expect(tokenNumber, "a year or a comparison")
+	if err != nil {
+		return nil, err
+	}
+	if _, err = p.expect(tokenRange, ".."); err != nil {
+		return nil, err
+	}
+	end, err := p.expect(tokenNumber, "a year")
+	if err != nil {
+		return nil, err
+	}
+	if cond.Year, err = parseYear(start); err != nil {
+		return nil, err
+	}
+	if cond.End, err = parseYear(end); err != nil {
+		return nil, err
+	}
+	if cond.End < cond.Year {
+		return nil, &SyntaxError{start.pos, fmt.Sprintf("year range %d..%d ends before it starts", cond.Year, cond.End)}
+	}
+	return cond, nil
+}
+
+func parseYear(t token) (int32, error) {
+	year, err := strconv.ParseInt(t.text, 10, 32)
+	if t.kind != tokenNumber || err != nil {
+		return 0, &SyntaxError{t.pos, fmt.Sprintf("expected a year, found %s", t)}
+	}
+	return int32(year), nil
+}
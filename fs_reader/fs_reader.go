@@ -0,0 +1,357 @@
+/*
+Package fs_reader reads FamilySearchPerson protobuf files.
+
+A PersonSource iterates over the persons in a single file or in every file of a directory.
+Files ending in .gz are gunzipped transparently, and both legacy FamilySearchPersons files and
+length-delimited stream files (see fs_data.StreamWriter) are detected automatically.
+Errors are returned to the caller instead of exiting.
+Files written with fsxml2protobuf -shards can be read a shard at a time (see fs_shard).
+
+	source, err := fs_reader.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+	for source.Next() {
+		person := source.Person()
+		...
+	}
+	return source.Err()
+*/
+package fs_reader
+
+import (
+	"bufio"
+	"code.google.com/p/goprotobuf/proto"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/rootsdev/fsbff/fs_data"
+	"github.com/rootsdev/fsbff/fs_shard"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Filenames returns path if it is a file, or the files in path if it is a directory, skipping any shard manifest
+func Filenames(path string) ([]string, error) {
+	fileInfo, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !fileInfo.IsDir() {
+		return []string{path}, nil
+	}
+	fileInfos, err := ioutil.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	filenames := make([]string, 0, len(fileInfos))
+	for _, fileInfo := range fileInfos {
+		if fileInfo.IsDir() || fileInfo.Name() == fs_shard.ManifestName {
+			continue
+		}
+		filenames = append(filenames, filepath.Join(path, fileInfo.Name()))
+	}
+	return filenames, nil
+}
+
+// OpenFile opens a file for reading, gunzipping it if the name ends in .gz
+func OpenFile(filename string) (io.ReadCloser, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasSuffix(filename, ".gz") {
+		return file, nil
+	}
+	gzipReader, err := gzip.NewReader(file)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	return &gzipFile{gzipReader, file}, nil
+}
+
+type gzipFile struct {
+	*gzip.Reader
+	file *os.File
+}
+
+func (f *gzipFile) Close() error {
+	err := f.Reader.Close()
+	if fileErr := f.file.Close(); err == nil {
+		err = fileErr
+	}
+	return err
+}
+
+// legacyFile holds the persons of a legacy FamilySearchPersons file and their offsets, if known
+type legacyFile struct {
+	persons []*fs_data.FamilySearchPerson
+	offsets []int64
+}
+
+// openPersons opens a file and returns either a stream reader or, for legacy files, all of its persons
+func openPersons(filename string) (io.ReadCloser, *fs_data.StreamReader, *legacyFile, error) {
+	file, err := OpenFile(filename)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	r := bufio.NewReader(file)
+	prefix, _ := r.Peek(len(fs_data.StreamMagic))
+	if fs_data.IsStream(prefix) {
+		stream, err := fs_data.NewStreamReader(r)
+		if err != nil {
+			file.Close()
+			return nil, nil, nil, fmt.Errorf("%s: %v", filename, err)
+		}
+		return file, stream, nil, nil
+	}
+	defer file.Close()
+
+	bytes, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	if legacy, err := splitPersons(bytes); err == nil {
+		return nil, nil, legacy, nil
+	}
+	fsPersons := &fs_data.FamilySearchPersons{}
+	if err = proto.Unmarshal(bytes, fsPersons); err != nil {
+		return nil, nil, nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	return nil, nil, &legacyFile{persons: fsPersons.Persons}, nil
+}
+
+// personsTag is the wire tag of FamilySearchPersons.persons (field 1, length-delimited)
+const personsTag = 1<<3 | 2
+
+// maxPersonSize guards against reading garbage as a huge length
+const maxPersonSize = 64 << 20
+
+// splitPersons unmarshals the persons of a FamilySearchPersons message one at a time, recording the
+// offset of each person's length; it fails on anything but persons, leaving those to proto.Unmarshal
+func splitPersons(b []byte) (*legacyFile, error) {
+	legacy := &legacyFile{}
+	for pos := 0; pos < len(b); {
+		tag, n := proto.DecodeVarint(b[pos:])
+		if n == 0 || tag != personsTag {
+			return nil, errors.New("not a persons field")
+		}
+		pos += n
+		length, n := proto.DecodeVarint(b[pos:])
+		if n == 0 || length > uint64(len(b)-pos-n) {
+			return nil, errors.New("truncated person")
+		}
+		person := &fs_data.FamilySearchPerson{}
+		if err := proto.Unmarshal(b[pos+n:pos+n+int(length)], person); err != nil {
+			return nil, err
+		}
+		legacy.persons = append(legacy.persons, person)
+		legacy.offsets = append(legacy.offsets, int64(pos))
+		pos += n + int(length)
+	}
+	return legacy, nil
+}
+
+// ReadPersonAt reads the person at an offset returned by PersonSource.Offset
+func ReadPersonAt(filename string, offset int64) (*fs_data.FamilySearchPerson, error) {
+	file, err := OpenFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	if f, ok := file.(*os.File); ok {
+		_, err = f.Seek(offset, io.SeekStart)
+	} else {
+		_, err = io.CopyN(ioutil.Discard, file, offset)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: offset %d: %v", filename, offset, err)
+	}
+
+	r := bufio.NewReader(file)
+	length, err := binary.ReadUvarint(r)
+	if err == nil && length > maxPersonSize {
+		err = fmt.Errorf("person length %d too large", length)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: offset %d: %v", filename, offset, err)
+	}
+	b := make([]byte, length)
+	if _, err = io.ReadFull(r, b); err != nil {
+		return nil, fmt.Errorf("%s: offset %d: %v", filename, offset, err)
+	}
+	person := &fs_data.FamilySearchPerson{}
+	if err = proto.Unmarshal(b, person); err != nil {
+		return nil, fmt.Errorf("%s: offset %d: %v", filename, offset, err)
+	}
+	return person, nil
+}
+
+// ReadFile reads all of the persons in a single file
+func ReadFile(filename string) (*fs_data.FamilySearchPersons, error) {
+	file, stream, legacy, err := openPersons(filename)
+	if err != nil {
+		return nil, err
+	}
+	if stream == nil {
+		return &fs_data.FamilySearchPersons{Persons: legacy.persons}, nil
+	}
+	defer file.Close()
+
+	fsPersons := &fs_data.FamilySearchPersons{}
+
+	for {
+		person, err := stream.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", filename, err)
+		}
+		fsPersons.Persons = append(fsPersons.Persons, person)
+	}
+	return fsPersons, nil
+}
+
+// PersonSource iterates over the persons in a file or directory of files
+type PersonSource struct {
+	filenames []string
+	next      int
+	filename  string
+	file      io.ReadCloser
+	stream    *fs_data.StreamReader
+	legacy    *legacyFile
+	pos       int
+	person    *fs_data.FamilySearchPerson
+	offset    int64
+	err       error
+}
+
+// Open returns a PersonSource for a file or directory
+func Open(path string) (*PersonSource, error) {
+	filenames, err := Filenames(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewPersonSource(filenames), nil
+}
+
+// OpenShard returns a PersonSource for the shard of a sharded output directory that holds a person ID
+func OpenShard(dir string, id string) (*PersonSource, error) {
+	m, err := fs_shard.ReadManifest(dir)
+	if err != nil {
+		return nil, err
+	}
+	return NewPersonSource([]string{m.Filename(dir, id)}), nil
+}
+
+// FindPerson reads the person with an ID from a sharded output directory, returning nil if it isn't found
+func FindPerson(dir string, id string) (*fs_data.FamilySearchPerson, error) {
+	source, err := OpenShard(dir, id)
+	if err != nil {
+		return nil, err
+	}
+	defer source.Close()
+	for source.Next() {
+		if source.Person().GetId() == id {
+			return source.Person(), nil
+		}
+	}
+	return nil, source.Err()
+}
+
+// NewPersonSource returns a PersonSource that reads the given files in order
+func NewPersonSource(filenames []string) *PersonSource {
+	return &PersonSource{filenames: filenames}
+}
+
+func (s *PersonSource) closeFile() {
+	if s.file != nil {
+		s.file.Close()
+	}
+	s.file = nil
+	s.stream = nil
+	s.legacy = nil
+	s.pos = 0
+}
+
+// Next advances to the next person, returning false at the end of the input or on error
+func (s *PersonSource) Next() bool {
+	for s.err == nil {
+		if s.stream != nil {
+			offset := s.stream.Offset()
+			person, err := s.stream.Read()
+			if err == nil {
+				s.person = person
+				s.offset = offset
+				return true
+			}
+			if err != io.EOF {
+				s.err = fmt.Errorf("%s: %v", s.filename, err)
+				break
+			}
+		} else if s.legacy != nil && s.pos < len(s.legacy.persons) {
+			s.person = s.legacy.persons[s.pos]
+			s.offset = -1
+			if s.legacy.offsets != nil {
+				s.offset = s.legacy.offsets[s.pos]
+			}
+			s.pos++
+			return true
+		}
+
+		s.closeFile()
+		if s.next >= len(s.filenames) {
+			break
+		}
+		s.filename = s.filenames[s.next]
+		s.next++
+		s.file, s.stream, s.legacy, s.err = openPersons(s.filename)
+	}
+	s.person = nil
+	return false
+}
+
+// Person returns the current person
+func (s *PersonSource) Person() *fs_data.FamilySearchPerson {
+	return s.person
+}
+
+// Filename returns the name of the file the current person was read from
+func (s *PersonSource) Filename() string {
+	return s.filename
+}
+
+// Offset returns the byte offset of the current person in its uncompressed file, for ReadPersonAt,
+// or -1 if it isn't known
+func (s *PersonSource) Offset() int64 {
+	return s.offset
+}
+
+// Header returns the header of the current file, or nil if it is a legacy FamilySearchPersons file
+func (s *PersonSource) Header() *fs_data.FamilySearchPersonsHeader {
+	if s.stream == nil {
+		return nil
+	}
+	return s.stream.Header()
+}
+
+// Err returns the first error encountered while reading
+func (s *PersonSource) Err() error {
+	return s.err
+}
+
+// Close closes the current file; Next returns false afterwards
+func (s *PersonSource) Close() error {
+	s.closeFile()
+	s.person = nil
+	s.next = len(s.filenames)
+	return nil
+}
@@ -0,0 +1,194 @@
+package fs_reader
+
+import (
+	"code.google.com/p/goprotobuf/proto"
+	"compress/gzip"
+	"github.com/rootsdev/fsbff/fs_data"
+	"github.com/rootsdev/fsbff/fs_shard"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writePersons(t *testing.T, filename string, ids ...string) {
+	fsPersons := &fs_data.FamilySearchPersons{}
+	for _, id := range ids {
+		fsPersons.Persons = append(fsPersons.Persons, &fs_data.FamilySearchPerson{Id: proto.String(id)})
+	}
+	b, err := proto.Marshal(fsPersons)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Create(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if strings.HasSuffix(filename, ".gz") {
+		w := gzip.NewWriter(file)
+		defer w.Close()
+		_, err = w.Write(b)
+	} else {
+		_, err = file.Write(b)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writeStream(t *testing.T, filename string, ids ...string) {
+	file, err := os.Create(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	w, err := fs_data.NewStreamWriter(file, "test", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range ids {
+		if err = w.Write(&fs_data.FamilySearchPerson{Id: proto.String(id)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readIds(source *PersonSource) (ids []string) {
+	for source.Next() {
+		ids = append(ids, source.Person().GetId()+"@"+filepath.Base(source.Filename()))
+	}
+	return
+}
+
+func TestPersonSource(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fs_reader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	writePersons(t, filepath.Join(dir, "a.protobuf"), "A1", "A2")
+	writePersons(t, filepath.Join(dir, "b.protobuf"))
+	writePersons(t, filepath.Join(dir, "c.protobuf.gz"), "C1")
+	writeStream(t, filepath.Join(dir, "d.protobuf"), "D1", "D2")
+	os.Mkdir(filepath.Join(dir, "sub"), 0755)
+
+	var tests = []struct {
+		path string
+		out  string
+	}{
+		{dir, "A1@a.protobuf,A2@a.protobuf,C1@c.protobuf.gz,D1@d.protobuf,D2@d.protobuf"},
+		{filepath.Join(dir, "d.protobuf"), "D1@d.protobuf,D2@d.protobuf"},
+		{filepath.Join(dir, "b.protobuf"), ""},
+		{filepath.Join(dir, "c.protobuf.gz"), "C1@c.protobuf.gz"},
+	}
+	for _, test := range tests {
+		source, err := Open(test.path)
+		if err != nil {
+			t.Errorf("Open(%s) error %v", test.path, err)
+			continue
+		}
+		actual := strings.Join(readIds(source), ",")
+		if source.Err() != nil || actual != test.out {
+			t.Errorf("Open(%s) read %s err %v; want %s", test.path, actual, source.Err(), test.out)
+		}
+		source.Close()
+	}
+
+	if _, err = Open(filepath.Join(dir, "missing")); err == nil {
+		t.Errorf("Open(missing) returned no error")
+	}
+
+	fsPersons, err := ReadFile(filepath.Join(dir, "d.protobuf"))
+	if err != nil || len(fsPersons.Persons) != 2 || fsPersons.Persons[1].GetId() != "D2" {
+		t.Errorf("ReadFile(stream) = %v, %v; want D1, D2", fsPersons, err)
+	}
+
+	ioutil.WriteFile(filepath.Join(dir, "bad.protobuf.gz"), []byte("not gzip"), 0644)
+	source := NewPersonSource([]string{filepath.Join(dir, "a.protobuf"), filepath.Join(dir, "bad.protobuf.gz")})
+	actual := strings.Join(readIds(source), ",")
+	if source.Err() == nil || actual != "A1@a.protobuf,A2@a.protobuf" {
+		t.Errorf("bad file read %s err %v; want the first file's persons and an error", actual, source.Err())
+	}
+}
+
+func TestFindPerson(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fs_reader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ids := []string{"A", "B", "C", "D", "E", "F"}
+	m := fs_shard.NewManifest(3, false)
+	shardIds := make([][]string, m.Shards)
+	for _, id := range ids {
+		shard := fs_shard.Shard(id, m.Shards)
+		shardIds[shard] = append(shardIds[shard], id)
+	}
+	for shard, filename := range m.Filenames(dir) {
+		writeStream(t, filename, shardIds[shard]...)
+		m.Files[shard].Persons = int64(len(shardIds[shard]))
+	}
+	if _, err = FindPerson(dir, "A"); err == nil {
+		t.Errorf("FindPerson(no manifest) returned no error")
+	}
+	if err = m.Write(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range append(ids, "G") {
+		person, err := FindPerson(dir, id)
+		found := id != "G"
+		if err != nil || (person != nil) != found || (found && person.GetId() != id) {
+			t.Errorf("FindPerson(%s) = %v, %v; want found %v", id, person, err, found)
+		}
+	}
+
+	source, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer source.Close()
+	if actual := readIds(source); source.Err() != nil || len(actual) != len(ids) {
+		t.Errorf("Open(sharded) read %v err %v; want %d persons", actual, source.Err(), len(ids))
+	}
+}
+
+func TestReadPersonAt(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fs_reader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	writePersons(t, filepath.Join(dir, "a.protobuf"), "A1", "A2", "A3")
+	writePersons(t, filepath.Join(dir, "b.protobuf.gz"), "B1", "B2")
+	writeStream(t, filepath.Join(dir, "c.protobuf"), "C1", "C2")
+
+	source, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer source.Close()
+	persons := 0
+	for source.Next() {
+		person, err := ReadPersonAt(source.Filename(), source.Offset())
+		if err != nil || person.GetId() != source.Person().GetId() {
+			t.Errorf("ReadPersonAt(%s, %d) = %v, %v; want %s", source.Filename(), source.Offset(), person, err,
+				source.Person().GetId())
+		}
+		persons++
+	}
+	if source.Err() != nil || persons != 7 {
+		t.Errorf("Open read %d persons err %v; want 7", persons, source.Err())
+	}
+	if _, err = ReadPersonAt(filepath.Join(dir, "a.protobuf"), 1000); err == nil {
+		t.Errorf("ReadPersonAt(past the end) returned no error")
+	}
+}
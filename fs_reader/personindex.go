@@ -0,0 +1,59 @@
+package fs_reader
+
+import (
+	"fmt"
+	"github.com/rootsdev/fsbff/fs_data"
+	"github.com/rootsdev/fsbff/fs_index"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+/*
+A person index is an fs_index file whose keys are person IDs and whose values are the file name of
+each person, relative to the indexed directory, and the person's offset in it, separated by a tab.
+It is written by the indexpersons command.
+*/
+
+// PersonIndexValue returns the index value of a person in a file of the indexed directory
+func PersonIndexValue(name string, offset int64) string {
+	return name + "\t" + strconv.FormatInt(offset, 10)
+}
+
+// PersonIndex finds persons by ID in an indexed directory; it is safe for concurrent use
+type PersonIndex struct {
+	dir   string
+	index *fs_index.Index
+}
+
+// OpenPersonIndex opens the index of the persons in dir
+func OpenPersonIndex(filename string, dir string) (*PersonIndex, error) {
+	index, err := fs_index.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	return &PersonIndex{dir: dir, index: index}, nil
+}
+
+// Find reads the person with an ID, returning nil if it isn't in the index
+func (p *PersonIndex) Find(id string) (*fs_data.FamilySearchPerson, error) {
+	values, err := p.index.Lookup(id)
+	if err != nil || len(values) == 0 {
+		return nil, err
+	}
+	// fsxml2protobuf writes each person once, so there is normally a single value
+	tab := strings.LastIndexByte(values[0], '\t')
+	if tab < 0 {
+		return nil, fmt.Errorf("fs_reader: bad person index value %q", values[0])
+	}
+	offset, err := strconv.ParseInt(values[0][tab+1:], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("fs_reader: bad person index value %q", values[0])
+	}
+	return ReadPersonAt(filepath.Join(p.dir, values[0][:tab]), offset)
+}
+
+// Close closes the index
+func (p *PersonIndex) Close() error {
+	return p.index.Close()
+}
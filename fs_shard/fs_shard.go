@@ -0,0 +1,122 @@
+/*
+Package fs_shard partitions persons into a fixed number of shard files by a hash of their IDs.
+
+A sharded output directory holds the shard files and a manifest naming them, so a reader can open
+just the shard that holds a given person, and two datasets sharded the same way can be joined shard
+by shard in parallel.
+
+	m, err := fs_shard.ReadManifest(dir)
+	if err != nil {
+		return err
+	}
+	filename := m.Filename(dir, id)
+*/
+package fs_shard
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// ManifestName is the name of the manifest file in a sharded output directory
+const ManifestName = "shards.json"
+
+// HashFNV1a is the hash of person IDs, 32-bit FNV-1a modulo the number of shards
+const HashFNV1a = "fnv1a32"
+
+// Manifest describes the shard files of a sharded output directory
+type Manifest struct {
+	Shards int    `json:"shards"`
+	Hash   string `json:"hash"`
+	Files  []File `json:"files"` // indexed by shard
+}
+
+// File is a single shard file
+type File struct {
+	Name    string `json:"name"`
+	Persons int64  `json:"persons"`
+}
+
+// Shard returns the shard of a person ID
+func Shard(id string, shards int) int {
+	h := fnv.New32a()
+	h.Write([]byte(id))
+	return int(h.Sum32() % uint32(shards))
+}
+
+// ShardName returns the file name of a shard
+func ShardName(shard int, gzip bool) string {
+	name := fmt.Sprintf("shard-%05d.protobuf", shard)
+	if gzip {
+		name += ".gz"
+	}
+	return name
+}
+
+// NewManifest returns the manifest of a number of shards, with no persons yet
+func NewManifest(shards int, gzip bool) *Manifest {
+	m := &Manifest{Shards: shards, Hash: HashFNV1a, Files: make([]File, shards)}
+	for i := range m.Files {
+		m.Files[i].Name = ShardName(i, gzip)
+	}
+	return m
+}
+
+// ReadManifest reads the manifest of a sharded output directory
+func ReadManifest(dir string) (*Manifest, error) {
+	b, err := ioutil.ReadFile(filepath.Join(dir, ManifestName))
+	if err != nil {
+		return nil, err
+	}
+	m := &Manifest{}
+	if err = json.Unmarshal(b, m); err != nil {
+		return nil, fmt.Errorf("fs_shard: %s: %v", dir, err)
+	}
+	if m.Hash != HashFNV1a {
+		return nil, fmt.Errorf("fs_shard: %s: unknown hash %q", dir, m.Hash)
+	}
+	if m.Shards <= 0 || len(m.Files) != m.Shards {
+		return nil, fmt.Errorf("fs_shard: %s: %d files for %d shards", dir, len(m.Files), m.Shards)
+	}
+	return m, nil
+}
+
+// Write writes the manifest to a sharded output directory
+func (m *Manifest) Write(dir string) error {
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	filename := filepath.Join(dir, ManifestName)
+	if err = ioutil.WriteFile(filename+".tmp", append(b, '\n'), 0644); err != nil {
+		return err
+	}
+	return os.Rename(filename+".tmp", filename)
+}
+
+// Filename returns the name of the shard file in dir that holds a person ID
+func (m *Manifest) Filename(dir string, id string) string {
+	return filepath.Join(dir, m.Files[Shard(id, m.Shards)].Name)
+}
+
+// Filenames returns the names of the shard files in dir, in shard order
+func (m *Manifest) Filenames(dir string) []string {
+	filenames := make([]string, len(m.Files))
+	for i, file := range m.Files {
+		filenames[i] = filepath.Join(dir, file.Name)
+	}
+	return filenames
+}
+
+// Persons returns the number of persons in all shards
+func (m *Manifest) Persons() int64 {
+	var persons int64
+	for _, file := range m.Files {
+		persons += file.Persons
+	}
+	return persons
+}
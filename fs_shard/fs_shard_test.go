@@ -0,0 +1,83 @@
+package fs_shard
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestShard(t *testing.T) {
+	var tests = []struct {
+		id     string
+		shards int
+		out    int
+	}{
+		{"", 1, 0},
+		{"KWJ1-ABC", 1, 0},
+		{"", 7, int(2166136261 % 7)}, // FNV-1a offset basis
+		{"a", 16, int(0xe40c292c % 16)},
+	}
+	for _, test := range tests {
+		if out := Shard(test.id, test.shards); out != test.out {
+			t.Errorf("Shard(%q, %d) = %d; want %d", test.id, test.shards, out, test.out)
+		}
+	}
+
+	counts := make([]int, 8)
+	for i := 0; i < 8000; i++ {
+		counts[Shard(fmt.Sprintf("P%d", i), len(counts))]++
+	}
+	for shard, count := range counts {
+		if count < 800 || count > 1200 {
+			t.Errorf("Shard put %d of 8000 ids in shard %d; want about 1000", count, shard)
+		}
+	}
+}
+
+func TestManifest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fs_shard")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if _, err = ReadManifest(dir); err == nil {
+		t.Errorf("ReadManifest(missing) returned no error")
+	}
+
+	m := NewManifest(3, true)
+	m.Files[0].Persons = 2
+	m.Files[2].Persons = 5
+	if err = m.Write(dir); err != nil {
+		t.Fatal(err)
+	}
+	m, err = ReadManifest(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Shards != 3 || m.Persons() != 7 || m.Files[1].Name != "shard-00001.protobuf.gz" {
+		t.Errorf("ReadManifest() = %d shards %d persons %q; want 3 shards 7 persons shard-00001.protobuf.gz",
+			m.Shards, m.Persons(), m.Files[1].Name)
+	}
+	id := "KWJ1-ABC"
+	if filename := m.Filename(dir, id); filename != m.Filenames(dir)[Shard(id, 3)] {
+		t.Errorf("Filename(%q) = %s; want %s", id, filename, m.Filenames(dir)[Shard(id, 3)])
+	}
+
+	var bad = []string{
+		`{"shards": 2, "hash": "fnv1a32", "files": [{"name": "a"}]}`,
+		`{"shards": 1, "hash": "md5", "files": [{"name": "a"}]}`,
+		`{"shards": 0, "hash": "fnv1a32"}`,
+		`not json`,
+	}
+	for _, contents := range bad {
+		if err = ioutil.WriteFile(filepath.Join(dir, ManifestName), []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = ReadManifest(dir); err == nil {
+			t.Errorf("ReadManifest(%s) returned no error", contents)
+		}
+	}
+}
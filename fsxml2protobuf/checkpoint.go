@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bufio"
+	"github.com/willf/bloom"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+/*
+Checkpoints let a long directory run be resumed after a crash.
+
+A checkpoint directory holds two files, each replaced atomically:
+  completed - the input files that have been fully processed, one per line
+  bloom     - a snapshot of personIdsBloom taken at the same moment
+
+Workers hold checkpointLock for reading while they process a file, so taking the lock for writing
+waits until no file is half-processed. The bloom snapshot then contains the IDs of exactly the
+files listed in completed, and a resumed run skips those files and restores the snapshot.
+*/
+
+const completedFilename = "completed"
+const bloomFilename = "bloom"
+
+var checkpointLock sync.RWMutex
+var completedFiles []string
+var completedMutex = &sync.Mutex{}
+
+// markCompleted records that an input file has been converted, so a resumed run skips it;
+// the caller must hold checkpointLock for reading
+func markCompleted(inFilename string) {
+	completedMutex.Lock()
+	completedFiles = append(completedFiles, inFilename)
+	completedMutex.Unlock()
+}
+
+// writeFileAtomic writes a file by writing a temporary file and renaming it
+func writeFileAtomic(filename string, write func(w *bufio.Writer) error) error {
+	tmpFilename := filename + ".tmp"
+	file, err := os.Create(tmpFilename)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(file)
+	err = write(w)
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpFilename)
+		return err
+	}
+	return os.Rename(tmpFilename, filename)
+}
+
+// writeCheckpoint waits for in-progress files to finish, then saves the completed files and the bloom filter
+func writeCheckpoint(dir string) error {
+	checkpointLock.Lock()
+	defer checkpointLock.Unlock()
+
+	err := writeFileAtomic(filepath.Join(dir, bloomFilename), func(w *bufio.Writer) error {
+		personIdsMutex.Lock()
+		defer personIdsMutex.Unlock()
+		_, err := personIdsBloom.WriteTo(w)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	completedMutex.Lock()
+	defer completedMutex.Unlock()
+	return writeFileAtomic(filepath.Join(dir, completedFilename), func(w *bufio.Writer) error {
+		for _, inFilename := range completedFiles {
+			if _, err := w.WriteString(inFilename + "\n"); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// readCheckpoint restores the completed files and the bloom filter saved by writeCheckpoint
+func readCheckpoint(dir string) ([]string, *bloom.BloomFilter, error) {
+	var completed []string
+	file, err := os.Open(filepath.Join(dir, completedFilename))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		completed = append(completed, scanner.Text())
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	bloomFile, err := os.Open(filepath.Join(dir, bloomFilename))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer bloomFile.Close()
+	filter := &bloom.BloomFilter{}
+	if _, err = filter.ReadFrom(bufio.NewReader(bloomFile)); err != nil {
+		return nil, nil, err
+	}
+	return completed, filter, nil
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"strings"
+)
+
+/*
+Input files are either BFF XML, a sequence of <record> elements each holding a person and its
+relationships, or GEDCOM X JSON, a sequence of documents (one per line, concatenated, or in a
+top-level array) each holding persons and relationships. Both are decoded into the same Record
+structs, so the rest of the conversion doesn't depend on the input encoding.
+*/
+
+// recordDecoder reads the records of an input file one at a time
+type recordDecoder interface {
+	// Decode decodes the next record and sets its offset, returning io.EOF at the end of the input.
+	// skippable is set if the record could not be decoded but the decoder can continue after it.
+	Decode(record *Record) (skippable bool, err error)
+}
+
+// newRecordDecoder returns a decoder for the format given by the filename extension (ignoring .gz),
+// or by the first character of the input if the extension is neither .xml nor .json.
+// If skipBad is set, the decoder makes the extra effort needed to continue after a bad record.
+func newRecordDecoder(filename string, r io.Reader, skipBad bool) recordDecoder {
+	br := bufio.NewReader(r)
+	first := firstNonSpace(br)
+	name := strings.TrimSuffix(filename, ".gz")
+	isJSON := first == '{' || first == '['
+	if strings.HasSuffix(name, ".xml") {
+		isJSON = false
+	} else if strings.HasSuffix(name, ".json") || strings.HasSuffix(name, ".jsonl") {
+		isJSON = true
+	}
+
+	if isJSON {
+		d := &jsonDecoder{decoder: json.NewDecoder(br)}
+		if first == '[' {
+			d.decoder.Token()
+			d.array = true
+		}
+		return d
+	}
+	return &xmlDecoder{decoder: xml.NewDecoder(br), skipBad: skipBad}
+}
+
+// firstNonSpace returns the first byte of the input that isn't whitespace, without consuming it
+func firstNonSpace(br *bufio.Reader) byte {
+	for n := 1; n <= 4096; n++ {
+		b, err := br.Peek(n)
+		if len(b) < n {
+			return 0
+		}
+		if c := b[n-1]; c != ' ' && c != '\t' && c != '\r' && c != '\n' {
+			return c
+		}
+		if err != nil {
+			return 0
+		}
+	}
+	return 0
+}
+
+// xmlDecoder decodes BFF XML records
+type xmlDecoder struct {
+	decoder *xml.Decoder
+	skipBad bool
+}
+
+func (d *xmlDecoder) Decode(record *Record) (bool, error) {
+	for {
+		record.offset = d.decoder.InputOffset()
+		token, err := d.decoder.Token()
+		if err != nil {
+			return false, err
+		}
+		if se, ok := token.(xml.StartElement); ok && se.Name.Local == "record" {
+			if !d.skipBad {
+				return false, d.decoder.DecodeElement(record, &se)
+			}
+			return decodeRecord(d.decoder, &se, record)
+		}
+	}
+}
+
+// decodeRecord decodes a record in two steps: the raw XML of the record, which fails only if the XML
+// is malformed, and then its contents, which fails if a value can't be parsed. A record that fails
+// the second step can be skipped, because the decoder is positioned after it.
+func decodeRecord(decoder *xml.Decoder, se *xml.StartElement, record *Record) (skippable bool, err error) {
+	var raw struct {
+		Inner []byte `xml:",innerxml"`
+	}
+	if err = decoder.DecodeElement(&raw, se); err != nil {
+		return false, err
+	}
+	b := make([]byte, 0, len(raw.Inner)+len("<record></record>"))
+	b = append(append(append(b, "<record>"...), raw.Inner...), "</record>"...)
+	return true, xml.Unmarshal(b, record)
+}
+
+// Document is a GEDCOM X JSON document
+type Document struct {
+	Persons       []Person       `json:"persons"`
+	Relationships []Relationship `json:"relationships"`
+}
+
+// records returns a Record for each person in the document, holding the relationships that involve
+// the person. As in BFF XML, the person's own side of each relationship is referenced as #id.
+func (document *Document) records() []Record {
+	records := make([]Record, 0, len(document.Persons))
+	for _, person := range document.Persons {
+		isSelf := func(resource string) bool {
+			if strings.HasPrefix(resource, "#") {
+				return resource[1:] == person.ID
+			}
+			return resource != "" && getArkPid(resource) == person.ID
+		}
+		var relationships []Relationship
+		for _, relationship := range document.Relationships {
+			if isSelf(relationship.Person1.Resource) {
+				relationship.Person1.Resource = "#" + person.ID
+				relationship.Person2.Resource = strings.TrimPrefix(relationship.Person2.Resource, "#")
+			} else if isSelf(relationship.Person2.Resource) {
+				relationship.Person2.Resource = "#" + person.ID
+				relationship.Person1.Resource = strings.TrimPrefix(relationship.Person1.Resource, "#")
+			} else {
+				continue
+			}
+			relationships = append(relationships, relationship)
+		}
+		records = append(records, Record{Person: person, Relationships: relationships})
+	}
+	return records
+}
+
+// jsonDecoder decodes GEDCOM X JSON documents and returns their persons one at a time
+type jsonDecoder struct {
+	decoder *json.Decoder
+	array   bool
+	records []Record
+	offset  int64
+}
+
+func (d *jsonDecoder) Decode(record *Record) (bool, error) {
+	for len(d.records) == 0 {
+		if !d.decoder.More() {
+			return false, io.EOF
+		}
+		d.offset = d.decoder.InputOffset()
+		var document Document
+		if err := d.decoder.Decode(&document); err != nil {
+			record.offset = d.offset
+			// the decoder reads past a value of the wrong type, so only syntax errors are fatal
+			_, skippable := err.(*json.UnmarshalTypeError)
+			return skippable, err
+		}
+		d.records = document.records()
+	}
+
+	index := record.index
+	*record = d.records[0]
+	record.index = index
+	record.offset = d.offset
+	d.records = d.records[1:]
+	return false, nil
+}
+
+// decodeRecords decodes the records of an input file.
+// Records are handed to emit in batches of at most bufferSize, in file order.
+// Records that can't be decoded but can be skipped are passed to skip if it is not nil;
+// otherwise they fail the file.
+func decodeRecords(d recordDecoder, bufferSize int, skip func(failure *conversionError),
+	emit func(records []Record) error) error {
+	if bufferSize < 1 {
+		bufferSize = 1
+	}
+	records := make([]Record, 0, bufferSize)
+	for recordIdx := 0; ; recordIdx++ {
+		record := Record{index: recordIdx}
+		skippable, err := d.Decode(&record)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			failure := newConversionError(stageDecode, record.offset, recordIdx, err)
+			if !skippable || skip == nil {
+				return failure
+			}
+			failure.Skipped = true
+			skip(failure)
+			continue
+		}
+		records = append(records, record)
+		if len(records) >= bufferSize {
+			if err = emit(records); err != nil {
+				return err
+			}
+			records = records[:0]
+		}
+	}
+	if len(records) > 0 {
+		return emit(records)
+	}
+	return nil
+}
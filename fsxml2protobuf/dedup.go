@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/rootsdev/fsbff/fs_extsort"
+	"github.com/rootsdev/fsbff/fs_parallel"
+	"github.com/rootsdev/fsbff/fs_reader"
+	"github.com/willf/bitset"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*
+By default each person is written the first time its id is seen, using personIdsBloom, and files are
+processed backwards so the first version seen is likely to be the latest. Within a file, a quick scan
+first finds the last record of each id, and only that record is written, however many batches of -b
+records the file is converted in. Exact deduplication instead makes two passes over the input.
+
+The first pass decodes every file and adds a version key for each record to an external sort:
+  id, modified, file index, record index
+The numbers are zero-padded, so the keys sort by id and then from oldest to newest version. The last
+key of each id is the version to keep; versions modified at the same time are ordered by file name and
+then by position in the file. The second pass converts each file, writing only the records marked in
+that file's keep bitmap.
+*/
+
+// keepFunc reports whether the record at recordIdx in its file is the version of person id to write
+type keepFunc func(recordIdx int, id string) bool
+
+// unseenKeeper keeps the last record of a person in a file, if its id hasn't been seen in earlier files.
+// The ids a file keeps are added to
+// personIdsBloom only by commit, once the file's output has been written, so the persons of a file that
+// fails aren't marked as seen and can still be written from other files. Two files converted at the same
+// time can then both keep a person, which the bloom filter's approximate deduplication already allows.
+type unseenKeeper struct {
+	last map[string]int // the index of the last record of each id in the file
+	kept map[string]bool
+}
+
+func newUnseenKeeper(last map[string]int) *unseenKeeper {
+	return &unseenKeeper{last: last, kept: make(map[string]bool)}
+}
+
+func (k *unseenKeeper) keep(recordIdx int, id string) bool {
+	if k.kept[id] {
+		return false
+	}
+	if last, ok := k.last[id]; ok && recordIdx != last {
+		return false
+	}
+	personIdsMutex.Lock()
+	seen := personIdsBloom.Test([]byte(id))
+	personIdsMutex.Unlock()
+	if seen {
+		return false
+	}
+	k.kept[id] = true
+	return true
+}
+
+// commit marks the kept ids as seen
+func (k *unseenKeeper) commit() {
+	personIdsMutex.Lock()
+	defer personIdsMutex.Unlock()
+	for id := range k.kept {
+		personIdsBloom.Add([]byte(id))
+	}
+}
+
+// lastPositions returns the index of the last record of each id
+func lastPositions(d recordDecoder, bufferSize int, skipBad bool) (map[string]int, error) {
+	// bad records are reported when the file is converted
+	var skip func(failure *conversionError)
+	if skipBad {
+		skip = func(failure *conversionError) {}
+	}
+	last := make(map[string]int)
+	err := decodeRecords(d, bufferSize, skip, func(records []Record) error {
+		for i := range records {
+			last[records[i].Person.ID] = records[i].index
+		}
+		return nil
+	})
+	return last, err
+}
+
+// scanLastPositions returns the index of the last record of each id in a file
+func scanLastPositions(inFilename string, bufferSize int, skipBad bool) (map[string]int, error) {
+	file, err := fs_reader.OpenFile(inFilename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return lastPositions(newRecordDecoder(inFilename, file, skipBad), bufferSize, skipBad)
+}
+
+// keepLatest keeps the records marked in a file's keep bitmap; nil keeps nothing
+func keepLatest(bitmap *bitset.BitSet) keepFunc {
+	return func(recordIdx int, id string) bool {
+		return bitmap != nil && bitmap.Test(uint(recordIdx))
+	}
+}
+
+// versionKey sorts the versions of a person together, oldest first
+func versionKey(id string, modified int64, fileIdx int, recordIdx int) string {
+	return fmt.Sprintf("%s\t%020d\t%08d\t%010d", id, modified, fileIdx, recordIdx)
+}
+
+// scanVersions returns the version key of every record in a file
+func scanVersions(inFilename string, fileIdx int, bufferSize int, skipBad bool) (keys []string, err error) {
+	file, err := fs_reader.OpenFile(inFilename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	// bad records are reported by the second pass
+	var skip func(failure *conversionError)
+	if skipBad {
+		skip = func(failure *conversionError) {}
+	}
+	err = decodeRecords(newRecordDecoder(inFilename, file, skipBad), bufferSize, skip, func(records []Record) error {
+		for i := range records {
+			modified := getModified(&records[i].Person, records[i].Relationships)
+			keys = append(keys, versionKey(records[i].Person.ID, modified, fileIdx, records[i].index))
+		}
+		return nil
+	})
+	return
+}
+
+// selectLatest reads sorted version keys, marks the latest version of each person in keep,
+// and returns the number of older versions
+func selectLatest(r *fs_extsort.Reader, keep []*bitset.BitSet) (superseded int, err error) {
+	mark := func(key string) error {
+		fields := strings.Split(key, "\t")
+		fileIdx, err := strconv.Atoi(fields[len(fields)-2])
+		if err != nil {
+			return err
+		}
+		recordIdx, err := strconv.Atoi(fields[len(fields)-1])
+		if err != nil {
+			return err
+		}
+		if fileIdx >= len(keep) || keep[fileIdx] == nil {
+			return fmt.Errorf("version key %q for an unknown file", key)
+		}
+		keep[fileIdx].Set(uint(recordIdx))
+		return nil
+	}
+
+	var lastKey, lastID string
+	haveLast := false
+	for r.Next() {
+		key := r.Line()
+		id := key[:strings.Index(key, "\t")]
+		if haveLast {
+			if id == lastID {
+				superseded++
+			} else if err = mark(lastKey); err != nil {
+				return
+			}
+		}
+		lastKey, lastID, haveLast = key, id, true
+	}
+	if err = r.Err(); err != nil {
+		return
+	}
+	if haveLast {
+		err = mark(lastKey)
+	}
+	return
+}
+
+// findLatestVersions is the first pass of exact deduplication; it returns the keep bitmap of each input file
+// and the number of superseded versions
+func findLatestVersions(inFilenames []string, workers int, bufferSize int, skipBad bool, tmpDir string, sortLines int) (
+	map[string]*bitset.BitSet, int, error) {
+	sorted := append([]string(nil), inFilenames...)
+	sort.Strings(sorted)
+	fileIdxs := make(map[string]int)
+	for i, inFilename := range sorted {
+		fileIdxs[inFilename] = i
+	}
+
+	sorter := fs_extsort.New(tmpDir, sortLines)
+	defer sorter.Close()
+	keep := make([]*bitset.BitSet, len(sorted))
+	err := fs_parallel.Run(context.Background(), sorted,
+		fs_parallel.Options{Workers: workers, Progress: fs_parallel.Dots(100)},
+		func(ctx context.Context, inFilename string) (interface{}, error) {
+			return scanVersions(inFilename, fileIdxs[inFilename], bufferSize, skipBad)
+		},
+		func(inFilename string, result interface{}) error {
+			keys := result.([]string)
+			keep[fileIdxs[inFilename]] = bitset.New(uint(len(keys)))
+			for _, key := range keys {
+				if err := sorter.Add(key); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	if errs, ok := err.(fs_parallel.Errors); ok {
+		// files that can't be scanned keep no records; the second pass will report them too
+		for _, fileErr := range errs {
+			log.Printf("Error scanning %s %v", fileErr.Filename, fileErr.Err)
+		}
+	} else if err != nil {
+		return nil, 0, err
+	}
+
+	r, err := sorter.Sort()
+	if err != nil {
+		return nil, 0, err
+	}
+	defer r.Close()
+	superseded, err := selectLatest(r, keep)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	latest := make(map[string]*bitset.BitSet)
+	for i, inFilename := range sorted {
+		latest[inFilename] = keep[i]
+	}
+	return latest, superseded, nil
+}
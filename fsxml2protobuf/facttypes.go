@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/rootsdev/fsbff/fs_data"
+	"io"
+	"regexp"
+	"strings"
+)
+
+/*
+Fact types are either GEDCOM X types like http://gedcomx.org/Birth, which are kept without their prefix,
+or custom types entered by users like data:,census, which are mapped by rules. Rules are read from the
+fact types file, whose lines hold the kind of rule, the pattern and the fact type, separated by tabs:
+	exact	census	Residence
+	prefix	military	MilitaryService
+	regex	^marr(iage)?\b	Marriage
+Patterns are matched against the lower-case custom type without its data:, prefix. Exact rules are tried
+first, then prefix and regex rules in file order; the file's exact rules add to and override the built-in
+ones in customTypeMap. Every fact type must be in fs_data.FactTypes. Custom types that no rule maps become
+OTHER, except that types which are URIs keep their last path segment like GEDCOM X types do. GEDCOM X types
+outside fs_data.FactTypes, like ChildOrder, are kept as well; all of these are counted for the unmapped
+types report.
+*/
+
+var customTypeMap = map[string]string{
+	"census":           "Residence",
+	"residence":        "Residence",
+	"will":             "Will",
+	"baptism":          "Baptism",
+	"confirmation":     "Confirmation",
+	"christened":       "Christening",
+	"probate":          "Probate",
+	"marriage":         "Marriage",
+	"marr":             "Marriage",
+	"marriage-license": "MarriageLicense",
+	"military service": "MilitaryService",
+	"military":         "MilitaryService",
+	"_milt":            "MilitaryService",
+	"emigration":       "Emigration",
+	"immigration":      "Immigration",
+	"arrival":          "MoveTo",
+	"move":             "MoveTo",
+}
+
+// factTypeRule is a prefix or regex rule
+type factTypeRule struct {
+	prefix   string
+	regex    *regexp.Regexp
+	factType string
+}
+
+func (rule *factTypeRule) matches(typ string) bool {
+	if rule.regex != nil {
+		return rule.regex.MatchString(typ)
+	}
+	return strings.HasPrefix(typ, rule.prefix)
+}
+
+// factTypeMapper maps fact types; it is safe for concurrent use once the rules are added
+type factTypeMapper struct {
+	exact    map[string]string
+	rules    []factTypeRule
+	unmapped *counter // unmapped types, nil unless counting
+}
+
+func newFactTypeMapper() *factTypeMapper {
+	m := &factTypeMapper{exact: make(map[string]string, len(customTypeMap))}
+	for pattern, factType := range customTypeMap {
+		m.exact[pattern] = factType
+	}
+	return m
+}
+
+// addRule adds an exact, prefix or regex rule
+func (m *factTypeMapper) addRule(kind, pattern, factType string) error {
+	if !fs_data.IsFactType(factType) {
+		return fmt.Errorf("unknown fact type %q", factType)
+	}
+	switch kind {
+	case "exact":
+		m.exact[strings.ToLower(pattern)] = factType
+	case "prefix":
+		m.rules = append(m.rules, factTypeRule{prefix: strings.ToLower(pattern), factType: factType})
+	case "regex":
+		regex, err := regexp.Compile(pattern)
+		if err != nil {
+			return err
+		}
+		m.rules = append(m.rules, factTypeRule{regex: regex, factType: factType})
+	default:
+		return fmt.Errorf("unknown kind of rule %q", kind)
+	}
+	return nil
+}
+
+// countUnmapped starts counting the types that aren't in fs_data.FactTypes or mapped by a rule
+func (m *factTypeMapper) countUnmapped() {
+	m.unmapped = newCounter()
+}
+
+// mapType returns the fact type of a GEDCOM X or custom type
+func (m *factTypeMapper) mapType(typ string) string {
+	if strings.HasPrefix(typ, "http://gedcomx.org/") {
+		t := typ[strings.LastIndex(typ, "/")+1:]
+		if !fs_data.IsFactType(t) && m.unmapped != nil {
+			m.unmapped.add(typ)
+		}
+		return t
+	}
+
+	custom := strings.ToLower(strings.TrimPrefix(typ, "data:,"))
+	if t := m.exact[custom]; t != "" {
+		return t
+	}
+	for _, rule := range m.rules {
+		if rule.matches(custom) {
+			return rule.factType
+		}
+	}
+	if strings.HasPrefix(typ, "data:,") || !strings.Contains(typ, "/") {
+		typ = custom
+	}
+	if m.unmapped != nil {
+		m.unmapped.add(typ)
+	}
+	if t := typ[strings.LastIndex(typ, "/")+1:]; t != typ && t != "" {
+		return t
+	}
+	return "OTHER"
+}
+
+// writeUnmapped writes the unmapped types and their counts, most frequent first
+func (m *factTypeMapper) writeUnmapped(w io.Writer) error {
+	return m.unmapped.write(w)
+}
+
+// readFactTypeRules reads the rules of a fact types file
+func readFactTypeRules(r io.Reader, m *factTypeMapper) error {
+	scanner := bufio.NewScanner(r)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, "\t")
+		if len(fields) != 3 {
+			return fmt.Errorf("fact types line %d: want 3 tab-separated fields", lineNumber)
+		}
+		if err := m.addRule(fields[0], fields[1], strings.TrimSpace(fields[2])); err != nil {
+			return fmt.Errorf("fact types line %d: %v", lineNumber, err)
+		}
+	}
+	return scanner.Err()
+}
@@ -2,101 +2,118 @@ package main
 
 import (
 	"bufio"
-	"bytes"
 	"code.google.com/p/goprotobuf/proto"
 	"compress/gzip"
-	"encoding/xml"
+	"context"
 	"flag"
 	"fmt"
 	"github.com/rootsdev/fsbff/fs_data"
+	"github.com/rootsdev/fsbff/fs_date"
+	"github.com/rootsdev/fsbff/fs_index"
+	"github.com/rootsdev/fsbff/fs_parallel"
+	"github.com/rootsdev/fsbff/fs_place"
+	"github.com/willf/bitset"
 	"github.com/willf/bloom"
 	"io"
 	"io/ioutil"
 	"log"
-	"math"
 	"os"
-	"regexp"
-	"runtime"
-	"strconv"
+	"path/filepath"
 	"strings"
 	"sync"
 )
 
-var stdPlaces map[string]string
-var sourceRefs map[string][]string
+var places *fs_place.Matcher
+var factTypes = newFactTypeMapper()
+var sourceRefs sourceRefLookup
+var sourceDescriptions map[string]*fs_data.FSSource
 var personIdsBloom *bloom.BloomFilter
 var personIdsMutex = &sync.Mutex{}
 
-// Data is the global BFF record structure
-type Data struct {
-	Records []Record `xml:"record"`
-}
-
 // Record contains people and relationships
 type Record struct {
 	Person        Person         `xml:"person"`
 	Relationships []Relationship `xml:"relationship"`
+	index         int            // position of the record in its file
+	offset        int64          // byte offset of the record in the uncompressed input
 }
 
 // Person contains information about a person
 type Person struct {
-	ID     string `xml:"id,attr"`
-	Gender Gender `xml:"gender"`
-	Names  []Name `xml:"name"`
-	Facts  []Fact `xml:"fact"`
+	ID     string `xml:"id,attr" json:"id"`
+	Gender Gender `xml:"gender" json:"gender"`
+	Names  []Name `xml:"name" json:"names"`
+	Facts  []Fact `xml:"fact" json:"facts"`
 }
 
 // Relationship contains information about a relationship
 type Relationship struct {
-	Type    string         `xml:"type,attr"` // http://gedcomx.org/ParentChild or http://gedcomx.org/Couple
-	Person1 PersonResource `xml:"person1"`   // https://familysearch.org/ark:/61903/4:1:K8PV-6M7 or #218J-DF3
-	Person2 PersonResource `xml:"person2"`
-	Facts   []Fact         `xml:"fact"`
+	Type        string         `xml:"type,attr" json:"type"` // http://gedcomx.org/ParentChild or http://gedcomx.org/Couple
+	Attribution Attribution    `xml:"attribution" json:"attribution"`
+	Person1     PersonResource `xml:"person1" json:"person1"` // https://familysearch.org/ark:/61903/4:1:K8PV-6M7 or #218J-DF3
+	Person2     PersonResource `xml:"person2" json:"person2"`
+	Facts       []Fact         `xml:"fact" json:"facts"`
 }
 
 // PersonResource contains a resource id
 type PersonResource struct {
-	Resource string `xml:"resource,attr"`
+	Resource string `xml:"resource,attr" json:"resource"`
 }
 
 // Gender contains the gender fact
 type Gender struct {
-	Type        string      `xml:"type,attr"` // http://gedcomx.org/Male or Female or Unknown
-	Attribution Attribution `xml:"attribution"`
+	Type        string      `xml:"type,attr" json:"type"` // http://gedcomx.org/Male or Female or Unknown
+	Attribution Attribution `xml:"attribution" json:"attribution"`
 }
 
-// Name we don't capture names; only their attribution
+// Name contains the name forms of a name
 type Name struct {
-	Attribution Attribution `xml:"attribution"`
+	Type        string      `xml:"type,attr" json:"type"` // http://gedcomx.org/BirthName, MarriedName, AlsoKnownAs, ...
+	Preferred   bool        `xml:"preferred,attr" json:"preferred"`
+	Attribution Attribution `xml:"attribution" json:"attribution"`
+	NameForms   []NameForm  `xml:"nameForm" json:"nameForms"`
+}
+
+// NameForm contains the full text of a name and its parts
+type NameForm struct {
+	FullText string     `xml:"fullText" json:"fullText"`
+	Parts    []NamePart `xml:"part" json:"parts"`
+}
+
+// NamePart contains a single part of a name
+type NamePart struct {
+	Type  string `xml:"type,attr" json:"type"` // http://gedcomx.org/Given or Surname or Prefix or Suffix
+	Value string `xml:"value,attr" json:"value"`
 }
 
 // Fact contains all other facts
 type Fact struct {
-	Type        string      `xml:"type,attr"`
-	Attribution Attribution `xml:"attribution"`
-	Date        Date        `xml:"date"`
-	Place       Place       `xml:"place"`
-	Value       string      `xml:"value"`
+	Type        string      `xml:"type,attr" json:"type"`
+	Attribution Attribution `xml:"attribution" json:"attribution"`
+	Date        Date        `xml:"date" json:"date"`
+	Place       Place       `xml:"place" json:"place"`
+	Value       string      `xml:"value" json:"value"`
 }
 
 // Date we only capture the user-entered text
 type Date struct {
-	Original string `xml:"original"`
+	Original string `xml:"original" json:"original"`
 }
 
 // Place we only capture the user-entered text
 type Place struct {
-	Original string `xml:"original"`
+	Original string `xml:"original" json:"original"`
 }
 
-// Attribution contains the contributor
+// Attribution contains the contributor and when the change was made
 type Attribution struct {
-	Contributor Contributor `xml:"contributor"`
+	Contributor Contributor `xml:"contributor" json:"contributor"`
+	Modified    int64       `xml:"modified" json:"modified"` // milliseconds since the epoch
 }
 
 // Contributor contains information about the user
 type Contributor struct {
-	ResourceID string `xml:"resourceId,attr"`
+	ResourceID string `xml:"resourceId,attr" json:"resourceId"`
 }
 
 func getGender(person *Person) (gender fs_data.FSGender) {
@@ -110,6 +127,59 @@ func getGender(person *Person) (gender fs_data.FSGender) {
 	return
 }
 
+func getName(name Name) *fs_data.FSName {
+	// only the first name form is kept; the others are typically transliterations
+	if len(name.NameForms) == 0 {
+		return nil
+	}
+	nameForm := name.NameForms[0]
+	var given, surname []string
+	for _, part := range nameForm.Parts {
+		value := strings.TrimSpace(part.Value)
+		if value == "" {
+			continue
+		}
+		switch part.Type {
+		case "http://gedcomx.org/Given":
+			given = append(given, value)
+		case "http://gedcomx.org/Surname":
+			surname = append(surname, value)
+		}
+	}
+	fullText := strings.TrimSpace(nameForm.FullText)
+	if fullText == "" && len(given) == 0 && len(surname) == 0 {
+		return nil
+	}
+
+	fsName := &fs_data.FSName{}
+	if len(given) > 0 {
+		fsName.Given = proto.String(strings.Join(given, " "))
+	}
+	if len(surname) > 0 {
+		fsName.Surname = proto.String(strings.Join(surname, " "))
+	}
+	if fullText != "" {
+		fsName.FullText = &fullText
+	}
+	if name.Type != "" {
+		fsName.Type = proto.String(name.Type[strings.LastIndex(name.Type, "/")+1:])
+	}
+	if name.Preferred {
+		fsName.Preferred = proto.Bool(true)
+	}
+	return fsName
+}
+
+func getNames(person *Person) (fsNames []*fs_data.FSName) {
+	for _, name := range person.Names {
+		fsName := getName(name)
+		if fsName != nil {
+			fsNames = append(fsNames, fsName)
+		}
+	}
+	return
+}
+
 func getContributors(person *Person, relationships []Relationship) (contributors []string) {
 	contributorSet := make(map[string]bool)
 	contributorSet[person.Gender.Attribution.Contributor.ResourceID] = true
@@ -120,6 +190,7 @@ func getContributors(person *Person, relationships []Relationship) (contributors
 		contributorSet[fact.Attribution.Contributor.ResourceID] = true
 	}
 	for _, relationship := range relationships {
+		contributorSet[relationship.Attribution.Contributor.ResourceID] = true
 		for _, fact := range relationship.Facts {
 			contributorSet[fact.Attribution.Contributor.ResourceID] = true
 		}
@@ -133,72 +204,86 @@ func getContributors(person *Person, relationships []Relationship) (contributors
 	return
 }
 
-func getSources(person *Person) (sources []*fs_data.FSSource) {
-	for _, ref := range sourceRefs[person.ID] {
-		sources = append(sources, &fs_data.FSSource{SourceId: &ref})
+// getModified returns the latest modified timestamp of any attribution in the record
+func getModified(person *Person, relationships []Relationship) (modified int64) {
+	attributions := []Attribution{person.Gender.Attribution}
+	for _, name := range person.Names {
+		attributions = append(attributions, name.Attribution)
+	}
+	for _, fact := range person.Facts {
+		attributions = append(attributions, fact.Attribution)
+	}
+	for _, relationship := range relationships {
+		attributions = append(attributions, relationship.Attribution)
+		for _, fact := range relationship.Facts {
+			attributions = append(attributions, fact.Attribution)
+		}
+	}
+
+	for _, attribution := range attributions {
+		if attribution.Modified > modified {
+			modified = attribution.Modified
+		}
 	}
 	return
 }
 
-var customTypeMap = map[string]string{
-	"census":           "Residence",
-	"residence":        "Residence",
-	"will":             "Will",
-	"baptism":          "Baptism",
-	"confirmation":     "Confirmation",
-	"christened":       "Christening",
-	"probate":          "Probate",
-	"marriage":         "Marriage",
-	"marr":             "Marriage",
-	"marriage-license": "MarriageLicense",
-	"military service": "MilitaryService",
-	"military":         "MilitaryService",
-	"_milt":            "MilitaryService",
-	"emigration":       "Emigration",
-	"immigration":      "Immigration",
-	"arrival":          "MoveTo",
-	"move":             "MoveTo",
+// sourceRefLookup returns the source ids of a person
+type sourceRefLookup interface {
+	Lookup(personID string) ([]string, error)
 }
 
-func getFactType(typ string) string {
-	if strings.HasPrefix(typ, "http://gedcomx.org/") {
-		typ = typ[strings.LastIndex(typ, "/")+1:]
-	} else {
-		typ = customTypeMap[strings.ToLower(typ[6:])] // skip data:,
-		if typ == "" {
-			typ = "OTHER"
+// sourceRefMap holds the whole source references file in memory; fs_index.Index looks them up on disk
+type sourceRefMap map[string][]string
+
+func (m sourceRefMap) Lookup(personID string) ([]string, error) {
+	return m[personID], nil
+}
+
+func getSources(person *Person) (sources []*fs_data.FSSource, err error) {
+	if sourceRefs == nil {
+		return nil, nil
+	}
+	refs, err := sourceRefs.Lookup(person.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, ref := range refs {
+		source := &fs_data.FSSource{SourceId: proto.String(ref)}
+		if description := sourceDescriptions[ref]; description != nil {
+			source.Title = description.Title
+			source.About = description.About
+			source.Citation = description.Citation
 		}
+		sources = append(sources, source)
 	}
-	return typ
+	return sources, nil
 }
 
-var yearRegex = regexp.MustCompile("\\D*(\\d{4})\\D*")
+func getFactType(typ string) string {
+	return factTypes.mapType(typ)
+}
 
 func getYear(date string) int32 {
-	s := yearRegex.FindStringSubmatch(date)
-	if len(s) != 0 && s[1] != "" {
-		year, _ := strconv.ParseInt(s[1], 10, 32)
-		return int32(year)
-	}
-	return 0
+	return fs_date.Parse(date).Year
 }
 
-func getStdPlace(place string) string {
+// getStdPlace returns the standardized name of a place and its details, if the standardized places file has them
+func getStdPlace(place string) (string, *fs_data.FSPlace) {
 	place = strings.Replace(place, "\t", " ", -1)
-	stdPlace := stdPlaces[place]
-	if stdPlace != "" {
-		return stdPlace
+	if places == nil {
+		return place, nil
 	}
-	return place
+	return places.Standardize(place)
 }
 
 func getFact(fact Fact) *fs_data.FSFact {
 	t := getFactType(fact.Type)
-	year := getYear(fact.Date.Original)
-	place := getStdPlace(fact.Place.Original)
+	date := fs_date.Parse(fact.Date.Original)
+	place, stdPlace := getStdPlace(fact.Place.Original)
 
 	// omit OTHER facts that don't have a year or place
-	if t == "OTHER" && year == 0 && place == "" {
+	if t == "OTHER" && date.Year == 0 && place == "" {
 		return nil
 	}
 
@@ -206,11 +291,10 @@ func getFact(fact Fact) *fs_data.FSFact {
 	fsFact := &fs_data.FSFact{
 		Type: &t,
 	}
-	if year != 0 {
-		fsFact.Year = &year
-	}
+	date.SetFact(fsFact)
 	if place != "" {
 		fsFact.Place = &place
+		fsFact.StdPlace = stdPlace
 	}
 	if fact.Value != "" {
 		fsFact.Value = &fact.Value
@@ -219,21 +303,13 @@ func getFact(fact Fact) *fs_data.FSFact {
 	return fsFact
 }
 
-func getFacts(person *Person, relationships []Relationship) (fsFacts []*fs_data.FSFact) {
-	for _, fact := range person.Facts {
+func getFacts(facts []Fact) (fsFacts []*fs_data.FSFact) {
+	for _, fact := range facts {
 		fsFact := getFact(fact)
 		if fsFact != nil {
 			fsFacts = append(fsFacts, fsFact)
 		}
 	}
-	for _, relationship := range relationships {
-		for _, fact := range relationship.Facts {
-			fsFact := getFact(fact)
-			if fsFact != nil {
-				fsFacts = append(fsFacts, fsFact)
-			}
-		}
-	}
 	return
 }
 
@@ -241,18 +317,44 @@ func getArkPid(ark string) string {
 	return ark[strings.LastIndex(ark, ":")+1:]
 }
 
-func getRelationships(relationships []Relationship) (parents []string, children []string, spouses []string) {
+func getRelationship(relationship Relationship, typ fs_data.FSRelationshipType, relID string) *fs_data.FSRelationship {
+	contributorSet := make(map[string]bool)
+	contributorSet[relationship.Attribution.Contributor.ResourceID] = true
+	for _, fact := range relationship.Facts {
+		contributorSet[fact.Attribution.Contributor.ResourceID] = true
+	}
+	var contributors []string
+	for contributor := range contributorSet {
+		if contributor != "" {
+			contributors = append(contributors, contributor)
+		}
+	}
+
+	return &fs_data.FSRelationship{
+		Type:         &typ,
+		RelatedId:    &relID,
+		Facts:        getFacts(relationship.Facts),
+		Contributors: contributors,
+	}
+}
+
+func getRelationships(relationships []Relationship) (parents []string, children []string, spouses []string,
+	fsRelationships []*fs_data.FSRelationship) {
 	for _, relationship := range relationships {
 		if relationship.Type == "http://gedcomx.org/ParentChild" {
 			if strings.HasPrefix(relationship.Person1.Resource, "#") {
 				relID := getArkPid(relationship.Person2.Resource)
 				if relID != "" {
 					children = append(children, relID)
+					fsRelationships = append(fsRelationships,
+						getRelationship(relationship, fs_data.FSRelationshipType_CHILD, relID))
 				}
 			} else {
 				relID := getArkPid(relationship.Person1.Resource)
 				if relID != "" {
 					parents = append(parents, relID)
+					fsRelationships = append(fsRelationships,
+						getRelationship(relationship, fs_data.FSRelationshipType_PARENT, relID))
 				}
 			}
 		} else if relationship.Type == "http://gedcomx.org/Couple" {
@@ -264,6 +366,8 @@ func getRelationships(relationships []Relationship) (parents []string, children
 			}
 			if relID != "" {
 				spouses = append(spouses, relID)
+				fsRelationships = append(fsRelationships,
+					getRelationship(relationship, fs_data.FSRelationshipType_SPOUSE, relID))
 			}
 		} else { // Unknown
 		}
@@ -271,26 +375,41 @@ func getRelationships(relationships []Relationship) (parents []string, children
 	return
 }
 
-func readStdPlaces(file *os.File) map[string]string {
-	stdPlaces := make(map[string]string)
+func readSourceRefs(file *os.File) sourceRefMap {
+	sourceRefs := make(sourceRefMap)
 	scanner := bufio.NewScanner(file)
 	for scanner.Scan() {
 		line := scanner.Text()
-		fields := strings.Split(line, "\t")
-		stdPlaces[fields[0]] = fields[1]
+		fields := strings.SplitN(line, ",", 2)
+		sourceRefs[fields[0]] = append(sourceRefs[fields[0]], fields[1])
 	}
-	return stdPlaces
+	return sourceRefs
 }
 
-func readSourceRefs(file *os.File) map[string][]string {
-	sourceRefs := make(map[string][]string)
-	scanner := bufio.NewScanner(file)
+// readSourceDescriptions reads the output of fetchsources: lines of source id, about, title and citation
+// separated by |; lines written before fetchsources wrote citations have only the first three
+func readSourceDescriptions(r io.Reader) (map[string]*fs_data.FSSource, error) {
+	descriptions := make(map[string]*fs_data.FSSource)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
 	for scanner.Scan() {
-		line := scanner.Text()
-		fields := strings.SplitN(line, ",", 2)
-		sourceRefs[fields[0]] = append(sourceRefs[fields[0]], fields[1])
+		fields := strings.SplitN(scanner.Text(), "|", 4)
+		if len(fields) < 3 || fields[0] == "" {
+			continue
+		}
+		description := &fs_data.FSSource{}
+		if fields[1] != "" {
+			description.About = proto.String(fields[1])
+		}
+		if fields[2] != "" {
+			description.Title = proto.String(fields[2])
+		}
+		if len(fields) == 4 && fields[3] != "" {
+			description.Citation = proto.String(fields[3])
+		}
+		descriptions[fields[0]] = description
 	}
-	return sourceRefs
+	return descriptions, scanner.Err()
 }
 
 func check(err error) {
@@ -299,116 +418,300 @@ func check(err error) {
 	}
 }
 
-func processFile(filename string, gzipOutput bool) (recordCount int) {
-	inOut := strings.SplitN(filename, "\t", 2)
-	inFilename := inOut[0]
-	outFilename := inOut[1]
-	var file io.ReadCloser
-	var err error
+// personWriter writes marshaled persons to an output file
+type personWriter interface {
+	WritePerson(id string, b []byte) error
+}
 
-	file, err = os.Open(inFilename)
-	if err != nil {
-		log.Printf("Error opening %s %v", inFilename, err)
-		return 0
+// legacyWriter writes persons as the repeated field of a single FamilySearchPersons message
+type legacyWriter struct {
+	w io.Writer
+}
+
+// personsTag is the wire tag of FamilySearchPersons.persons (field 1, length-delimited).
+// Writing each person prefixed by this tag produces a valid FamilySearchPersons message,
+// so persons can be emitted one at a time instead of marshaling the whole file at once.
+var personsTag = proto.EncodeVarint(1<<3 | 2)
+
+func (l legacyWriter) WritePerson(id string, b []byte) error {
+	if _, err := l.w.Write(personsTag); err != nil {
+		return err
 	}
-	defer file.Close()
+	if _, err := l.w.Write(proto.EncodeVarint(uint64(len(b)))); err != nil {
+		return err
+	}
+	_, err := l.w.Write(b)
+	return err
+}
 
-	if inFilename[len(inFilename)-3:] == ".gz" {
-		file, err = gzip.NewReader(file)
+// streamWriter writes persons to a stream file
+type streamWriter struct {
+	*fs_data.StreamWriter
+}
+
+func (s streamWriter) WritePerson(id string, b []byte) error {
+	return s.WriteMarshaled(b)
+}
+
+// outputFile is an output file of persons, gzipped and in stream format if requested
+type outputFile struct {
+	personWriter
+	file *os.File
+	buf  *bufio.Writer
+	zw   *gzip.Writer
+	sw   *fs_data.StreamWriter
+}
+
+func createOutputFile(filename string, gzipOutput bool, streamOutput bool) (*outputFile, error) {
+	file, err := os.Create(filename)
+	if err != nil {
+		return nil, err
+	}
+	o := &outputFile{file: file, buf: bufio.NewWriter(file)}
+	var w io.Writer = o.buf
+	if gzipOutput {
+		o.zw = gzip.NewWriter(o.buf)
+		w = o.zw
+	}
+	o.personWriter = legacyWriter{w}
+	if streamOutput {
+		o.sw, err = fs_data.NewStreamWriter(w, "fsxml2protobuf", filepath.Dir(filename))
 		if err != nil {
-			log.Printf("Error reading %s %v", inFilename, err)
-			return 0
+			file.Close()
+			return nil, err
 		}
-		defer file.Close()
+		o.personWriter = streamWriter{o.sw}
 	}
+	return o, nil
+}
 
-	var data Data
-	err = xml.NewDecoder(file).Decode(&data)
-	if err != nil {
-		log.Printf("Error decoding %s %v", inFilename, err)
-		return 0
+// Close finishes writing the file and closes it
+func (o *outputFile) Close() error {
+	var err error
+	if o.sw != nil {
+		err = o.sw.Close()
 	}
+	if o.zw != nil && err == nil {
+		err = o.zw.Close()
+	}
+	if err == nil {
+		err = o.buf.Flush()
+	}
+	if closeErr := o.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
 
-	fsPersons := new(fs_data.FamilySearchPersons)
-	fsPersons.Persons = make([]*fs_data.FamilySearchPerson, 0)
+// Abort closes the file without finishing it; it does nothing after Close
+func (o *outputFile) Abort() {
+	if o.sw != nil {
+		o.sw.Abort()
+	}
+	o.file.Close()
+}
 
-	// Process in reverse order so we're more likely to keep the most recent version of each person
-	for i := len(data.Records) - 1; i >= 0; i-- {
-		person := data.Records[i].Person
-		relationships := data.Records[i].Relationships
+func getPerson(person *Person, relationships []Relationship) (*fs_data.FamilySearchPerson, error) {
+	sources, err := getSources(person)
+	if err != nil {
+		return nil, err
+	}
+	gender := getGender(person)
+	parents, children, spouses, fsRelationships := getRelationships(relationships)
+	fsPerson := &fs_data.FamilySearchPerson{
+		Id:            &person.ID,
+		Gender:        &gender,
+		Names:         getNames(person),
+		Contributors:  getContributors(person, relationships),
+		Sources:       sources,
+		Facts:         getFacts(person.Facts),
+		Parents:       parents,
+		Children:      children,
+		Spouses:       spouses,
+		Relationships: fsRelationships,
+	}
+	if modified := getModified(person, relationships); modified != 0 {
+		fsPerson.Modified = &modified
+	}
+	return fsPerson, nil
+}
+
+// writeRecords converts a batch of records and writes the versions that keep selects.
+// Records that can't be marshaled are passed to skip if it is not nil; otherwise they fail the file.
+// Records are processed in reverse order; which version of a person is written is up to keep.
+func writeRecords(w personWriter, records []Record, keep keepFunc, skip func(failure *conversionError)) (
+	recordCount int, err error) {
+	for i := len(records) - 1; i >= 0; i-- {
+		record := &records[i]
 
 		// process each person only once
-		// better go style might put this in a separate goroutine with channels to communicate
-		personIdsMutex.Lock()
-		isSeen := personIdsBloom.TestAndAdd([]byte(person.ID))
-		personIdsMutex.Unlock()
-
-		if !isSeen {
-			gender := getGender(&person)
-			parents, children, spouses := getRelationships(relationships)
-			fsPerson := &fs_data.FamilySearchPerson{
-				Id:           &person.ID,
-				Gender:       &gender,
-				Contributors: getContributors(&person, relationships),
-				Sources:      getSources(&person),
-				Facts:        getFacts(&person, relationships),
-				Parents:      parents,
-				Children:     children,
-				Spouses:      spouses,
+		if !keep(record.index, record.Person.ID) {
+			continue
+		}
+		fsPerson, err := getPerson(&record.Person, record.Relationships)
+		if err != nil {
+			return recordCount, newConversionError(stageSources, record.offset, record.index, err)
+		}
+		b, err := proto.Marshal(fsPerson)
+		if err != nil {
+			failure := newConversionError(stageMarshal, record.offset, record.index, err)
+			if skip == nil {
+				return recordCount, failure
 			}
-			fsPersons.Persons = append(fsPersons.Persons, fsPerson)
-			recordCount++
+			failure.Skipped = true
+			skip(failure)
+			continue
+		}
+		if err = w.WritePerson(record.Person.ID, b); err != nil {
+			return recordCount, newConversionError(stageWrite, record.offset, record.index, err)
+		}
+		recordCount++
+	}
+	return
+}
+
+// processFile converts a single input file; a file that fails is logged and its output removed.
+// When writing shards, the file's persons are spooled and copied to the shards only if it succeeds.
+// commit, if not nil, is called once the file's output has been written successfully.
+func processFile(filename string, gzipOutput bool, streamOutput bool, bufferSize int, keep keepFunc,
+	commit func(), skipBad bool) (result fileResult) {
+	inOut := strings.SplitN(filename, "\t", 2)
+	inFilename := inOut[0]
+	outFilename := inOut[1]
+	fail := func(failure *conversionError) fileResult {
+		failure.File = inFilename
+		log.Printf("Error converting %s %v", inFilename, failure)
+		if shards == nil {
+			os.Remove(outFilename)
+		}
+		return fileResult{failures: append(result.failures, failure), failed: true}
+	}
+	var skip func(failure *conversionError)
+	if skipBad {
+		skip = func(failure *conversionError) {
+			failure.File = inFilename
+			result.failures = append(result.failures, failure)
 		}
 	}
-	b, err := proto.Marshal(fsPersons)
+
+	file, err := os.Open(inFilename)
 	if err != nil {
-		log.Printf("Error marshaling %s %v", inFilename, err)
-		return 0
+		return fail(newConversionError(stageOpen, 0, 0, err))
 	}
+	defer file.Close()
 
-	if gzipOutput {
-		var buf bytes.Buffer
-		w := gzip.NewWriter(&buf)
-		w.Write(b)
-		w.Close()
-		b = buf.Bytes()
+	in := &errorReader{r: file}
+	readStage := stageOpen
+	if strings.HasSuffix(inFilename, ".gz") {
+		zr, err := gzip.NewReader(file)
+		if err != nil {
+			return fail(newConversionError(stageGunzip, 0, 0, err))
+		}
+		defer zr.Close()
+		in = &errorReader{r: zr}
+		readStage = stageGunzip
 	}
 
-	err = ioutil.WriteFile(outFilename, b, 0644)
-	if err != nil {
-		log.Printf("Error writing %s %v", outFilename, err)
-		return 0
+	var pw personWriter
+	var out *outputFile
+	var shardBuf *shardBuffer
+	if shards != nil {
+		shardBuf, err = shards.newBuffer()
+		if err != nil {
+			return fail(newConversionError(stageWrite, 0, 0, err))
+		}
+		defer shardBuf.remove()
+		pw = shardBuf
+	} else {
+		out, err = createOutputFile(outFilename, gzipOutput, streamOutput)
+		if err != nil {
+			return fail(newConversionError(stageWrite, 0, 0, err))
+		}
+		defer out.Abort()
+		pw = out
 	}
 
-	return
-}
+	recordCount := 0
+	recordsRead := 0
+	err = decodeRecords(newRecordDecoder(inFilename, in, skipBad), bufferSize, skip, func(records []Record) error {
+		count, err := writeRecords(pw, records, keep, skip)
+		recordCount += count
+		recordsRead = records[len(records)-1].index + 1
+		return err
+	})
+	if err != nil {
+		failure := err.(*conversionError)
+		if failure.Stage == stageDecode && in.err != nil {
+			// the XML decoder reports read errors as its own
+			failure.Stage = readStage
+		}
+		return fail(failure)
+	}
 
-func processFiles(fileNames chan string, results chan int, gzipOutput bool) {
-	for fileName := range fileNames {
-		results <- processFile(fileName, gzipOutput)
+	if shardBuf != nil {
+		if err = shardBuf.commit(); err != nil {
+			// some of the persons may be in the shards already, so neither failing the file nor rerunning it is safe
+			result = fail(newConversionError(stageWrite, in.n, recordsRead, err))
+			result.fatal = fmt.Errorf("writing the persons of %s to the shards: %v", inFilename, err)
+			return
+		}
+	} else if err = out.Close(); err != nil {
+		return fail(newConversionError(stageWrite, in.n, recordsRead, err))
+	}
+	if commit != nil {
+		commit()
 	}
+
+	result.records = recordCount
+	return
 }
 
 var stdPlacesFilename = flag.String("p", "", "standardized places filename")
+var abbreviationsFilename = flag.String("a", "", "place abbreviations filename (optional)")
+var placeReportFilename = flag.String("placereport", "", "filename of a report of unmatched and partly matched places, most frequent first")
+var factTypesFilename = flag.String("facttypes", "", "fact type rules filename (optional)")
+var typeReportFilename = flag.String("typereport", "", "filename of a report of unmapped fact types, most frequent first")
 var sourceRefsFilename = flag.String("s", "", "source references filename")
-var inFilename = flag.String("i", "", "input filename or directory")
+var sourceIndexFilename = flag.String("si", "", "source references index built by buildindex, used instead of -s")
+var sourceDescriptionsFilename = flag.String("sd", "", "source descriptions filename written by fetchsources (optional)")
+var inFilename = flag.String("i", "", "input filename or directory of BFF XML or GEDCOM X JSON files")
 var outFilename = flag.String("o", "", "output filename or directory")
 var numWorkers = flag.Int("w", 1, "number of workers")
 var gzipOutput = flag.Bool("z", false, "gzip output")
+var bufferSize = flag.Int("b", 50000, "number of records buffered per file before writing")
+var checkpointDir = flag.String("c", "", "checkpoint directory")
+var checkpointInterval = flag.Int("ci", 1000, "number of files processed between checkpoints")
+var resume = flag.Bool("r", false, "resume from the checkpoint in the checkpoint directory")
+var exact = flag.Bool("exact", false, "deduplicate exactly by modified timestamp, making an extra pass over the input")
+var tmpDir = flag.String("t", "", "temporary directory for sorting versions when deduplicating exactly, and for spooling shards")
+var sortLines = flag.Int("sortlines", 5000000, "number of versions sorted in memory at a time when deduplicating exactly")
+var streamOutput = flag.Bool("stream", false, "write length-delimited stream files instead of FamilySearchPersons messages")
+var numShards = flag.Int("shards", 0, "write persons to this many shard files in the output directory, partitioned by person ID")
+var reportFilename = flag.String("e", "", "filename of a JSON-lines report of failed files and skipped records")
+var maxFailRate = flag.Float64("maxfailrate", 0, "exit with an error if more than this fraction of files fail")
+var skipBad = flag.Bool("skipbad", false, "skip records that can't be converted instead of failing the whole file")
 
 func main() {
 	flag.Parse()
 
-	numCPU := runtime.NumCPU()
-	fmt.Printf("Number of CPUs=%d\n", numCPU)
-	runtime.GOMAXPROCS(int(math.Min(float64(numCPU), float64(*numWorkers))))
+	fmt.Printf("Number of CPUs=%d\n", fs_parallel.SetMaxProcs(*numWorkers))
+	if *resume && *checkpointDir == "" {
+		log.Fatal("resuming requires a checkpoint directory (-c)")
+	}
+	if *exact && (*checkpointDir != "" || *resume) {
+		log.Fatal("exact deduplication cannot be combined with checkpoints")
+	}
+	if *numShards > 0 && (*checkpointDir != "" || *resume) {
+		log.Fatal("sharded output cannot be combined with checkpoints")
+	}
 
-	numFiles := 0
-	fileNames := make(chan string, 100000)
+	var fileNames []string
 	fileInfo, err := os.Stat(*inFilename)
 	check(err)
 	if fileInfo.IsDir() {
-		personIdsBloom = bloom.New(80000000000, 70) // assume reading 800M people, p=1*E-21
+		if !*resume && !*exact {
+			personIdsBloom = bloom.New(80000000000, 70) // assume reading 800M people, p=1*E-21
+		}
 		fileInfos, err := ioutil.ReadDir(*inFilename)
 		check(err)
 		// process files (roughly) backwards to increase likelihood of processing latest version of each person
@@ -419,48 +722,200 @@ func main() {
 				start = len("gedcomxb.")
 			}
 			end := strings.Index(fileInfo.Name(), ".xml")
+			if end < 0 {
+				end = strings.Index(fileInfo.Name(), ".json")
+			}
+			if end < 0 {
+				end = len(fileInfo.Name())
+			}
 			suffix := ""
 			if *gzipOutput {
 				suffix = ".gz"
 			}
-			fileNames <- *inFilename + "/" + fileInfo.Name() + "\t" +
-				*outFilename + "/" + fileInfo.Name()[start:end] + ".protobuf" + suffix
-			numFiles++
+			fileNames = append(fileNames, *inFilename+"/"+fileInfo.Name()+"\t"+
+				*outFilename+"/"+fileInfo.Name()[start:end]+".protobuf"+suffix)
 		}
 	} else {
-		personIdsBloom = bloom.New(3000000, 70) // assume reading 30K people, p=1*E-21
-		fileNames <- *inFilename + "\t" + *outFilename
-		numFiles++
+		if !*resume && !*exact {
+			personIdsBloom = bloom.New(3000000, 70) // assume reading 30K people, p=1*E-21
+		}
+		fileNames = append(fileNames, *inFilename+"\t"+*outFilename)
+	}
+
+	if *resume {
+		fmt.Println("Reading checkpoint")
+		completed, filter, err := readCheckpoint(*checkpointDir)
+		check(err)
+		personIdsBloom = filter
+		completedFiles = completed
+		completedSet := make(map[string]bool)
+		for _, inFilename := range completed {
+			completedSet[inFilename] = true
+		}
+		remaining := make([]string, 0, len(fileNames))
+		for _, fileName := range fileNames {
+			if !completedSet[strings.SplitN(fileName, "\t", 2)[0]] {
+				remaining = append(remaining, fileName)
+			}
+		}
+		fmt.Printf("Skipping %d completed files\n", len(fileNames)-len(remaining))
+		fileNames = remaining
 	}
-	close(fileNames)
 
 	fmt.Println("Reading places")
+	if *abbreviationsFilename != "" {
+		abbreviationsFile, err := os.Open(*abbreviationsFilename)
+		check(err)
+		check(fs_place.ReadAbbreviations(abbreviationsFile, fs_place.LevelAbbreviations, fs_place.WordAbbreviations))
+		abbreviationsFile.Close()
+	}
+	places = fs_place.NewMatcher(fs_place.LevelAbbreviations, fs_place.WordAbbreviations)
+	var unmatchedPlaces *counter
+	if *placeReportFilename != "" {
+		unmatchedPlaces = newCounter()
+		places.Unmatched = unmatchedPlaces.add
+	}
 	stdPlacesFile, err := os.Open(*stdPlacesFilename)
 	check(err)
 	defer stdPlacesFile.Close()
-	stdPlaces = readStdPlaces(stdPlacesFile)
+	check(fs_place.ReadStdPlaces(stdPlacesFile, places))
 
-	fmt.Println("Reading sources")
-	sourceRefsFile, err := os.Open(*sourceRefsFilename)
-	check(err)
-	defer sourceRefsFile.Close()
-	sourceRefs = readSourceRefs(sourceRefsFile)
+	if *factTypesFilename != "" {
+		fmt.Println("Reading fact types")
+		factTypesFile, err := os.Open(*factTypesFilename)
+		check(err)
+		check(readFactTypeRules(factTypesFile, factTypes))
+		factTypesFile.Close()
+	}
+	if *typeReportFilename != "" {
+		factTypes.countUnmapped()
+	}
 
-	fmt.Print("Processing files")
-	results := make(chan int)
+	if *sourceIndexFilename != "" {
+		fmt.Println("Opening sources index")
+		sourceIndex, err := fs_index.Open(*sourceIndexFilename)
+		check(err)
+		defer sourceIndex.Close()
+		sourceRefs = sourceIndex
+	} else {
+		fmt.Println("Reading sources")
+		sourceRefsFile, err := os.Open(*sourceRefsFilename)
+		check(err)
+		sourceRefs = readSourceRefs(sourceRefsFile)
+		sourceRefsFile.Close()
+	}
+	if *sourceDescriptionsFilename != "" {
+		sourceDescriptionsFile, err := os.Open(*sourceDescriptionsFilename)
+		check(err)
+		sourceDescriptions, err = readSourceDescriptions(sourceDescriptionsFile)
+		check(err)
+		sourceDescriptionsFile.Close()
+	}
 
-	for i := 0; i < *numWorkers; i++ {
-		go processFiles(fileNames, results, *gzipOutput)
+	var latest map[string]*bitset.BitSet
+	if *exact {
+		fmt.Print("Finding latest versions")
+		inFilenames := make([]string, 0, len(fileNames))
+		for _, fileName := range fileNames {
+			inFilenames = append(inFilenames, strings.SplitN(fileName, "\t", 2)[0])
+		}
+		var superseded int
+		latest, superseded, err = findLatestVersions(inFilenames, *numWorkers, *bufferSize, *skipBad, *tmpDir, *sortLines)
+		check(err)
+		fmt.Printf("\nVersions superseded=%d\n", superseded)
 	}
 
+	var report *failureReport
+	if *reportFilename != "" {
+		report, err = createFailureReport(*reportFilename)
+		check(err)
+	}
+
+	if *numShards > 0 {
+		check(os.MkdirAll(*outFilename, 0755))
+		shards, err = createShards(*outFilename, *numShards, *gzipOutput, *streamOutput, *tmpDir)
+		check(err)
+	}
+
+	fmt.Print("Processing files")
 	recordsProcessed := 0
 	filesProcessed := 0
-	for i := 0; i < numFiles; i++ {
-		recordsProcessed += <-results
-		filesProcessed++
-		if filesProcessed%100 == 0 {
-			fmt.Print(".")
-		}
+	filesFailed := 0
+	recordsSkipped := 0
+	err = fs_parallel.Run(context.Background(), fileNames,
+		fs_parallel.Options{Workers: *numWorkers, Progress: fs_parallel.Dots(100)},
+		func(ctx context.Context, fileName string) (interface{}, error) {
+			checkpointLock.RLock()
+			defer checkpointLock.RUnlock()
+			inFilename := strings.SplitN(fileName, "\t", 2)[0]
+			var keep keepFunc
+			var commit func()
+			if latest != nil {
+				keep = keepLatest(latest[inFilename])
+			} else {
+				// a file that can't be scanned fails the same way when it is converted
+				last, _ := scanLastPositions(inFilename, *bufferSize, *skipBad)
+				keeper := newUnseenKeeper(last)
+				keep, commit = keeper.keep, keeper.commit
+			}
+			result := processFile(fileName, *gzipOutput, *streamOutput, *bufferSize, keep, commit, *skipBad)
+			if !result.failed {
+				markCompleted(inFilename)
+			}
+			return result, nil
+		},
+		func(fileName string, result interface{}) error {
+			fileResult := result.(fileResult)
+			recordsProcessed += fileResult.records
+			filesProcessed++
+			if fileResult.failed {
+				filesFailed++
+				recordsSkipped += len(fileResult.failures) - 1
+			} else {
+				recordsSkipped += len(fileResult.failures)
+			}
+			if report != nil {
+				if err := report.Write(fileResult.failures); err != nil {
+					return err
+				}
+			}
+			if fileResult.fatal != nil {
+				// the run stops, so flush the report of this and earlier failures now
+				if report != nil {
+					report.Close()
+				}
+				return fileResult.fatal
+			}
+			if *checkpointDir != "" && filesProcessed%*checkpointInterval == 0 {
+				return writeCheckpoint(*checkpointDir)
+			}
+			return nil
+		})
+	check(err)
+	if shards != nil {
+		check(shards.Close())
+	}
+	if *checkpointDir != "" {
+		check(writeCheckpoint(*checkpointDir))
+	}
+	if report != nil {
+		check(report.Close())
+	}
+	if *placeReportFilename != "" {
+		placeReportFile, err := os.Create(*placeReportFilename)
+		check(err)
+		check(unmatchedPlaces.write(placeReportFile))
+		check(placeReportFile.Close())
+	}
+	if *typeReportFilename != "" {
+		typeReportFile, err := os.Create(*typeReportFilename)
+		check(err)
+		check(factTypes.writeUnmapped(typeReportFile))
+		check(typeReportFile.Close())
+	}
+	fmt.Printf("\nTotal files=%d records=%d failed files=%d skipped records=%d\n",
+		filesProcessed, recordsProcessed, filesFailed, recordsSkipped)
+	if filesProcessed > 0 && float64(filesFailed)/float64(filesProcessed) > *maxFailRate {
+		log.Fatalf("%d of %d files failed", filesFailed, filesProcessed)
 	}
-	fmt.Printf("\nTotal files=%d records=%d\n", filesProcessed, recordsProcessed)
 }
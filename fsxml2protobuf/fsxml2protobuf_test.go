@@ -1,8 +1,19 @@
 package main
 
 import (
+	"bytes"
+	"code.google.com/p/goprotobuf/proto"
 	"encoding/xml"
+	"fmt"
 	"github.com/rootsdev/fsbff/fs_data"
+	"github.com/rootsdev/fsbff/fs_index"
+	"github.com/rootsdev/fsbff/fs_place"
+	"github.com/rootsdev/fsbff/fs_reader"
+	"github.com/rootsdev/fsbff/fs_shard"
+	"github.com/willf/bloom"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 )
@@ -23,6 +34,15 @@ func TestGetYear(t *testing.T) {
 		{"25Apr1888", 1888},
 		{"25Apr18", 0},
 		{"June", 0},
+		{"Abt. 1880", 1880},
+		{"Bef 1700", 1700},
+		{"1850-1860", 1850},
+		{"Bet 1850 and 1860", 1850},
+		{"1880-04-25", 1880},
+		{"24/3/2010", 2010},
+		{"25th December 1901", 1901},
+		{"Deceased", 0},
+		{"12345", 0},
 	}
 	for _, test := range tests {
 		actual := getYear(test.in)
@@ -73,3 +93,638 @@ func TestGetFactType(t *testing.T) {
 		}
 	}
 }
+
+func TestDecodeRecords(t *testing.T) {
+	in := `<records>
+<record><person id="A"/></record>
+<record><person id="B"/></record>
+<record><person id="A"><gender type="http://gedcomx.org/Female"/></person></record>
+</records>`
+	var tests = []struct {
+		bufferSize int
+		batches    int
+		out        []string
+	}{
+		{10, 1, []string{"A:FEMALE", "B:UNKNOWN"}},
+		{2, 2, []string{"B:UNKNOWN", "A:FEMALE"}},
+		{1, 3, []string{"B:UNKNOWN", "A:FEMALE"}},
+	}
+	for _, test := range tests {
+		personIdsBloom = bloom.New(1000, 5)
+		last, err := lastPositions(newRecordDecoder("", strings.NewReader(in), false), test.bufferSize, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keeper := newUnseenKeeper(last)
+		var buf bytes.Buffer
+		batches := 0
+		err = decodeRecords(newRecordDecoder("", strings.NewReader(in), false), test.bufferSize, nil, func(records []Record) error {
+			batches++
+			_, err := writeRecords(legacyWriter{&buf}, records, keeper.keep, nil)
+			return err
+		})
+		if err != nil {
+			t.Errorf("decodeRecords(%d) error %v", test.bufferSize, err)
+			continue
+		}
+		fsPersons := &fs_data.FamilySearchPersons{}
+		if err = proto.Unmarshal(buf.Bytes(), fsPersons); err != nil {
+			t.Errorf("decodeRecords(%d) unmarshal error %v", test.bufferSize, err)
+			continue
+		}
+		var actual []string
+		for _, person := range fsPersons.Persons {
+			actual = append(actual, person.GetId()+":"+person.GetGender().String())
+		}
+		if batches != test.batches || strings.Join(actual, ",") != strings.Join(test.out, ",") {
+			t.Errorf("decodeRecords(%d) = %d batches %v; want %d batches %v",
+				test.bufferSize, batches, actual, test.batches, test.out)
+		}
+	}
+}
+
+func TestGetFact(t *testing.T) {
+	var tests = []struct {
+		in  Fact
+		out string
+	}{
+		{Fact{Type: "http://gedcomx.org/ChildOrder", Value: "2"}, "ChildOrder/2"},
+		{Fact{Type: "http://gedcomx.org/Obituary", Value: "Died peacefully"}, "Obituary/Died peacefully"},
+		{Fact{Type: "data:,Hobby", Value: "fishing"}, ""},
+		{Fact{Type: "data:,Hobby", Date: Date{Original: "1900"}}, "OTHER/"},
+	}
+	for _, test := range tests {
+		out := ""
+		if fsFact := getFact(test.in); fsFact != nil {
+			out = fsFact.GetType() + "/" + fsFact.GetValue()
+		}
+		if out != test.out {
+			t.Errorf("getFact(%s) = %q; want %q", test.in.Type, out, test.out)
+		}
+	}
+}
+
+func TestGetNames(t *testing.T) {
+	var tests = []struct {
+		in  string
+		out []string
+	}{
+		{`<person></person>`, nil},
+		{`<person><name><nameForm/></name></person>`, nil},
+		{`<person><name type="http://gedcomx.org/BirthName" preferred="true"><nameForm>` +
+			`<fullText>John Henry Smith</fullText>` +
+			`<part type="http://gedcomx.org/Given" value="John"/>` +
+			`<part type="http://gedcomx.org/Given" value="Henry"/>` +
+			`<part type="http://gedcomx.org/Surname" value="Smith"/>` +
+			`</nameForm></name></person>`,
+			[]string{"John Henry/Smith/John Henry Smith/BirthName/true"}},
+		{`<person><name><nameForm><fullText>Mary</fullText></nameForm></name>` +
+			`<name type="http://gedcomx.org/MarriedName"><nameForm><part type="http://gedcomx.org/Surname" value="Jones"/></nameForm></name></person>`,
+			[]string{"//Mary//false", "/Jones//MarriedName/false"}},
+	}
+	for _, test := range tests {
+		var person Person
+		err := xml.NewDecoder(strings.NewReader(test.in)).Decode(&person)
+		if err != nil {
+			t.Errorf("Error decoding %s %v", test.in, err)
+			continue
+		}
+		var actual []string
+		for _, name := range getNames(&person) {
+			actual = append(actual, fmt.Sprintf("%s/%s/%s/%s/%v",
+				name.GetGiven(), name.GetSurname(), name.GetFullText(), name.GetType(), name.GetPreferred()))
+		}
+		if strings.Join(actual, "|") != strings.Join(test.out, "|") {
+			t.Errorf("getNames(%q) = %v; want %v", test.in, actual, test.out)
+		}
+	}
+}
+
+func TestGetRelationships(t *testing.T) {
+	in := `<record><person id="P1"/>` +
+		`<relationship type="http://gedcomx.org/ParentChild"><person1 resource="https://familysearch.org/ark:/61903/4:1:F1"/><person2 resource="#P1"/></relationship>` +
+		`<relationship type="http://gedcomx.org/ParentChild"><person1 resource="#P1"/><person2 resource="https://familysearch.org/ark:/61903/4:1:C1"/>` +
+		`<fact type="http://gedcomx.org/AdoptiveParent"><attribution><contributor resourceId="U2"/></attribution></fact></relationship>` +
+		`<relationship type="http://gedcomx.org/Couple"><attribution><contributor resourceId="U1"/></attribution>` +
+		`<person1 resource="#P1"/><person2 resource="https://familysearch.org/ark:/61903/4:1:S1"/>` +
+		`<fact type="http://gedcomx.org/Marriage"><date><original>1 Jun 1880</original></date></fact></relationship>` +
+		`</record>`
+	var record Record
+	err := xml.NewDecoder(strings.NewReader(in)).Decode(&record)
+	if err != nil {
+		t.Fatalf("Error decoding %s %v", in, err)
+	}
+	parents, children, spouses, fsRelationships := getRelationships(record.Relationships)
+	if strings.Join(parents, ",") != "F1" || strings.Join(children, ",") != "C1" || strings.Join(spouses, ",") != "S1" {
+		t.Errorf("getRelationships parents=%v children=%v spouses=%v; want [F1] [C1] [S1]", parents, children, spouses)
+	}
+	var tests = []struct {
+		typ          fs_data.FSRelationshipType
+		relatedID    string
+		facts        string
+		contributors string
+	}{
+		{fs_data.FSRelationshipType_PARENT, "F1", "", ""},
+		{fs_data.FSRelationshipType_CHILD, "C1", "AdoptiveParent:0", "U2"},
+		{fs_data.FSRelationshipType_SPOUSE, "S1", "Marriage:1880", "U1"},
+	}
+	if len(fsRelationships) != len(tests) {
+		t.Fatalf("getRelationships returned %d relationships; want %d", len(fsRelationships), len(tests))
+	}
+	for i, test := range tests {
+		rel := fsRelationships[i]
+		var facts []string
+		for _, fact := range rel.Facts {
+			facts = append(facts, fmt.Sprintf("%s:%d", fact.GetType(), fact.GetYear()))
+		}
+		if rel.GetType() != test.typ || rel.GetRelatedId() != test.relatedID ||
+			strings.Join(facts, ",") != test.facts || strings.Join(rel.Contributors, ",") != test.contributors {
+			t.Errorf("relationship[%d] = %v %s %v %v; want %v %s %s %s", i, rel.GetType(), rel.GetRelatedId(),
+				facts, rel.Contributors, test.typ, test.relatedID, test.facts, test.contributors)
+		}
+	}
+}
+
+func TestCheckpoint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fsxml2protobuf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	personIdsBloom = bloom.New(1000, 5)
+	personIdsBloom.TestAndAdd([]byte("P1"))
+	completedFiles = nil
+	markCompleted("in/a.xml")
+	markCompleted("in/b.xml")
+	if err = writeCheckpoint(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	completed, filter, err := readCheckpoint(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(completed, ",") != "in/a.xml,in/b.xml" {
+		t.Errorf("readCheckpoint completed = %v; want [in/a.xml in/b.xml]", completed)
+	}
+	if !filter.Test([]byte("P1")) || filter.Test([]byte("P2")) {
+		t.Errorf("readCheckpoint bloom filter does not match the saved filter")
+	}
+}
+
+func TestExactDedup(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fsxml2protobuf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := []string{`<records>
+<record><person id="A"><gender type="http://gedcomx.org/Male"><attribution><modified>300</modified></attribution></gender></person></record>
+<record><person id="B"><gender type="http://gedcomx.org/Male"><attribution><modified>100</modified></attribution></gender></person></record>
+<record><person id="C"/></record>
+</records>`, `<records>
+<record><person id="A"><gender type="http://gedcomx.org/Female"><attribution><modified>200</modified></attribution></gender></person></record>
+<record><person id="B"><gender type="http://gedcomx.org/Female"/></person><relationship><attribution><modified>150</modified></attribution></relationship></record>
+<record><person id="C"><gender type="http://gedcomx.org/Female"/></person></record>
+<record><person id="D"/></record>
+</records>`}
+	var inFilenames []string
+	for i, in := range files {
+		inFilename := fmt.Sprintf("%s/%d.xml", dir, i)
+		if err = ioutil.WriteFile(inFilename, []byte(in), 0644); err != nil {
+			t.Fatal(err)
+		}
+		inFilenames = append(inFilenames, inFilename)
+	}
+
+	// larger files first, as in a directory run, and small sorts so the versions are merged from several runs
+	latest, superseded, err := findLatestVersions([]string{inFilenames[1], inFilenames[0]}, 2, 2, false, dir, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if superseded != 3 {
+		t.Errorf("findLatestVersions superseded = %d; want 3", superseded)
+	}
+
+	var actual []string
+	for _, inFilename := range inFilenames {
+		file, err := os.Open(inFilename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		err = decodeRecords(newRecordDecoder(inFilename, file, false), 2, nil, func(records []Record) error {
+			_, err := writeRecords(legacyWriter{&buf}, records, keepLatest(latest[inFilename]), nil)
+			return err
+		})
+		file.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		fsPersons := &fs_data.FamilySearchPersons{}
+		if err = proto.Unmarshal(buf.Bytes(), fsPersons); err != nil {
+			t.Fatal(err)
+		}
+		for _, person := range fsPersons.Persons {
+			actual = append(actual, fmt.Sprintf("%s:%s:%d", person.GetId(), person.GetGender(), person.GetModified()))
+		}
+	}
+	want := "A:MALE:300,B:FEMALE:150,D:UNKNOWN:0,C:FEMALE:0"
+	if strings.Join(actual, ",") != want {
+		t.Errorf("exact dedup wrote %v; want %s", actual, want)
+	}
+}
+
+func TestProcessFileFailures(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fsxml2protobuf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"good.xml":      `<records><record><person id="A"/></record></records>`,
+		"malformed.xml": `<records><record><person id="A"/></record><record><person id="B"></record></records>`,
+		"badvalue.xml": `<records><record><person id="A"/></record>` +
+			`<record><person id="B"><gender><attribution><modified>soon</modified></attribution></gender></person></record>` +
+			`<record><person id="C"/></record></records>`,
+		"notgzip.xml.gz": `<records></records>`,
+	}
+	for name, contents := range files {
+		if err = ioutil.WriteFile(dir+"/"+name, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	keepAll := func(recordIdx int, id string) bool { return true }
+	var tests = []struct {
+		in      string
+		skipBad bool
+		records int
+		out     string
+	}{
+		{"good.xml", false, 1, ""},
+		{"missing.xml", false, 0, "open/0/0/false"},
+		{"notgzip.xml.gz", false, 0, "gunzip/0/0/false"},
+		{"malformed.xml", false, 0, "decode/42/1/false"},
+		{"malformed.xml", true, 0, "decode/42/1/false"},
+		{"badvalue.xml", false, 0, "decode/42/1/false"},
+		{"badvalue.xml", true, 2, "decode/42/1/true"},
+	}
+	for _, test := range tests {
+		outFilename := dir + "/out.protobuf"
+		os.Remove(outFilename)
+		result := processFile(dir+"/"+test.in+"\t"+outFilename, false, false, 10, keepAll, nil, test.skipBad)
+		var failures []string
+		for _, failure := range result.failures {
+			if failure.File != dir+"/"+test.in {
+				t.Errorf("processFile(%s) failure file %s", test.in, failure.File)
+			}
+			failures = append(failures, fmt.Sprintf("%s/%d/%d/%v", failure.Stage, failure.Offset, failure.Record, failure.Skipped))
+		}
+		failed := test.out != "" && !strings.HasSuffix(test.out, "true")
+		if result.records != test.records || result.failed != failed || strings.Join(failures, ",") != test.out {
+			t.Errorf("processFile(%s, %v) = %d records %v failed %v; want %d records %s",
+				test.in, test.skipBad, result.records, failures, result.failed, test.records, test.out)
+		}
+		if _, err := os.Stat(outFilename); (err == nil) == failed {
+			t.Errorf("processFile(%s, %v) output exists %v; want %v", test.in, test.skipBad, err == nil, !failed)
+		}
+	}
+}
+
+func TestFailedFileKeepsPersonsUnseen(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fsxml2protobuf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// the first file fails after its first batch has been written
+	files := []string{
+		`<records><record><person id="A"/></record><record><person id="B"></record></records>`,
+		`<records><record><person id="A"/></record></records>`,
+	}
+	personIdsBloom = bloom.New(1000, 5)
+	var results []string
+	for i, in := range files {
+		inFilename := fmt.Sprintf("%s/%d.xml", dir, i)
+		outFilename := fmt.Sprintf("%s/%d.protobuf", dir, i)
+		if err = ioutil.WriteFile(inFilename, []byte(in), 0644); err != nil {
+			t.Fatal(err)
+		}
+		keeper := newUnseenKeeper(nil)
+		result := processFile(inFilename+"\t"+outFilename, false, false, 1, keeper.keep, keeper.commit, false)
+		if result.failed {
+			results = append(results, "failed")
+			continue
+		}
+		b, err := ioutil.ReadFile(outFilename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fsPersons := &fs_data.FamilySearchPersons{}
+		if err = proto.Unmarshal(b, fsPersons); err != nil {
+			t.Fatal(err)
+		}
+		var ids []string
+		for _, person := range fsPersons.Persons {
+			ids = append(ids, person.GetId())
+		}
+		results = append(results, strings.Join(ids, ","))
+	}
+	if strings.Join(results, ";") != "failed;A" {
+		t.Errorf("processFile wrote %v; want [failed A]", results)
+	}
+}
+
+func decodePersons(filename string, in string, skipBad bool) (fsPersons []*fs_data.FamilySearchPerson, skipped int, err error) {
+	var skip func(failure *conversionError)
+	if skipBad {
+		skip = func(failure *conversionError) { skipped++ }
+	}
+	err = decodeRecords(newRecordDecoder(filename, strings.NewReader(in), skipBad), 10, skip, func(records []Record) error {
+		for i := range records {
+			fsPerson, err := getPerson(&records[i].Person, records[i].Relationships)
+			if err != nil {
+				return err
+			}
+			fsPersons = append(fsPersons, fsPerson)
+		}
+		return nil
+	})
+	return
+}
+
+func TestJSONInput(t *testing.T) {
+	xmlIn := `<records>
+<record><person id="A"><gender type="http://gedcomx.org/Male"><attribution><contributor resourceId="C1"/><modified>5</modified></attribution></gender>
+<name type="http://gedcomx.org/BirthName" preferred="true"><nameForm><fullText>John Smith</fullText><part type="http://gedcomx.org/Given" value="John"/><part type="http://gedcomx.org/Surname" value="Smith"/></nameForm></name>
+<fact type="http://gedcomx.org/Birth"><date><original>3 Mar 1850</original></date><place><original>Ohio</original></place></fact></person>
+<relationship type="http://gedcomx.org/Couple"><person1 resource="#A"/><person2 resource="https://familysearch.org/ark:/61903/4:1:B"/><fact type="http://gedcomx.org/Marriage"><date><original>1875</original></date></fact></relationship>
+<relationship type="http://gedcomx.org/ParentChild"><person1 resource="https://familysearch.org/ark:/61903/4:1:P"/><person2 resource="#A"/></relationship></record>
+<record><person id="B"><gender type="http://gedcomx.org/Female"/></person>
+<relationship type="http://gedcomx.org/Couple"><person1 resource="https://familysearch.org/ark:/61903/4:1:A"/><person2 resource="#B"/><fact type="http://gedcomx.org/Marriage"><date><original>1875</original></date></fact></relationship></record>
+</records>`
+	persons := `"persons": [{"id": "A", "gender": {"type": "http://gedcomx.org/Male", "attribution": {"contributor": {"resourceId": "C1"}, "modified": 5}},
+  "names": [{"type": "http://gedcomx.org/BirthName", "preferred": true, "nameForms": [{"fullText": "John Smith",
+    "parts": [{"type": "http://gedcomx.org/Given", "value": "John"}, {"type": "http://gedcomx.org/Surname", "value": "Smith"}]}]}],
+  "facts": [{"type": "http://gedcomx.org/Birth", "date": {"original": "3 Mar 1850"}, "place": {"original": "Ohio"}}]},
+ {"id": "B", "gender": {"type": "http://gedcomx.org/Female"}}]`
+	relationships := `"relationships": [{"type": "http://gedcomx.org/Couple", "person1": {"resource": "#A"}, "person2": {"resource": "#B"},
+  "facts": [{"type": "http://gedcomx.org/Marriage", "date": {"original": "1875"}}]},
+ {"type": "http://gedcomx.org/ParentChild", "person1": {"resource": "https://familysearch.org/ark:/61903/4:1:P"}, "person2": {"resource": "#A"}}]`
+
+	want, _, err := decodePersons("in.xml", xmlIn, false)
+	if err != nil || len(want) != 2 {
+		t.Fatalf("decodeRecords(xml) = %d persons err %v; want 2", len(want), err)
+	}
+	var tests = []struct {
+		filename string
+		in       string
+	}{
+		{"in.json", "{" + persons + ", " + relationships + "}"},
+		{"in.json.gz", "[{" + persons + ", " + relationships + "}]"},
+		{"in", "\n {" + persons + ", " + relationships + "}"},
+		{"in.jsonl", `{"persons": [{"id": "X"}], "relationships": []}` + "\n{" + persons + ", " + relationships + "}"},
+	}
+	for _, test := range tests {
+		actual, _, err := decodePersons(test.filename, test.in, false)
+		if err != nil {
+			t.Errorf("decodeRecords(%s) error %v", test.filename, err)
+			continue
+		}
+		if len(actual) > 0 && actual[0].GetId() == "X" {
+			actual = actual[1:]
+		}
+		if len(actual) != len(want) {
+			t.Errorf("decodeRecords(%s) = %d persons; want %d", test.filename, len(actual), len(want))
+			continue
+		}
+		for i := range want {
+			if !proto.Equal(actual[i], want[i]) {
+				t.Errorf("decodeRecords(%s) person %d = %v; want %v", test.filename, i, actual[i], want[i])
+			}
+		}
+	}
+
+	in := `{"persons": [{"id": "X", "gender": "male"}]}` + "\n" + `{"persons": [{"id": "Y"}]}`
+	if _, _, err = decodePersons("in.json", in, false); err == nil {
+		t.Errorf("decodeRecords(bad json) returned no error")
+	}
+	actual, skipped, err := decodePersons("in.json", in, true)
+	if err != nil || skipped != 1 || len(actual) != 1 || actual[0].GetId() != "Y" {
+		t.Errorf("decodeRecords(bad json, skipBad) = %v skipped %d err %v; want Y skipped 1", actual, skipped, err)
+	}
+}
+
+func formatStdPlace(place *fs_data.FSPlace) string {
+	if place == nil {
+		return ""
+	}
+	var levels []string
+	for _, level := range place.Levels {
+		levels = append(levels, level.GetName()+"/"+level.GetType())
+	}
+	return fmt.Sprintf("%s %v %v %s", place.GetId(), place.GetLatitude(), place.GetLongitude(), strings.Join(levels, ","))
+}
+
+func TestStdPlaceDetails(t *testing.T) {
+	const stdPlacesFile = "Provo, Ut\tProvo, Utah, Utah, United States\t123\t40.23\t-111.66\tCity,County,State,Country\n" +
+		"Provo\tProvo, Utah, Utah, United States\t\t\t\t\n" +
+		"Sussex\tSussex, England\t\t\t\tCounty\n" +
+		"London\tLondon, England\n"
+	m := fs_place.NewMatcher(fs_place.LevelAbbreviations, fs_place.WordAbbreviations)
+	if err := fs_place.ReadStdPlaces(strings.NewReader(stdPlacesFile), m); err != nil {
+		t.Fatal(err)
+	}
+	places = m
+	defer func() { places = nil }()
+
+	var tests = []struct {
+		place    string
+		stdPlace string
+	}{
+		{"Provo, Utah", "123 40.23 -111.66 Provo/City,Utah/County,Utah/State,United States/Country"},
+		{"Sussex", " 0 0 Sussex/,England/"},
+		{"London", ""},
+		{"Paris", ""},
+	}
+	for _, test := range tests {
+		fsFact := getFact(Fact{Type: "http://gedcomx.org/Birth", Place: Place{Original: test.place}})
+		if stdPlace := formatStdPlace(fsFact.StdPlace); stdPlace != test.stdPlace {
+			t.Errorf("getFact(%q) std place = %q; want %q", test.place, stdPlace, test.stdPlace)
+		}
+	}
+
+	if err := fs_place.ReadStdPlaces(strings.NewReader("a\tb\t1\tnorth\t2\t\n"), m); err == nil {
+		t.Errorf("readStdPlaces(bad latitude) returned no error")
+	}
+}
+
+func TestFactTypeRules(t *testing.T) {
+	const rules = "# custom types\n" +
+		"exact\tCensus 1850\tCensus\n" +
+		"prefix\tmilitary \tMilitaryService\n" +
+		"regex\t^marr(iage)?\\b\tMarriage\n" +
+		"regex\t^bur\tBurial\n"
+	m := newFactTypeMapper()
+	if err := readFactTypeRules(strings.NewReader(rules), m); err != nil {
+		t.Fatal(err)
+	}
+	m.countUnmapped()
+
+	var tests = []struct {
+		in  string
+		out string
+	}{
+		{"http://gedcomx.org/Birth", "Birth"},
+		{"http://gedcomx.org/ChildOrder", "ChildOrder"},
+		{"http://example.org/facts/Obituary", "Obituary"},
+		{"data:,census 1850", "Census"},
+		{"data:,census", "Residence"},
+		{"data:,Military Record", "MilitaryService"},
+		{"data:,marr date", "Marriage"},
+		{"data:,marriages", "OTHER"},
+		{"data:,Buried", "Burial"},
+		{"data:,Hobby", "OTHER"},
+		{"data:,hobby", "OTHER"},
+	}
+	for _, test := range tests {
+		if out := m.mapType(test.in); out != test.out {
+			t.Errorf("mapType(%q) = %q; want %q", test.in, out, test.out)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := m.writeUnmapped(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if want := "2\thobby\n1\thttp://example.org/facts/Obituary\n1\thttp://gedcomx.org/ChildOrder\n1\tmarriages\n"; buf.String() != want {
+		t.Errorf("writeUnmapped = %q; want %q", buf.String(), want)
+	}
+
+	for _, bad := range []string{"exact\tx\tNotAType\n", "glob\tx*\tBirth\n", "regex\t(\tBirth\n", "exact\tx\n"} {
+		if err := readFactTypeRules(strings.NewReader(bad), newFactTypeMapper()); err == nil {
+			t.Errorf("readFactTypeRules(%q) returned no error", bad)
+		}
+	}
+}
+
+func TestGetSources(t *testing.T) {
+	const descriptions = "S1|https://familysearch.org/ark:/61903/1:1:X|1850 Census|\"United States Census, 1850\"\n" +
+		"S2|https://familysearch.org/photos/1|Photo\n" +
+		"bad line\n"
+	var err error
+	sourceDescriptions, err = readSourceDescriptions(strings.NewReader(descriptions))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sourceRefs = sourceRefMap{"P1": {"S1", "S2", "S3"}}
+	defer func() { sourceDescriptions, sourceRefs = nil, nil }()
+
+	sources, err := getSources(&Person{ID: "P1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var actual []string
+	for _, source := range sources {
+		actual = append(actual, fmt.Sprintf("%s|%s|%s|%s", source.GetSourceId(), source.GetAbout(), source.GetTitle(),
+			source.GetCitation()))
+	}
+	want := []string{
+		"S1|https://familysearch.org/ark:/61903/1:1:X|1850 Census|\"United States Census, 1850\"",
+		"S2|https://familysearch.org/photos/1|Photo|",
+		"S3|||",
+	}
+	if strings.Join(actual, "\n") != strings.Join(want, "\n") {
+		t.Errorf("getSources = %q; want %q", actual, want)
+	}
+
+	// the same references looked up in an index
+	dir, err := ioutil.TempDir("", "fsxml2protobuf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	b := fs_index.NewBuilder(dir, 10)
+	for _, ref := range []string{"S3", "S1", "S2"} {
+		b.Add("P1", ref)
+	}
+	b.Add("P2", "S4")
+	if err = b.Write(filepath.Join(dir, "index")); err != nil {
+		t.Fatal(err)
+	}
+	index, err := fs_index.Open(filepath.Join(dir, "index"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer index.Close()
+	sourceRefs = index
+	if sources, err = getSources(&Person{ID: "P1"}); err != nil || len(sources) != 3 || sources[0].GetTitle() != "1850 Census" {
+		t.Errorf("getSources(index) = %v err %v; want S1, S2, S3", sources, err)
+	}
+}
+
+func TestShardedOutput(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fsxml2protobuf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"a.xml": `<records><record><person id="A"/></record><record><person id="B"/></record>` +
+			`<record><person id="C"/></record></records>`,
+		"b.xml":         `<records><record><person id="D"/></record><record><person id="E"/></record></records>`,
+		"malformed.xml": `<records><record><person id="F"/></record><record><person id="G"></record></records>`,
+	}
+	for name, contents := range files {
+		if err = ioutil.WriteFile(filepath.Join(dir, name), []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	outDir := filepath.Join(dir, "out")
+	if err = os.Mkdir(outDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, streamOutput := range []bool{false, true} {
+		shards, err = createShards(outDir, 3, true, streamOutput, dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keepAll := func(recordIdx int, id string) bool { return true }
+		records := 0
+		for _, name := range []string{"a.xml", "b.xml", "malformed.xml"} {
+			records += processFile(filepath.Join(dir, name)+"\t", false, false, 2, keepAll, nil, false).records
+		}
+		err = shards.Close()
+		shards = nil
+		if err != nil {
+			t.Fatal(err)
+		}
+		if records != 5 {
+			t.Errorf("processFile(shards, stream %v) wrote %d records; want 5", streamOutput, records)
+		}
+		if spools, _ := filepath.Glob(filepath.Join(dir, "fsxml2protobuf-shard*")); len(spools) != 0 {
+			t.Errorf("processFile(shards, stream %v) left spool files %v", streamOutput, spools)
+		}
+
+		m, err := fs_shard.ReadManifest(outDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m.Persons() != 5 {
+			t.Errorf("manifest(stream %v) has %d persons; want 5", streamOutput, m.Persons())
+		}
+		for _, id := range []string{"A", "B", "C", "D", "E", "F"} {
+			person, err := fs_reader.FindPerson(outDir, id)
+			if err != nil || (person != nil) != (id != "F") {
+				t.Errorf("FindPerson(stream %v, %s) = %v, %v; want found %v", streamOutput, id, person, err, id != "F")
+			}
+		}
+	}
+}
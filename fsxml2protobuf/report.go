@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+)
+
+// stages at which converting a file can fail
+const (
+	stageOpen    = "open"
+	stageGunzip  = "gunzip"
+	stageDecode  = "decode"
+	stageSources = "sources"
+	stageMarshal = "marshal"
+	stageWrite   = "write"
+)
+
+// conversionError records a failure converting an input file or one of its records.
+// Offset is the byte offset in the uncompressed input of the record being converted and Record is its
+// position in the file; failures after the input has been read report the end of the input instead.
+type conversionError struct {
+	File    string `json:"file"`
+	Stage   string `json:"stage"`
+	Offset  int64  `json:"offset"`
+	Record  int    `json:"record"`
+	Err     string `json:"error"`
+	Skipped bool   `json:"skipped,omitempty"` // the record was skipped and the rest of the file converted
+}
+
+func (e *conversionError) Error() string {
+	return fmt.Sprintf("%s error at offset %d (record %d): %s", e.Stage, e.Offset, e.Record, e.Err)
+}
+
+func newConversionError(stage string, offset int64, record int, err error) *conversionError {
+	return &conversionError{Stage: stage, Offset: offset, Record: record, Err: err.Error()}
+}
+
+// fileResult summarizes the conversion of a single input file
+type fileResult struct {
+	records  int
+	failures []*conversionError // skipped records, followed by the error that failed the file if failed is set
+	failed   bool
+	fatal    error // set if the file's persons were partly written to the shards, which must stop the run
+}
+
+// errorReader counts the bytes read and remembers the first read error other than io.EOF,
+// so that failures reading the input can be told apart from malformed XML
+type errorReader struct {
+	r   io.Reader
+	n   int64
+	err error
+}
+
+func (e *errorReader) Read(p []byte) (int, error) {
+	n, err := e.r.Read(p)
+	e.n += int64(n)
+	if err != nil && err != io.EOF && e.err == nil {
+		e.err = err
+	}
+	return n, err
+}
+
+// failureReport writes conversion failures as JSON lines
+type failureReport struct {
+	file    *os.File
+	w       *bufio.Writer
+	encoder *json.Encoder
+}
+
+func createFailureReport(filename string) (*failureReport, error) {
+	file, err := os.Create(filename)
+	if err != nil {
+		return nil, err
+	}
+	w := bufio.NewWriter(file)
+	return &failureReport{file: file, w: w, encoder: json.NewEncoder(w)}, nil
+}
+
+func (r *failureReport) Write(failures []*conversionError) error {
+	for _, failure := range failures {
+		if err := r.encoder.Encode(failure); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *failureReport) Close() error {
+	err := r.w.Flush()
+	if closeErr := r.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// counter counts strings seen by concurrent workers, for a frequency report
+type counter struct {
+	lock   sync.Mutex
+	counts map[string]int
+}
+
+func newCounter() *counter {
+	return &counter{counts: make(map[string]int)}
+}
+
+func (c *counter) add(s string) {
+	c.lock.Lock()
+	c.counts[s]++
+	c.lock.Unlock()
+}
+
+// write writes the strings and their counts, most frequent first
+func (c *counter) write(w io.Writer) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	keys := make([]string, 0, len(c.counts))
+	for key := range c.counts {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if c.counts[keys[i]] != c.counts[keys[j]] {
+			return c.counts[keys[i]] > c.counts[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	buf := bufio.NewWriter(w)
+	for _, key := range keys {
+		if _, err := fmt.Fprintf(buf, "%d\t%s\n", c.counts[key], key); err != nil {
+			return err
+		}
+	}
+	return buf.Flush()
+}
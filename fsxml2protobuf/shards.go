@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"github.com/rootsdev/fsbff/fs_shard"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+/*
+With -shards, persons are written to a fixed number of shard files partitioned by a hash of their IDs
+instead of to one output file per input file, and a manifest listing the shards is written at the end
+(see fs_shard). Every worker writes to every shard, so each shard has its own lock. A file's persons are
+spooled to a temporary file while it is converted and copied to the shards only if the whole file succeeds,
+so a failed file leaves nothing behind, as it does without shards, and memory doesn't grow with the size of
+the input files. A file whose persons can't all be copied to the shards stops the run, since the shards
+then hold some of its persons and can't be completed by rerunning the file.
+*/
+
+// shards is the shards being written, or nil when writing one output file per input file
+var shards *shardSet
+
+type shardFile struct {
+	lock    sync.Mutex
+	out     *outputFile
+	persons int64
+}
+
+// shardSet is the shard files of an output directory; it is safe for concurrent use
+type shardSet struct {
+	dir      string
+	spoolDir string
+	manifest *fs_shard.Manifest
+	files    []*shardFile
+}
+
+// createShards creates the shard files in dir; files are spooled in spoolDir, or the default temporary
+// directory if it is empty
+func createShards(dir string, numShards int, gzipOutput bool, streamOutput bool, spoolDir string) (*shardSet, error) {
+	s := &shardSet{dir: dir, spoolDir: spoolDir, manifest: fs_shard.NewManifest(numShards, gzipOutput)}
+	for _, file := range s.manifest.Files {
+		out, err := createOutputFile(filepath.Join(dir, file.Name), gzipOutput, streamOutput)
+		if err != nil {
+			s.abort()
+			return nil, err
+		}
+		s.files = append(s.files, &shardFile{out: out})
+	}
+	return s, nil
+}
+
+// newBuffer returns a buffer for the persons of a single input file; it must be removed when done
+func (s *shardSet) newBuffer() (*shardBuffer, error) {
+	file, err := ioutil.TempFile(s.spoolDir, "fsxml2protobuf-shard")
+	if err != nil {
+		return nil, err
+	}
+	return &shardBuffer{shards: s, file: file, w: bufio.NewWriter(file)}, nil
+}
+
+// Close finishes writing the shard files and writes the manifest
+func (s *shardSet) Close() error {
+	for i, file := range s.files {
+		if err := file.out.Close(); err != nil {
+			s.abort()
+			return err
+		}
+		s.manifest.Files[i].Persons = file.persons
+	}
+	return s.manifest.Write(s.dir)
+}
+
+func (s *shardSet) abort() {
+	for _, file := range s.files {
+		file.out.Abort()
+	}
+}
+
+// shardBuffer spools the marshaled persons of an input file until they are committed; each person is
+// written as its shard and length as uvarints, followed by the person
+type shardBuffer struct {
+	shards *shardSet
+	file   *os.File
+	w      *bufio.Writer
+}
+
+func (b *shardBuffer) WritePerson(id string, person []byte) error {
+	var header [2 * binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(header[:], uint64(fs_shard.Shard(id, len(b.shards.files))))
+	n += binary.PutUvarint(header[n:], uint64(len(person)))
+	if _, err := b.w.Write(header[:n]); err != nil {
+		return err
+	}
+	_, err := b.w.Write(person)
+	return err
+}
+
+// commit copies the spooled persons to their shards
+func (b *shardBuffer) commit() error {
+	if err := b.w.Flush(); err != nil {
+		return err
+	}
+	if _, err := b.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	r := bufio.NewReader(b.file)
+	var person []byte
+	for {
+		shard, err := binary.ReadUvarint(r)
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		length, err := binary.ReadUvarint(r)
+		if err != nil {
+			return err
+		}
+		if uint64(cap(person)) < length {
+			person = make([]byte, length)
+		}
+		person = person[:length]
+		if _, err = io.ReadFull(r, person); err != nil {
+			return err
+		}
+		if err = b.shards.files[shard].write(person); err != nil {
+			return err
+		}
+	}
+}
+
+// remove removes the spool file
+func (b *shardBuffer) remove() {
+	b.file.Close()
+	os.Remove(b.file.Name())
+}
+
+func (f *shardFile) write(person []byte) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	// the id is only needed to choose the shard
+	if err := f.out.WritePerson("", person); err != nil {
+		return err
+	}
+	f.persons++
+	return nil
+}
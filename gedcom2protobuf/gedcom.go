@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// gedcomNode is a GEDCOM line with its subordinate lines
+type gedcomNode struct {
+	xref     string // record identifier without the @s, e.g. I1
+	tag      string
+	value    string
+	children []*gedcomNode
+}
+
+// child returns the first subordinate line with the tag, or nil
+func (n *gedcomNode) child(tag string) *gedcomNode {
+	for _, child := range n.children {
+		if child.tag == tag {
+			return child
+		}
+	}
+	return nil
+}
+
+// childValue returns the value of the first subordinate line with the tag, or ""
+func (n *gedcomNode) childValue(tag string) string {
+	if child := n.child(tag); child != nil {
+		return child.value
+	}
+	return ""
+}
+
+// getPointer returns the identifier a pointer value like @I1@ refers to, or "" if the value isn't a pointer
+func getPointer(value string) string {
+	if len(value) > 2 && strings.HasPrefix(value, "@") && strings.HasSuffix(value, "@") {
+		return value[1 : len(value)-1]
+	}
+	return ""
+}
+
+// parseLine splits a GEDCOM line into its level, xref, tag and value
+func parseLine(line string) (level int, xref string, tag string, value string, ok bool) {
+	fields := strings.SplitN(strings.TrimLeft(line, " \t"), " ", 2)
+	level, err := strconv.Atoi(fields[0])
+	if err != nil || level < 0 || len(fields) < 2 {
+		return 0, "", "", "", false
+	}
+	rest := strings.TrimLeft(fields[1], " ")
+	if strings.HasPrefix(rest, "@") {
+		fields = strings.SplitN(rest, " ", 2)
+		xref = getPointer(fields[0])
+		if xref == "" || len(fields) < 2 {
+			return 0, "", "", "", false
+		}
+		rest = strings.TrimLeft(fields[1], " ")
+	}
+	fields = strings.SplitN(rest, " ", 2)
+	tag = strings.ToUpper(fields[0])
+	if tag == "" {
+		return 0, "", "", "", false
+	}
+	if len(fields) == 2 {
+		value = fields[1]
+	}
+	return level, xref, tag, value, true
+}
+
+// readGedcom reads the level-0 records of a GEDCOM file, joining CONC and CONT lines to the values they continue.
+// Lines that can't be parsed or whose level doesn't follow from the previous line are counted and skipped.
+func readGedcom(r io.Reader) (records []*gedcomNode, badLines int, err error) {
+	var stack []*gedcomNode
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	first := true
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if first {
+			line = strings.TrimPrefix(line, "\ufeff") // byte order mark
+			first = false
+		}
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		level, xref, tag, value, ok := parseLine(line)
+		if !ok || level > len(stack) || (level == 0 && (tag == "CONC" || tag == "CONT")) {
+			badLines++
+			continue
+		}
+
+		if tag == "CONC" || tag == "CONT" {
+			parent := stack[level-1]
+			if tag == "CONT" {
+				parent.value += "\n"
+			}
+			parent.value += value
+			continue
+		}
+
+		node := &gedcomNode{xref: xref, tag: tag, value: value}
+		if level == 0 {
+			records = append(records, node)
+		} else {
+			stack[level-1].children = append(stack[level-1].children, node)
+		}
+		stack = append(stack[:level], node)
+	}
+	return records, badLines, scanner.Err()
+}
@@ -0,0 +1,409 @@
+package main
+
+import (
+	"bufio"
+	"code.google.com/p/goprotobuf/proto"
+	"compress/gzip"
+	"flag"
+	"fmt"
+	"github.com/rootsdev/fsbff/fs_data"
+	"github.com/rootsdev/fsbff/fs_date"
+	"github.com/rootsdev/fsbff/fs_gedcom"
+	"github.com/rootsdev/fsbff/fs_place"
+	"github.com/rootsdev/fsbff/fs_reader"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// places standardizes places, or is nil if there is no standardized places file
+var places *fs_place.Matcher
+
+// getFactType returns the fact type of an event or attribute tag. Generic EVEN and FACT tags use their TYPE
+// if it names a known fact type, and other tags starting with _ are OTHER; other tags aren't facts.
+func getFactType(node *gedcomNode, factTypes map[string]string) (string, bool) {
+	if t, ok := factTypes[node.tag]; ok {
+		return t, true
+	}
+	if node.tag == "EVEN" || node.tag == "FACT" {
+		typ := strings.ToLower(strings.Replace(node.childValue("TYPE"), " ", "", -1))
+		for _, t := range factTypes {
+			if strings.ToLower(t) == typ {
+				return t, true
+			}
+		}
+		return "OTHER", true
+	}
+	if strings.HasPrefix(node.tag, "_") {
+		return "OTHER", true
+	}
+	return "", false
+}
+
+// getStdPlace returns the standardized name of a place and its details, if the standardized places file has them
+func getStdPlace(place string) (string, *fs_data.FSPlace) {
+	place = strings.Replace(place, "\t", " ", -1)
+	if places == nil {
+		return place, nil
+	}
+	return places.Standardize(place)
+}
+
+func getFact(node *gedcomNode, t string) *fs_data.FSFact {
+	date := fs_date.Parse(node.childValue("DATE"))
+	place, stdPlace := getStdPlace(strings.TrimSpace(node.childValue("PLAC")))
+
+	// omit OTHER facts that don't have a year or place
+	if t == "OTHER" && date.Year == 0 && place == "" {
+		return nil
+	}
+
+	fsFact := &fs_data.FSFact{
+		Type: proto.String(t),
+	}
+	date.SetFact(fsFact)
+	if place != "" {
+		fsFact.Place = &place
+		fsFact.StdPlace = stdPlace
+	}
+	// events have the value Y to say that they happened without a date or place
+	if value := strings.TrimSpace(node.value); value != "" && value != "Y" && getPointer(value) == "" {
+		fsFact.Value = &value
+	}
+	return fsFact
+}
+
+func getFacts(record *gedcomNode, factTypes map[string]string) (fsFacts []*fs_data.FSFact) {
+	for _, node := range record.children {
+		if t, ok := getFactType(node, factTypes); ok {
+			if fsFact := getFact(node, t); fsFact != nil {
+				fsFacts = append(fsFacts, fsFact)
+			}
+		}
+	}
+	return
+}
+
+func getGender(indi *gedcomNode) fs_data.FSGender {
+	switch strings.ToUpper(strings.TrimSpace(indi.childValue("SEX"))) {
+	case "M":
+		return fs_data.FSGender_MALE
+	case "F":
+		return fs_data.FSGender_FEMALE
+	}
+	return fs_data.FSGender_UNKNOWN
+}
+
+// getName splits a name like "John /Smith/ Jr" into its given name and surname;
+// GIVN and SURN lines take precedence when present
+func getName(node *gedcomNode, preferred bool) *fs_data.FSName {
+	value := node.value
+	given, surname, suffix := value, "", ""
+	if start := strings.Index(value, "/"); start >= 0 {
+		given = value[:start]
+		surname = value[start+1:]
+		if end := strings.Index(surname, "/"); end >= 0 {
+			surname, suffix = surname[:end], surname[end+1:]
+		}
+	}
+	given = strings.Join(strings.Fields(given), " ")
+	surname = strings.Join(strings.Fields(surname), " ")
+	fullText := strings.Join(strings.Fields(given+" "+surname+" "+suffix), " ")
+	if givn := strings.TrimSpace(node.childValue("GIVN")); givn != "" {
+		given = givn
+	}
+	if surn := strings.TrimSpace(node.childValue("SURN")); surn != "" {
+		surname = surn
+	}
+	if fullText == "" && given == "" && surname == "" {
+		return nil
+	}
+
+	fsName := &fs_data.FSName{}
+	if given != "" {
+		fsName.Given = proto.String(given)
+	}
+	if surname != "" {
+		fsName.Surname = proto.String(surname)
+	}
+	if fullText != "" {
+		fsName.FullText = proto.String(fullText)
+	}
+	if t := fs_gedcom.NameTypes[strings.ToLower(strings.TrimSpace(node.childValue("TYPE")))]; t != "" {
+		fsName.Type = proto.String(t)
+	}
+	if preferred {
+		fsName.Preferred = proto.Bool(true)
+	}
+	return fsName
+}
+
+func getNames(indi *gedcomNode) (fsNames []*fs_data.FSName) {
+	for _, node := range indi.children {
+		if node.tag == "NAME" {
+			if fsName := getName(node, len(fsNames) == 0); fsName != nil {
+				fsNames = append(fsNames, fsName)
+			}
+		}
+	}
+	return
+}
+
+// getSources returns the sources cited by a record or its facts
+func getSources(record *gedcomNode, sourceTitles map[string]string) (sources []*fs_data.FSSource) {
+	seen := make(map[string]bool)
+	var addSources func(node *gedcomNode)
+	addSources = func(node *gedcomNode) {
+		for _, child := range node.children {
+			if id := getPointer(child.value); child.tag == "SOUR" && id != "" && !seen[id] {
+				seen[id] = true
+				fsSource := &fs_data.FSSource{SourceId: proto.String(id)}
+				if title := sourceTitles[id]; title != "" {
+					fsSource.Title = proto.String(title)
+				}
+				sources = append(sources, fsSource)
+			} else if child.tag != "SOUR" {
+				addSources(child)
+			}
+		}
+	}
+	addSources(record)
+	return
+}
+
+func getContributors(record *gedcomNode) (contributors []string) {
+	for _, node := range record.children {
+		if id := getPointer(node.value); node.tag == "SUBM" && id != "" {
+			contributors = append(contributors, id)
+		}
+	}
+	return
+}
+
+// getModified returns the CHAN date and time of a record in milliseconds since the epoch, or 0
+func getModified(record *gedcomNode) int64 {
+	change := record.child("CHAN")
+	if change == nil {
+		return 0
+	}
+	dateNode := change.child("DATE")
+	if dateNode == nil {
+		return 0
+	}
+	text := strings.TrimSpace(dateNode.value)
+	layout := "2 Jan 2006"
+	if clock := strings.TrimSpace(dateNode.childValue("TIME")); clock != "" {
+		text += " " + clock
+		layout += " 15:04:05"
+		if strings.Count(clock, ":") == 1 {
+			layout = "2 Jan 2006 15:04"
+		} else if strings.Contains(clock, ".") {
+			layout += ".999"
+		}
+	}
+	t, err := time.Parse(layout, text)
+	if err != nil {
+		return 0
+	}
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+func getPerson(indi *gedcomNode, sourceTitles map[string]string) *fs_data.FamilySearchPerson {
+	gender := getGender(indi)
+	fsPerson := &fs_data.FamilySearchPerson{
+		Id:           proto.String(indi.xref),
+		Gender:       &gender,
+		Names:        getNames(indi),
+		Contributors: getContributors(indi),
+		Sources:      getSources(indi, sourceTitles),
+		Facts:        getFacts(indi, fs_gedcom.PersonFactTypes),
+	}
+	if modified := getModified(indi); modified != 0 {
+		fsPerson.Modified = &modified
+	}
+	return fsPerson
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func addRelationship(fsPerson *fs_data.FamilySearchPerson, typ fs_data.FSRelationshipType, relID string,
+	facts []*fs_data.FSFact, contributors []string) {
+	var ids *[]string
+	switch typ {
+	case fs_data.FSRelationshipType_PARENT:
+		ids = &fsPerson.Parents
+	case fs_data.FSRelationshipType_CHILD:
+		ids = &fsPerson.Children
+	case fs_data.FSRelationshipType_SPOUSE:
+		ids = &fsPerson.Spouses
+	}
+	if relID == "" || relID == fsPerson.GetId() || containsString(*ids, relID) {
+		return
+	}
+	*ids = append(*ids, relID)
+	fsPerson.Relationships = append(fsPerson.Relationships, &fs_data.FSRelationship{
+		Type:         &typ,
+		RelatedId:    proto.String(relID),
+		Facts:        facts,
+		Contributors: contributors,
+	})
+}
+
+// addFamily links the spouses and children of a FAM record; family events become facts of the couple relationship
+func addFamily(fam *gedcomNode, persons map[string]*fs_data.FamilySearchPerson) {
+	var spouses, children []string
+	for _, node := range fam.children {
+		id := getPointer(node.value)
+		if id == "" {
+			continue
+		}
+		if node.tag == "HUSB" || node.tag == "WIFE" {
+			spouses = append(spouses, id)
+		} else if node.tag == "CHIL" {
+			children = append(children, id)
+		}
+	}
+	facts := getFacts(fam, fs_gedcom.FamilyFactTypes)
+	contributors := getContributors(fam)
+
+	for _, spouse := range spouses {
+		if fsPerson := persons[spouse]; fsPerson != nil {
+			for _, other := range spouses {
+				addRelationship(fsPerson, fs_data.FSRelationshipType_SPOUSE, other, facts, contributors)
+			}
+			for _, child := range children {
+				addRelationship(fsPerson, fs_data.FSRelationshipType_CHILD, child, nil, contributors)
+			}
+		}
+	}
+	for _, child := range children {
+		if fsPerson := persons[child]; fsPerson != nil {
+			for _, parent := range spouses {
+				addRelationship(fsPerson, fs_data.FSRelationshipType_PARENT, parent, nil, contributors)
+			}
+		}
+	}
+}
+
+// convert returns a FamilySearchPerson for each INDI record, in file order
+func convert(records []*gedcomNode) (fsPersons []*fs_data.FamilySearchPerson, familyCount int) {
+	sourceTitles := make(map[string]string)
+	for _, record := range records {
+		if record.tag == "SOUR" && record.xref != "" {
+			sourceTitles[record.xref] = strings.TrimSpace(record.childValue("TITL"))
+		}
+	}
+
+	persons := make(map[string]*fs_data.FamilySearchPerson)
+	for _, record := range records {
+		if record.tag == "INDI" && record.xref != "" && persons[record.xref] == nil {
+			fsPerson := getPerson(record, sourceTitles)
+			persons[record.xref] = fsPerson
+			fsPersons = append(fsPersons, fsPerson)
+		}
+	}
+	for _, record := range records {
+		if record.tag == "FAM" {
+			addFamily(record, persons)
+			familyCount++
+		}
+	}
+	return
+}
+
+func check(err error) {
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func writePersons(w io.Writer, fsPersons []*fs_data.FamilySearchPerson, streamOutput bool, tmpDir string) error {
+	if !streamOutput {
+		data, err := proto.Marshal(&fs_data.FamilySearchPersons{Persons: fsPersons})
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(data)
+		return err
+	}
+
+	sw, err := fs_data.NewStreamWriter(w, "gedcom2protobuf", tmpDir)
+	if err != nil {
+		return err
+	}
+	defer sw.Abort()
+	for _, fsPerson := range fsPersons {
+		if err = sw.Write(fsPerson); err != nil {
+			return err
+		}
+	}
+	return sw.Close()
+}
+
+var stdPlacesFilename = flag.String("p", "", "standardized places filename (optional)")
+var abbreviationsFilename = flag.String("a", "", "place abbreviations filename (optional)")
+var inFilename = flag.String("i", "", "input GEDCOM filename")
+var outFilename = flag.String("o", "", "output filename")
+var gzipOutput = flag.Bool("z", false, "gzip output")
+var streamOutput = flag.Bool("stream", false, "write a length-delimited stream file instead of a FamilySearchPersons message")
+
+func main() {
+	flag.Parse()
+
+	if *stdPlacesFilename != "" {
+		fmt.Println("Reading places")
+		if *abbreviationsFilename != "" {
+			abbreviationsFile, err := os.Open(*abbreviationsFilename)
+			check(err)
+			check(fs_place.ReadAbbreviations(abbreviationsFile, fs_place.LevelAbbreviations, fs_place.WordAbbreviations))
+			abbreviationsFile.Close()
+		}
+		places = fs_place.NewMatcher(fs_place.LevelAbbreviations, fs_place.WordAbbreviations)
+		stdPlacesFile, err := os.Open(*stdPlacesFilename)
+		check(err)
+		check(fs_place.ReadStdPlaces(stdPlacesFile, places))
+		stdPlacesFile.Close()
+	}
+
+	fmt.Println("Reading GEDCOM")
+	in, err := fs_reader.OpenFile(*inFilename)
+	check(err)
+	records, badLines, err := readGedcom(in)
+	in.Close()
+	check(err)
+	if len(records) > 0 && records[0].tag == "HEAD" {
+		if charset := strings.ToUpper(records[0].childValue("CHAR")); charset != "" && charset != "UTF-8" && charset != "ASCII" {
+			log.Printf("Warning: %s characters are not converted to UTF-8", charset)
+		}
+	}
+
+	fsPersons, familyCount := convert(records)
+
+	out, err := os.Create(*outFilename)
+	check(err)
+	defer out.Close()
+	buf := bufio.NewWriter(out)
+	var w io.Writer = buf
+	var zw *gzip.Writer
+	if *gzipOutput {
+		zw = gzip.NewWriter(buf)
+		w = zw
+	}
+	check(writePersons(w, fsPersons, *streamOutput, filepath.Dir(*outFilename)))
+	if zw != nil {
+		check(zw.Close())
+	}
+	check(buf.Flush())
+
+	fmt.Printf("Total persons=%d families=%d skipped lines=%d\n", len(fsPersons), familyCount, badLines)
+}
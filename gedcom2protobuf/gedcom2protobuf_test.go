@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"github.com/rootsdev/fsbff/fs_data"
+	"github.com/rootsdev/fsbff/fs_place"
+	"strings"
+	"testing"
+)
+
+const testGedcom = "\ufeff0 HEAD\r\n1 CHAR UTF-8\r\n" + `0 @I1@ INDI
+1 NAME John /Smith/ Jr
+2 TYPE birth
+1 NAME Jack /Smith/
+2 TYPE aka
+1 SEX M
+1 BIRT
+2 DATE ABT 1850
+2 PLAC Springfield, Ohio
+2 SOUR @S1@
+1 OCCU Farmer
+1 RESI Y
+1 EVEN
+2 TYPE Military Service
+2 DATE 1862
+1 _UID 12345
+1 NOTE a note
+2 CONT on two lines
+1 FAMS @F1@
+1 SUBM @U1@
+1 CHAN
+2 DATE 5 MAR 2010
+3 TIME 12:30:15
+0 @I2@ INDI
+1 NAME Mary /Jones/
+2 GIVN Mary Ann
+1 SEX F
+1 DEAT
+2 DATE BET 1900 AND 1910
+2 PLAC Ohio
+garbage line
+1 FAMS @F1@
+0 @I3@ INDI
+1 NAME Tom /Smith/
+1 SEX M
+1 FAMC @F1@
+0 @F1@ FAM
+1 HUSB @I1@
+1 WIFE @I2@
+1 CHIL @I3@
+1 CHIL @I4@
+1 MARR
+2 DATE 12 JUN 1875
+1 DIV Y
+0 @S1@ SOUR
+1 TITL Ohio Births,
+2 CONC  1800-1900
+3 bad level
+0 CONT stray
+0 TRLR
+`
+
+func TestReadGedcom(t *testing.T) {
+	records, badLines, err := readGedcom(strings.NewReader(testGedcom))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if badLines != 3 {
+		t.Errorf("readGedcom bad lines = %d; want 3", badLines)
+	}
+	var tags []string
+	for _, record := range records {
+		tags = append(tags, record.xref+":"+record.tag)
+	}
+	if strings.Join(tags, ",") != ":HEAD,I1:INDI,I2:INDI,I3:INDI,F1:FAM,S1:SOUR,:TRLR" {
+		t.Errorf("readGedcom records = %v", tags)
+	}
+	if note := records[1].childValue("NOTE"); note != "a note\non two lines" {
+		t.Errorf("readGedcom CONT = %q; want %q", note, "a note\non two lines")
+	}
+	if title := records[5].childValue("TITL"); title != "Ohio Births, 1800-1900" {
+		t.Errorf("readGedcom CONC = %q; want %q", title, "Ohio Births, 1800-1900")
+	}
+}
+
+func formatFacts(fsFacts []*fs_data.FSFact) string {
+	var facts []string
+	for _, fsFact := range fsFacts {
+		var modifier fs_data.FSDateModifier
+		if fsFact.Modifier != nil {
+			modifier = *fsFact.Modifier
+		}
+		facts = append(facts, fmt.Sprintf("%s/%d/%d/%d/%s/%s", fsFact.GetType(), fsFact.GetYear(), fsFact.GetEndYear(),
+			modifier, fsFact.GetPlace(), fsFact.GetValue()))
+	}
+	return strings.Join(facts, ",")
+}
+
+func TestConvert(t *testing.T) {
+	records, _, err := readGedcom(strings.NewReader(testGedcom))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fsPersons, familyCount := convert(records)
+	if len(fsPersons) != 3 || familyCount != 1 {
+		t.Fatalf("convert = %d persons %d families; want 3 persons 1 family", len(fsPersons), familyCount)
+	}
+	john, mary, tom := fsPersons[0], fsPersons[1], fsPersons[2]
+
+	var tests = []struct {
+		name   string
+		actual string
+		want   string
+	}{
+		{"gender", fmt.Sprint(john.GetGender(), mary.GetGender(), tom.GetGender()), "MALE FEMALE MALE"},
+		{"names", fmt.Sprintf("%s/%s/%s/%s/%v %s/%s/%s/%s/%v",
+			john.Names[0].GetGiven(), john.Names[0].GetSurname(), john.Names[0].GetFullText(), john.Names[0].GetType(),
+			john.Names[0].GetPreferred(), john.Names[1].GetGiven(), john.Names[1].GetSurname(),
+			john.Names[1].GetFullText(), john.Names[1].GetType(), john.Names[1].GetPreferred()),
+			"John/Smith/John Smith Jr/BirthName/true Jack/Smith/Jack Smith/AlsoKnownAs/false"},
+		{"given", mary.Names[0].GetGiven(), "Mary Ann"},
+		{"john facts", formatFacts(john.Facts),
+			"Birth/1850/0/1/Springfield, Ohio/,Occupation/0/0/0//Farmer,Residence/0/0/0//,MilitaryService/1862/0/0//"},
+		{"mary facts", formatFacts(mary.Facts), "Death/1900/1910/4/Ohio/"},
+		{"sources", fmt.Sprintf("%s/%s", john.Sources[0].GetSourceId(), john.Sources[0].GetTitle()), "S1/Ohio Births, 1800-1900"},
+		{"contributors", strings.Join(john.Contributors, ","), "U1"},
+		{"modified", fmt.Sprint(john.GetModified(), mary.GetModified()), "1267792215000 0"},
+		{"john links", fmt.Sprint(john.Parents, john.Spouses, john.Children), "[] [I2] [I3 I4]"},
+		{"mary links", fmt.Sprint(mary.Parents, mary.Spouses, mary.Children), "[] [I1] [I3 I4]"},
+		{"tom links", fmt.Sprint(tom.Parents, tom.Spouses, tom.Children), "[I1 I2] [] []"},
+		{"couple facts", formatFacts(john.Relationships[0].Facts), "Marriage/1875/0/0//,Divorce/0/0/0//"},
+		{"relationships", fmt.Sprintf("%v %v %v %v", john.Relationships[0].GetType(), john.Relationships[1].GetType(),
+			tom.Relationships[0].GetType(), tom.Relationships[1].GetRelatedId()), "SPOUSE CHILD PARENT I2"},
+	}
+	for _, test := range tests {
+		if test.actual != test.want {
+			t.Errorf("convert %s = %q; want %q", test.name, test.actual, test.want)
+		}
+	}
+}
+
+func TestGetStdPlace(t *testing.T) {
+	const stdPlacesFile = "Springfield, Ohio\tSpringfield, Clark, Ohio, United States\n" +
+		"line without a tab\n"
+	places = fs_place.NewMatcher(fs_place.LevelAbbreviations, fs_place.WordAbbreviations)
+	defer func() { places = nil }()
+	if err := fs_place.ReadStdPlaces(strings.NewReader(stdPlacesFile), places); err != nil {
+		t.Fatal(err)
+	}
+	var tests = []struct {
+		in  string
+		out string
+	}{
+		{"Springfield, Ohio", "Springfield, Clark, Ohio, United States"},
+		{"Springfield, Clark Co., OH", "Springfield, Clark, Ohio, United States"},
+		{"line without a tab", "line without a tab"},
+		{"Dayton,\tOhio", "Dayton, Ohio"},
+	}
+	for _, test := range tests {
+		if out, _ := getStdPlace(test.in); out != test.out {
+			t.Errorf("getStdPlace(%q) = %q; want %q", test.in, out, test.out)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/rootsdev/fsbff/fs_index"
+	"github.com/rootsdev/fsbff/fs_reader"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+/*
+Builds an index of the persons in a protobuf file or directory, mapping each person ID to the file
+and offset of the person, so that proto_dump -index can read persons by ID without scanning every file.
+File names in the index are relative to the indexed directory, so the directory can be moved with it.
+*/
+
+// indexPersons indexes the persons in a file or directory, returning the number of persons
+func indexPersons(path, filename, tmpDir string, sortLines int) (int, error) {
+	dir := path
+	if fileInfo, err := os.Stat(path); err != nil {
+		return 0, err
+	} else if !fileInfo.IsDir() {
+		dir = filepath.Dir(path)
+	}
+	source, err := fs_reader.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer source.Close()
+
+	b := fs_index.NewBuilder(tmpDir, sortLines)
+	defer b.Close()
+	persons := 0
+	for source.Next() {
+		if source.Offset() < 0 {
+			return persons, fmt.Errorf("%s: offsets of persons are unknown", source.Filename())
+		}
+		name, err := filepath.Rel(dir, source.Filename())
+		if err != nil {
+			return persons, err
+		}
+		if err = b.Add(source.Person().GetId(), fs_reader.PersonIndexValue(name, source.Offset())); err != nil {
+			return persons, err
+		}
+		persons++
+	}
+	if err = source.Err(); err != nil {
+		return persons, err
+	}
+	return persons, b.Write(filename)
+}
+
+func check(err error) {
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+var inFilename = flag.String("i", "", "protobuf filename or directory")
+var outFilename = flag.String("o", "", "index filename")
+var tmpDir = flag.String("t", "", "temporary directory for sorting")
+var sortLines = flag.Int("sortlines", 5000000, "number of persons sorted in memory at a time")
+
+func main() {
+	flag.Parse()
+
+	fmt.Println("Indexing persons")
+	dir := *tmpDir
+	if dir == "" {
+		dir = filepath.Dir(*outFilename)
+	}
+	persons, err := indexPersons(*inFilename, *outFilename, dir, *sortLines)
+	check(err)
+	fmt.Printf("Indexed persons=%d\n", persons)
+
+	fmt.Println("Verifying index")
+	index, err := fs_index.Open(*outFilename)
+	check(err)
+	check(index.Verify())
+	index.Close()
+}
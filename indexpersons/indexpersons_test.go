@@ -0,0 +1,80 @@
+package main
+
+import (
+	"code.google.com/p/goprotobuf/proto"
+	"github.com/rootsdev/fsbff/fs_data"
+	"github.com/rootsdev/fsbff/fs_reader"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestIndexPersons(t *testing.T) {
+	dir, err := ioutil.TempDir("", "indexpersons")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	personsDir := filepath.Join(dir, "persons")
+	if err = os.Mkdir(personsDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	fsPersons := &fs_data.FamilySearchPersons{}
+	for _, id := range []string{"A", "B", "C"} {
+		fsPersons.Persons = append(fsPersons.Persons, &fs_data.FamilySearchPerson{
+			Id:       proto.String(id),
+			Children: []string{id + "1"},
+		})
+	}
+	b, err := proto.Marshal(fsPersons)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(filepath.Join(personsDir, "a.protobuf"), b, 0644); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Create(filepath.Join(personsDir, "b.protobuf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := fs_data.NewStreamWriter(file, "test", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"D", "E"} {
+		if err = w.Write(&fs_data.FamilySearchPerson{Id: proto.String(id)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	filename := filepath.Join(dir, "persons.index")
+	persons, err := indexPersons(personsDir, filename, dir, 2)
+	if err != nil || persons != 5 {
+		t.Fatalf("indexPersons = %d, %v; want 5", persons, err)
+	}
+	index, err := fs_reader.OpenPersonIndex(filename, personsDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer index.Close()
+	for _, id := range []string{"A", "B", "C", "D", "E", "F"} {
+		person, err := index.Find(id)
+		found := id != "F"
+		if err != nil || (person != nil) != found || (found && person.GetId() != id) {
+			t.Errorf("Find(%s) = %v, %v; want found %v", id, person, err, found)
+		}
+	}
+	if person, _ := index.Find("B"); len(person.GetChildren()) != 1 || person.GetChildren()[0] != "B1" {
+		t.Errorf("Find(B) = %v; want child B1", person)
+	}
+
+	if _, err = indexPersons(filepath.Join(dir, "missing"), filename, dir, 2); err == nil {
+		t.Errorf("indexPersons(missing) returned no error")
+	}
+}
@@ -2,17 +2,14 @@ package main
 
 import (
 	"bufio"
-	"code.google.com/p/goprotobuf/proto"
-	"compress/gzip"
+	"context"
 	"flag"
 	"fmt"
 	"github.com/rootsdev/fsbff/fs_data"
-	"io"
-	"io/ioutil"
+	"github.com/rootsdev/fsbff/fs_parallel"
+	"github.com/rootsdev/fsbff/fs_reader"
 	"log"
-	"math"
 	"os"
-	"runtime"
 	"sort"
 	"strings"
 )
@@ -62,9 +59,11 @@ type Migrations struct {
 }
 
 func (m Migrations) add(from, to Location) {
-    // standardize places into the correct levels
-    stdFromLevels := stdPlace(from.place)
-    stdToLevels := stdPlace(to.place)
+	m.addLevels(from, stdPlace(from.place), to, stdPlace(to.place))
+}
+
+// addLevels adds a migration between locations whose places have already been standardized into levels
+func (m Migrations) addLevels(from Location, stdFromLevels []string, to Location, stdToLevels []string) {
     stdFromYear := from.year - from.year % YEAR_GRANULARITY
     stdToYear := to.year - to.year % YEAR_GRANULARITY
 
@@ -126,13 +125,40 @@ func stdPlace(place string) []string {
     return results
 }
 
+// migrationLevelTypes are the types of the place levels that migrations are counted between
+var migrationLevelTypes = map[string]bool{"county": true, "state": true, "province": true, "country": true}
+
+// placeLevels returns the levels of a fact's place that migrations are counted between, taken from its
+// structured place when the level types are known, and otherwise by splitting the place text
+func placeLevels(fact *fs_data.FSFact) []string {
+	var levels []string
+	for _, level := range fact.GetStdPlace().GetLevels() {
+		if migrationLevelTypes[strings.ToLower(level.GetType())] {
+			levels = append(levels, level.GetName())
+		}
+	}
+	if len(levels) == 0 {
+		return stdPlace(fact.GetPlace())
+	}
+	return levels
+}
+
 func NewLocation(fact *fs_data.FSFact) Location {
 	year := *fact.Year
 	return Location{*fact.Place, year}
 }
 
-func processFile(filename string) Migrations {
-	fsPersons := readPersons(filename)
+// factLocation is the location of a fact and the levels of its place
+type factLocation struct {
+	Location
+	levels []string
+}
+
+func processFile(filename string) (Migrations, error) {
+	fsPersons, err := fs_reader.ReadFile(filename)
+	if err != nil {
+		return Migrations{}, err
+	}
 	
 	migrations := Migrations {
         immigrations: make(MigrationMap),
@@ -140,34 +166,39 @@ func processFile(filename string) Migrations {
     }
 
 	for _, person := range fsPersons.Persons {
-		locations := NewLocations()
-		for _, fact := range person.Facts {
+		var locations []factLocation
+		for _, fact := range person.GetAllFacts() {
 			if fact.Place != nil && fact.Year != nil {
-				locations = append(locations, NewLocation(fact))
+				locations = append(locations, factLocation{NewLocation(fact), placeLevels(fact)})
 			}
 		}
 		if len(locations) <= 1 {
             migrations.singletons++
 			continue
 		}
-		sort.Sort(locations)
+		sort.Slice(locations, func(i, j int) bool {
+			if locations[i].place == locations[j].place {
+				return locations[i].year < locations[j].year
+			}
+			return locations[i].place < locations[j].place
+		})
 		// If place changes from one location to the next, we have
 		// a migration. Record the most recent location as "from"
 		// and new location as "to".
 		//fmt.Println("Person", person)
-		prev := Location{}
+		prev := factLocation{}
 		for _, location := range locations {
 			if prev.year >= 1500 && prev.year <= 2015 &&
                 location.year >= 1500 && location.year <= 2015 {
                 // move the migration test into the add function so we can calculate "total"'s
                 //migrated(prev.place, location.place) {
-				migrations.add(prev, location)
+				migrations.addLevels(prev.Location, prev.levels, location.Location, location.levels)
 				//fmt.Printf("Migrated from: %v to %v (%d migrations)\n", prev, location, migrations[prev][location])
 			}
 			prev = location
 		}
 	}
-	return migrations
+	return migrations, nil
 }
 
 // Determines if the 'from' and 'to' strings represent a migration, that
@@ -222,60 +253,6 @@ func check(err error) {
 	}
 }
 
-func readPersons(filename string) *fs_data.FamilySearchPersons {
-	var file io.ReadCloser
-	var err error
-
-	file, err = os.Open(filename)
-	check(err)
-	defer file.Close()
-
-	if strings.HasSuffix(filename, ".gz") {
-		file, err = gzip.NewReader(file)
-		check(err)
-		defer file.Close()
-	}
-
-	bytes, err := ioutil.ReadAll(file)
-	check(err)
-
-	fsPersons := &fs_data.FamilySearchPersons{}
-	err = proto.Unmarshal(bytes, fsPersons)
-	check(err)
-	
-	return fsPersons
-}
-
-func processFiles(fileNames chan string, results chan Migrations) {
-	for fileName := range fileNames {
-		//fmt.Printf("Processing file: %s", fileName)
-		m := processFile(fileName)
-		//fmt.Printf("; found %d immigration and %d emigration starts", len(m.immigrations), len(m.emigrations))
-		results <- m
-	}
-}
-
-func getFilenames(filename string) (int, chan string) {
-	numFiles := 0
-	fileNames := make(chan string, 100000)
-	fileInfo, err := os.Stat(filename)
-	check(err)
-	if fileInfo.IsDir() {
-		fileInfos, err := ioutil.ReadDir(filename)
-		check(err)
-		for _, fileInfo := range fileInfos {
-			fileNames <- filename + "/" + fileInfo.Name()
-			numFiles++
-		}
-	} else {
-		fileNames <- filename
-		numFiles++
-	}
-	close(fileNames)
-
-	return numFiles, fileNames
-}
-
 func writeMigrations(migrations map[Location]map[string]int, label, filename string) {
     out, err := os.Create(filename)
    	check(err)
@@ -314,18 +291,12 @@ var numWorkers = flag.Int("w", 1, "number of workers)")
 func main() {
 	flag.Parse()
 
-	numCPU := runtime.NumCPU()
-	fmt.Printf("Number of CPUs=%d\n", numCPU)
-	runtime.GOMAXPROCS(int(math.Min(float64(numCPU), float64(*numWorkers))))
+	fmt.Printf("Number of CPUs=%d\n", fs_parallel.SetMaxProcs(*numWorkers))
 
-	numFiles, fileNames := getFilenames(*inFilename)
+	fileNames, err := fs_reader.Filenames(*inFilename)
+	check(err)
 
 	fmt.Print("Processing files")
-	results := make(chan Migrations)
-
-	for i := 0; i < *numWorkers; i++ {
-		go processFiles(fileNames, results)
-	}
 
 	// Merge all the resulting migration maps
     migrations := Migrations {
@@ -333,24 +304,27 @@ func main() {
         immigrations: make(MigrationMap),
         emigrations: make(MigrationMap),
     }
-	for i := 0; i < numFiles; i++ {
-		m := <-results
-        migrations.singletons += m.singletons
-        for from, toMap := range m.emigrations {
-            for to, count := range toMap {
-                migrations.emigrations.add(from, to, count)
-            }
-        }
-        for to, fromMap := range m.immigrations {
-            for from, count := range fromMap {
-                migrations.immigrations.add(to, from, count)
-            }
-        }
-        // monitor how many files have been processed
-        if i % 100 == 0 {
-            fmt.Print(".")
-        }
-	}
+	err = fs_parallel.Run(context.Background(), fileNames,
+		fs_parallel.Options{Workers: *numWorkers, Progress: fs_parallel.Dots(100)},
+		func(ctx context.Context, fileName string) (interface{}, error) {
+			return processFile(fileName)
+		},
+		func(fileName string, result interface{}) error {
+			m := result.(Migrations)
+			migrations.singletons += m.singletons
+			for from, toMap := range m.emigrations {
+				for to, count := range toMap {
+					migrations.emigrations.add(from, to, count)
+				}
+			}
+			for to, fromMap := range m.immigrations {
+				for from, count := range fromMap {
+					migrations.immigrations.add(to, from, count)
+				}
+			}
+			return nil
+		})
+	check(err)
     totalImmigrations := countTotals(migrations.immigrations)
     totalEmigrations := countTotals(migrations.emigrations)
 	fmt.Printf("\n\nTotal singletons: %d immigrations: %d emigrations %d\n", migrations.singletons, totalImmigrations, totalEmigrations)
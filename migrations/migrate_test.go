@@ -1,6 +1,8 @@
 package main
 
 import (
+	"code.google.com/p/goprotobuf/proto"
+	"github.com/rootsdev/fsbff/fs_data"
 	"testing"
 )
 
@@ -199,4 +201,30 @@ func TestMigrationAdd(t *testing.T) {
     if cnt != totalImmigrations {
         t.Errorf("TestMigrationAdd total immigrations got %d; want %d", cnt, totalImmigrations)
     }
-}
\ No newline at end of file
+}
+
+func TestPlaceLevels(t *testing.T) {
+	level := func(name, typ string) *fs_data.FSPlaceLevel {
+		return &fs_data.FSPlaceLevel{Name: proto.String(name), Type: proto.String(typ)}
+	}
+	var tests = []struct {
+		fact      *fs_data.FSFact
+		stdLevels []string
+	}{
+		{&fs_data.FSFact{Place: proto.String("Provo, Utah, Utah, United States")},
+			[]string{"Utah", "Utah", "United States"}},
+		{&fs_data.FSFact{Place: proto.String("Berlin, Brandenburg, Preussen, Germany"), StdPlace: &fs_data.FSPlace{
+			Levels: []*fs_data.FSPlaceLevel{level("Berlin", "City"), level("Brandenburg", "Province"),
+				level("Preussen", "State"), level("Germany", "Country")}}},
+			[]string{"Brandenburg", "Preussen", "Germany"}},
+		{&fs_data.FSFact{Place: proto.String("Sussex, England"), StdPlace: &fs_data.FSPlace{
+			Levels: []*fs_data.FSPlaceLevel{{Name: proto.String("Sussex")}, {Name: proto.String("England")}}}},
+			[]string{"Sussex", "England"}},
+	}
+	for _, test := range tests {
+		stdLevels := placeLevels(test.fact)
+		if !equalStringSlice(stdLevels, test.stdLevels) {
+			t.Errorf("placeLevels(%q) got %v; want %v", test.fact.GetPlace(), stdLevels, test.stdLevels)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bufio"
+	"code.google.com/p/goprotobuf/proto"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/rootsdev/fsbff/fs_data"
+	"io"
+	"strconv"
+	"strings"
+)
+
+/*
+Output formats:
+	go    Go %+v dumps of each person, or with -f just their ids or names
+	json  one JSON object per line, with enums as names
+	text  protobuf text format; the output as a whole is a FamilySearchPersons message
+	csv   one row per fact of id, gender, fact type, year, place and value, after a header row
+*/
+
+var formats = []string{"go", "json", "text", "csv"}
+
+var csvHeader = []string{"id", "gender", "type", "year", "place", "value"}
+
+// dumper writes persons in an output format
+type dumper struct {
+	format string
+	field  string
+	w      *bufio.Writer
+	csv    *csv.Writer
+}
+
+func newDumper(w io.Writer, format string, field string) (*dumper, error) {
+	d := &dumper{format: format, field: field, w: bufio.NewWriter(w)}
+	switch format {
+	case "go", "json", "text":
+	case "csv":
+		d.csv = csv.NewWriter(d.w)
+		if err := d.csv.Write(csvHeader); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown format %q; want one of %s", format, strings.Join(formats, ", "))
+	}
+	return d, nil
+}
+
+// dump writes the i'th person dumped
+func (d *dumper) dump(i int, person *fs_data.FamilySearchPerson) error {
+	switch d.format {
+	case "json":
+		b, err := json.Marshal(person)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(d.w, "%s\n", b)
+		return err
+	case "text":
+		text := proto.MarshalTextString(person)
+		text = "  " + strings.Replace(strings.TrimSuffix(text, "\n"), "\n", "\n  ", -1)
+		_, err := fmt.Fprintf(d.w, "persons {\n%s\n}\n", text)
+		return err
+	case "csv":
+		return d.dumpFacts(person)
+	}
+
+	var err error
+	switch d.field {
+	case "i":
+		_, err = fmt.Fprintf(d.w, "%s\n", person.GetId())
+	case "n":
+		names := make([]string, 0, len(person.GetNames()))
+		for _, name := range person.GetNames() {
+			names = append(names, formatName(name))
+		}
+		_, err = fmt.Fprintf(d.w, "%s\t%s\n", person.GetId(), strings.Join(names, "; "))
+	default:
+		_, err = fmt.Fprintf(d.w, "fsPersons[%d]=%+v\n\n", i, person)
+	}
+	return err
+}
+
+func (d *dumper) dumpFacts(person *fs_data.FamilySearchPerson) error {
+	gender := ""
+	if person.Gender != nil {
+		gender = person.GetGender().String()
+	}
+	for _, fact := range person.GetAllFacts() {
+		year := ""
+		if fact.Year != nil {
+			year = strconv.Itoa(int(fact.GetYear()))
+		}
+		row := []string{person.GetId(), gender, fact.GetType(), year, fact.GetPlace(), fact.GetValue()}
+		if err := d.csv.Write(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// flush writes any buffered output
+func (d *dumper) flush() error {
+	if d.csv != nil {
+		d.csv.Flush()
+		if err := d.csv.Error(); err != nil {
+			return err
+		}
+	}
+	return d.w.Flush()
+}
@@ -1,43 +1,249 @@
 package main
 
 import (
-	"code.google.com/p/goprotobuf/proto"
+	"bufio"
 	"flag"
 	"fmt"
 	"github.com/rootsdev/fsbff/fs_data"
-	"io/ioutil"
+	"github.com/rootsdev/fsbff/fs_reader"
+	"github.com/rootsdev/fsbff/fs_shard"
 	"log"
+	"math/rand"
 	"os"
+	"path/filepath"
+	"strings"
 )
 
+/*
+Dumps the first records (or all of them with -n 0) of a protobuf file or directory in one of several
+formats (see formats.go). Records can be skipped with -offset and sampled at random with -sample;
+gzipped files are read transparently. A summary of the records read and dumped is written to stderr,
+so it doesn't mix with json or csv output.
+
+With -id or -idfile, the persons with the given IDs are dumped instead. Persons are found with the
+person index built by indexpersons if -index is given, in just their shards if the directory was written
+with fsxml2protobuf -shards, and otherwise by scanning every file. With -relatives, the parents, spouses
+and children of each person are dumped after them.
+*/
+
 func check(err error) {
 	if err != nil {
 		log.Fatal(err)
 	}
 }
 
-func main() {
-	var numRecords = flag.Int("n", 10, "number of records to dump")
-	var field = flag.String("f", "", "field to dump: [a]ll, [i]d")
-	flag.Parse()
-	
-	file, err := os.Open(flag.Arg(0))
-	check(err)
+func formatName(name *fs_data.FSName) string {
+	text := name.GetFullText()
+	if text == "" {
+		text = name.GetGiven()
+		if name.GetSurname() != "" {
+			text = strings.TrimSpace(text + " /" + name.GetSurname() + "/")
+		}
+	}
+	if name.GetType() != "" {
+		text += " (" + name.GetType() + ")"
+	}
+	if name.GetPreferred() {
+		text += " *"
+	}
+	return text
+}
+
+// openPersonIndex opens the index of the persons in path; indexpersons stores file names relative to the
+// indexed directory, or to the directory of the indexed file
+func openPersonIndex(filename string, path string) (*fs_reader.PersonIndex, error) {
+	dir := path
+	if fileInfo, err := os.Stat(path); err != nil {
+		return nil, err
+	} else if !fileInfo.IsDir() {
+		dir = filepath.Dir(path)
+	}
+	return fs_reader.OpenPersonIndex(filename, dir)
+}
+
+// findPersons returns the persons with the given IDs that can be found
+func findPersons(path string, index *fs_reader.PersonIndex, ids []string) (
+	map[string]*fs_data.FamilySearchPerson, error) {
+	persons := make(map[string]*fs_data.FamilySearchPerson)
+	if index == nil {
+		if _, err := os.Stat(filepath.Join(path, fs_shard.ManifestName)); err != nil {
+			return scanPersons(path, ids)
+		}
+	}
+	for _, id := range ids {
+		var person *fs_data.FamilySearchPerson
+		var err error
+		if index != nil {
+			person, err = index.Find(id)
+		} else {
+			person, err = fs_reader.FindPerson(path, id)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if person != nil {
+			persons[id] = person
+		}
+	}
+	return persons, nil
+}
+
+// scanPersons reads every file in path to find the persons with the given IDs
+func scanPersons(path string, ids []string) (map[string]*fs_data.FamilySearchPerson, error) {
+	wanted := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		wanted[id] = true
+	}
+	persons := make(map[string]*fs_data.FamilySearchPerson)
+	source, err := fs_reader.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer source.Close()
+	for source.Next() && len(persons) < len(wanted) {
+		if id := source.Person().GetId(); wanted[id] && persons[id] == nil {
+			persons[id] = source.Person()
+		}
+	}
+	return persons, source.Err()
+}
+
+// getRelatives returns the IDs of the parents, spouses and children of persons that aren't in seen
+func getRelatives(persons []*fs_data.FamilySearchPerson, seen map[string]bool) []string {
+	var relatives []string
+	for _, person := range persons {
+		for _, ids := range [][]string{person.GetParents(), person.GetSpouses(), person.GetChildren()} {
+			for _, id := range ids {
+				if !seen[id] {
+					seen[id] = true
+					relatives = append(relatives, id)
+				}
+			}
+		}
+	}
+	return relatives
+}
+
+// queryPersons returns the persons with the given IDs in order, followed by their relatives if requested;
+// IDs that can't be found are logged
+func queryPersons(path string, index *fs_reader.PersonIndex, ids []string, relatives bool) (
+	[]*fs_data.FamilySearchPerson, error) {
+	seen := make(map[string]bool)
+	var persons []*fs_data.FamilySearchPerson
+	for pass := 0; pass < 2 && len(ids) > 0; pass++ {
+		found, err := findPersons(path, index, ids)
+		if err != nil {
+			return nil, err
+		}
+		start := len(persons)
+		for _, id := range ids {
+			seen[id] = true
+			if found[id] == nil {
+				log.Printf("Person %s not found", id)
+				continue
+			}
+			persons = append(persons, found[id])
+		}
+		if !relatives {
+			break
+		}
+		ids = getRelatives(persons[start:], seen)
+	}
+	return persons, nil
+}
+
+func readIDs(filename string) ([]string, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
 	defer file.Close()
+	var ids []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if id := strings.TrimSpace(scanner.Text()); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	return ids, scanner.Err()
+}
 
-	bytes, err := ioutil.ReadAll(file)
-	check(err)
+// selection selects the records to dump: those after the first offset records, each kept with
+// probability sample, up to limit records (or all of them if limit is 0)
+type selection struct {
+	offset int
+	limit  int
+	sample float64
+	rand   *rand.Rand
+}
 
-	fsPersons := &fs_data.FamilySearchPersons{}
-	err = proto.Unmarshal(bytes, fsPersons)
+// dumpRecords dumps the selected records of a source, returning the number of records read and dumped
+func dumpRecords(source *fs_reader.PersonSource, d *dumper, sel selection) (read int, dumped int, err error) {
+	for (sel.limit == 0 || dumped < sel.limit) && source.Next() {
+		read++
+		if read <= sel.offset || (sel.sample < 1 && sel.rand.Float64() >= sel.sample) {
+			continue
+		}
+		if err = d.dump(read-1, source.Person()); err != nil {
+			return read, dumped, err
+		}
+		dumped++
+	}
+	return read, dumped, source.Err()
+}
+
+func main() {
+	var numRecords = flag.Int("n", 10, "number of records to dump, or 0 for all")
+	var offset = flag.Int("offset", 0, "number of records to skip")
+	var sample = flag.Float64("sample", 1, "fraction of records to dump, chosen at random")
+	var seed = flag.Int64("seed", 1, "random seed for -sample")
+	var format = flag.String("format", "go", "output format: "+strings.Join(formats, ", "))
+	var field = flag.String("f", "", "field to dump in go format: [a]ll, [i]d, [n]ames")
+	var ids = flag.String("id", "", "comma-separated IDs of persons to dump")
+	var idsFilename = flag.String("idfile", "", "file of IDs of persons to dump, one per line")
+	var indexFilename = flag.String("index", "", "person index built by indexpersons (optional)")
+	var relatives = flag.Bool("relatives", false, "also dump the parents, spouses and children of persons dumped by ID")
+	flag.Parse()
+
+	if *numRecords < 0 || *offset < 0 || *sample <= 0 || *sample > 1 {
+		log.Fatal("-n and -offset must not be negative, and -sample must be greater than 0 and at most 1")
+	}
+	d, err := newDumper(os.Stdout, *format, *field)
 	check(err)
 
-	for i := 0; i < *numRecords; i++ {
-		switch *field {
-		case "i":
-			fmt.Printf("%s\n", fsPersons.Persons[i].GetId())
-		default:
-			fmt.Printf("fsPersons[%d]=%+v\n\n", i, fsPersons.Persons[i])
+	if *ids != "" || *idsFilename != "" {
+		var queryIds []string
+		if *ids != "" {
+			queryIds = strings.Split(*ids, ",")
 		}
+		if *idsFilename != "" {
+			fileIds, err := readIDs(*idsFilename)
+			check(err)
+			queryIds = append(queryIds, fileIds...)
+		}
+		var index *fs_reader.PersonIndex
+		if *indexFilename != "" {
+			index, err = openPersonIndex(*indexFilename, flag.Arg(0))
+			check(err)
+			defer index.Close()
+		}
+		persons, err := queryPersons(flag.Arg(0), index, queryIds, *relatives)
+		check(err)
+		for i, person := range persons {
+			check(d.dump(i, person))
+		}
+		check(d.flush())
+		fmt.Fprintf(os.Stderr, "Total ids=%d persons=%d\n", len(queryIds), len(persons))
+		return
 	}
+
+	source, err := fs_reader.Open(flag.Arg(0))
+	check(err)
+	defer source.Close()
+
+	sel := selection{offset: *offset, limit: *numRecords, sample: *sample, rand: rand.New(rand.NewSource(*seed))}
+	read, dumped, err := dumpRecords(source, d, sel)
+	check(err)
+	check(d.flush())
+	fmt.Fprintf(os.Stderr, "Total records read=%d dumped=%d\n", read, dumped)
 }
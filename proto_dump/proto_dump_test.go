@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bytes"
+	"code.google.com/p/goprotobuf/proto"
+	"compress/gzip"
+	"fmt"
+	"github.com/rootsdev/fsbff/fs_data"
+	"github.com/rootsdev/fsbff/fs_index"
+	"github.com/rootsdev/fsbff/fs_reader"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDumper(t *testing.T) {
+	persons := []*fs_data.FamilySearchPerson{
+		{
+			Id:     proto.String("A"),
+			Gender: fs_data.FSGender_MALE.Enum(),
+			Names:  []*fs_data.FSName{{Given: proto.String("John"), Surname: proto.String("Smith")}},
+			Facts: []*fs_data.FSFact{
+				{Type: proto.String("Birth"), Year: proto.Int32(1850), Place: proto.String("Ohio, United States")},
+				{Type: proto.String("Occupation"), Value: proto.String("farmer, miller")},
+			},
+		},
+		{Id: proto.String("B")},
+	}
+
+	var tests = []struct {
+		format string
+		field  string
+		out    string
+	}{
+		{"go", "i", "A\nB\n"},
+		{"go", "n", "A\tJohn /Smith/\nB\t\n"},
+		{"json", "", `{"id":"A","gender":"MALE","facts":[{"type":"Birth","year":1850,"place":"Ohio, United States"},` +
+			`{"type":"Occupation","value":"farmer, miller"}],"names":[{"given":"John","surname":"Smith"}]}` + "\n" +
+			`{"id":"B"}` + "\n"},
+		{"csv", "", "id,gender,type,year,place,value\n" +
+			"A,MALE,Birth,1850,\"Ohio, United States\",\n" +
+			"A,MALE,Occupation,,,\"farmer, miller\"\n"},
+	}
+	for _, test := range tests {
+		var buf bytes.Buffer
+		d, err := newDumper(&buf, test.format, test.field)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, person := range persons {
+			if err = d.dump(i, person); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err = d.flush(); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != test.out {
+			t.Errorf("dump(%s, %s) = %q; want %q", test.format, test.field, buf.String(), test.out)
+		}
+	}
+
+	var buf bytes.Buffer
+	d, _ := newDumper(&buf, "text", "")
+	for i, person := range persons {
+		d.dump(i, person)
+	}
+	d.flush()
+	fsPersons := &fs_data.FamilySearchPersons{}
+	if err := proto.UnmarshalText(buf.String(), fsPersons); err != nil || !proto.Equal(fsPersons.Persons[0], persons[0]) {
+		t.Errorf("dump(text) = %s err %v; want a FamilySearchPersons message", buf.String(), err)
+	}
+
+	if _, err := newDumper(&buf, "xml", ""); err == nil {
+		t.Errorf("newDumper(xml) returned no error")
+	}
+}
+
+func TestDumpRecords(t *testing.T) {
+	dir, err := ioutil.TempDir("", "proto_dump")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	writeFile := func(name string, ids ...string) {
+		fsPersons := &fs_data.FamilySearchPersons{}
+		for _, id := range ids {
+			fsPersons.Persons = append(fsPersons.Persons, &fs_data.FamilySearchPerson{Id: proto.String(id)})
+		}
+		b, err := proto.Marshal(fsPersons)
+		if err != nil {
+			t.Fatal(err)
+		}
+		file, err := os.Create(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer file.Close()
+		w := io.Writer(file)
+		if strings.HasSuffix(name, ".gz") {
+			zw := gzip.NewWriter(file)
+			defer zw.Close()
+			w = zw
+		}
+		if _, err = w.Write(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFile("a.protobuf", "A1", "A2", "A3")
+	writeFile("b.protobuf")
+	writeFile("c.protobuf.gz", "C1", "C2")
+
+	var tests = []struct {
+		path   string
+		sel    selection
+		read   int
+		dumped string
+	}{
+		{"a.protobuf", selection{limit: 10}, 3, "A1,A2,A3"},
+		{"a.protobuf", selection{limit: 2}, 2, "A1,A2"},
+		{"a.protobuf", selection{offset: 2, limit: 10}, 3, "A3"},
+		{"a.protobuf", selection{offset: 5, limit: 10}, 3, ""},
+		{"b.protobuf", selection{limit: 10}, 0, ""},
+		{"c.protobuf.gz", selection{}, 2, "C1,C2"},
+		{"", selection{}, 5, "A1,A2,A3,C1,C2"},
+		{"", selection{offset: 1, limit: 3}, 4, "A2,A3,C1"},
+		{"", selection{sample: 0.0001}, 5, ""},
+	}
+	for _, test := range tests {
+		source, err := fs_reader.Open(filepath.Join(dir, test.path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if test.sel.sample == 0 {
+			test.sel.sample = 1
+		}
+		test.sel.rand = rand.New(rand.NewSource(1))
+		var buf bytes.Buffer
+		d, _ := newDumper(&buf, "go", "i")
+		read, dumped, err := dumpRecords(source, d, test.sel)
+		d.flush()
+		source.Close()
+		ids := strings.Join(strings.Fields(buf.String()), ",")
+		if err != nil || read != test.read || ids != test.dumped || dumped != len(strings.Fields(buf.String())) {
+			t.Errorf("dumpRecords(%s, %+v) = %d read %d dumped %q err %v; want %d read %q",
+				test.path, test.sel, read, dumped, ids, err, test.read, test.dumped)
+		}
+	}
+
+	// a sample keeps about the right fraction of records
+	var ids []string
+	for i := 0; i < 1000; i++ {
+		ids = append(ids, fmt.Sprintf("P%d", i))
+	}
+	writeFile("d.protobuf", ids...)
+	source, err := fs_reader.Open(filepath.Join(dir, "d.protobuf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer source.Close()
+	d, _ := newDumper(ioutil.Discard, "go", "i")
+	read, dumped, err := dumpRecords(source, d, selection{sample: 0.25, rand: rand.New(rand.NewSource(1))})
+	if err != nil || read != 1000 || dumped < 200 || dumped > 300 {
+		t.Errorf("dumpRecords(sample 0.25) = %d read %d dumped err %v; want 1000 read about 250 dumped", read, dumped, err)
+	}
+}
+
+func TestQueryIndexedFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "proto_dump")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "a.protobuf")
+	file, err := os.Create(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := fs_data.NewStreamWriter(file, "test", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"A", "B"} {
+		if err = w.Write(&fs_data.FamilySearchPerson{Id: proto.String(id)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	// index the file as indexpersons does, with names relative to the file's directory
+	source, err := fs_reader.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := fs_index.NewBuilder(dir, 10)
+	defer b.Close()
+	for source.Next() {
+		name, _ := filepath.Rel(dir, source.Filename())
+		if err = b.Add(source.Person().GetId(), fs_reader.PersonIndexValue(name, source.Offset())); err != nil {
+			t.Fatal(err)
+		}
+	}
+	source.Close()
+	indexFilename := filepath.Join(dir, "persons.index")
+	if err = b.Write(indexFilename); err != nil {
+		t.Fatal(err)
+	}
+
+	index, err := openPersonIndex(indexFilename, filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer index.Close()
+	persons, err := queryPersons(filename, index, []string{"B", "C"}, false)
+	if err != nil || len(persons) != 1 || persons[0].GetId() != "B" {
+		t.Errorf("queryPersons(B, C) = %v, %v; want B", persons, err)
+	}
+}
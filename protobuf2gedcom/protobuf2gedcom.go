@@ -0,0 +1,432 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"github.com/rootsdev/fsbff/fs_data"
+	"github.com/rootsdev/fsbff/fs_date"
+	"github.com/rootsdev/fsbff/fs_gedcom"
+	"github.com/rootsdev/fsbff/fs_parallel"
+	"github.com/rootsdev/fsbff/fs_reader"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+Writes a population of FamilySearch persons, such as the person IDs written by filterbyevent or
+finddescendants, as a GEDCOM 5.5.1 file that desktop genealogy programs can import.
+
+FamilySearch persons don't have families, so FAM records are reconstructed from the persons' links:
+  1. Each couple of spouses in the population gets a family, with the facts of their spouse relationship
+  2. Each child in the population is added to the family of its parents; a child with more than two
+     parents is added to the family of each couple of its parents who are spouses, and to a one-parent
+     family for each remaining parent
+Links to persons outside the population are dropped.
+*/
+
+// family is a reconstructed FAM record
+type family struct {
+	xref     string
+	husband  string
+	wife     string
+	children []string
+	facts    []*fs_data.FSFact
+}
+
+// xrefAllocator hands out unique record identifiers
+type xrefAllocator struct {
+	used   map[string]bool
+	counts map[string]int
+}
+
+// isValidXref reports whether an ID can be used as a record identifier: GEDCOM limits them to
+// 20 characters and they can't contain @ or spaces
+func isValidXref(id string) bool {
+	return id != "" && len(id) <= 20 && !strings.ContainsAny(id, "@ \t\r\n")
+}
+
+// next returns the next unused identifier with the prefix
+func (a *xrefAllocator) next(prefix string) string {
+	for {
+		a.counts[prefix]++
+		xref := prefix + strconv.Itoa(a.counts[prefix])
+		if !a.used[xref] {
+			a.used[xref] = true
+			return xref
+		}
+	}
+}
+
+func getGender(fsPerson *fs_data.FamilySearchPerson) fs_data.FSGender {
+	if fsPerson == nil || fsPerson.Gender == nil {
+		return fs_data.FSGender_UNKNOWN
+	}
+	return *fsPerson.Gender
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// getCoupleFacts returns the facts of the spouse relationship between two persons
+func getCoupleFacts(fsPerson *fs_data.FamilySearchPerson, spouseID string) []*fs_data.FSFact {
+	for _, relationship := range fsPerson.GetRelationships() {
+		if relationship.GetType() == fs_data.FSRelationshipType_SPOUSE && relationship.GetRelatedId() == spouseID {
+			return relationship.Facts
+		}
+	}
+	return nil
+}
+
+// getFamilies reconstructs the families of the persons, in the order of ids
+func getFamilies(ids []string, persons map[string]*fs_data.FamilySearchPerson) []*family {
+	var families []*family
+	couples := make(map[[2]string]*family)
+	getFamily := func(id1, id2 string) *family {
+		if id2 != "" && id2 < id1 {
+			id1, id2 = id2, id1
+		}
+		key := [2]string{id1, id2}
+		if f := couples[key]; f != nil {
+			return f
+		}
+		f := &family{husband: id1, wife: id2}
+		gender1, gender2 := getGender(persons[id1]), getGender(persons[id2])
+		if (gender1 == fs_data.FSGender_FEMALE && gender2 != fs_data.FSGender_FEMALE) ||
+			(gender2 == fs_data.FSGender_MALE && gender1 != fs_data.FSGender_MALE) {
+			f.husband, f.wife = id2, id1
+		}
+		if id2 != "" {
+			f.facts = getCoupleFacts(persons[id1], id2)
+			if len(f.facts) == 0 {
+				f.facts = getCoupleFacts(persons[id2], id1)
+			}
+		}
+		couples[key] = f
+		families = append(families, f)
+		return f
+	}
+	isCouple := func(id1, id2 string) bool {
+		return containsString(persons[id1].GetSpouses(), id2) || containsString(persons[id2].GetSpouses(), id1)
+	}
+
+	for _, id := range ids {
+		for _, spouseID := range persons[id].GetSpouses() {
+			if persons[spouseID] != nil && spouseID != id {
+				getFamily(id, spouseID)
+			}
+		}
+	}
+
+	for _, id := range ids {
+		var parents []string
+		for _, parentID := range persons[id].GetParents() {
+			if persons[parentID] != nil && parentID != id && !containsString(parents, parentID) {
+				parents = append(parents, parentID)
+			}
+		}
+		paired := make([]bool, len(parents))
+		for i := range parents {
+			if paired[i] {
+				continue
+			}
+			paired[i] = true
+			partner := ""
+			for j := i + 1; j < len(parents); j++ {
+				if !paired[j] && (len(parents) == 2 || isCouple(parents[i], parents[j])) {
+					partner = parents[j]
+					paired[j] = true
+					break
+				}
+			}
+			f := getFamily(parents[i], partner)
+			f.children = append(f.children, id)
+		}
+	}
+	return families
+}
+
+// getNameValue returns the NAME value of a name, like "John /Smith/"
+func getNameValue(fsName *fs_data.FSName) string {
+	if fsName.Given == nil && fsName.Surname == nil {
+		return fsName.GetFullText()
+	}
+	return strings.TrimSpace(fsName.GetGiven() + " /" + fsName.GetSurname() + "/")
+}
+
+func writeName(w *fs_gedcom.Writer, fsName *fs_data.FSName) {
+	value := getNameValue(fsName)
+	if value == "" {
+		return
+	}
+	w.Line(1, "", "NAME", value)
+	if fsName.Given != nil {
+		w.Line(2, "", "GIVN", fsName.GetGiven())
+	}
+	if fsName.Surname != nil {
+		w.Line(2, "", "SURN", fsName.GetSurname())
+	}
+	if t := fs_gedcom.NameType(fsName.GetType()); t != "" {
+		w.Line(2, "", "TYPE", t)
+	}
+}
+
+func writeFact(w *fs_gedcom.Writer, fsFact *fs_data.FSFact, family bool) {
+	t := fsFact.GetType()
+	tag := fs_gedcom.FactTag(t, family)
+	date := fs_gedcom.FormatDate(fs_date.FromFact(fsFact))
+	place := fsFact.GetPlace()
+	value, note := fsFact.GetValue(), ""
+	switch {
+	case tag == "":
+		tag = "EVEN"
+	case !fs_gedcom.AttributeTags[tag]:
+		// events have the value Y to say that they happened without a date or place
+		value, note = "", value
+		if date == "" && place == "" && note == "" {
+			value = "Y"
+		}
+	}
+
+	w.Line(1, "", tag, value)
+	if tag == "EVEN" && t != "OTHER" {
+		w.Line(2, "", "TYPE", t)
+	}
+	if date != "" {
+		w.Line(2, "", "DATE", date)
+	}
+	if place != "" {
+		w.Line(2, "", "PLAC", place)
+	}
+	if note != "" {
+		w.Line(2, "", "NOTE", note)
+	}
+}
+
+// writeChange writes the modified time as a CHAN structure
+func writeChange(w *fs_gedcom.Writer, modified int64) {
+	t := time.Unix(0, modified*int64(time.Millisecond)).UTC()
+	w.Line(1, "", "CHAN", "")
+	w.Line(2, "", "DATE", strings.ToUpper(t.Format("2 Jan 2006")))
+	w.Line(3, "", "TIME", t.Format("15:04:05"))
+}
+
+// writeGedcom writes the persons with the ids, and their reconstructed families, as a GEDCOM file.
+// It returns the number of families.
+func writeGedcom(out io.Writer, ids []string, persons map[string]*fs_data.FamilySearchPerson) (int, error) {
+	xrefs := &xrefAllocator{used: make(map[string]bool), counts: make(map[string]int)}
+	personXrefs := make(map[string]string)
+	for _, id := range ids {
+		if isValidXref(id) {
+			personXrefs[id] = id
+			xrefs.used[id] = true
+		}
+	}
+	for _, id := range ids {
+		if personXrefs[id] == "" {
+			personXrefs[id] = xrefs.next("I")
+		}
+	}
+
+	families := getFamilies(ids, persons)
+	childOf := make(map[string][]string)
+	spouseIn := make(map[string][]string)
+	for _, f := range families {
+		f.xref = xrefs.next("F")
+		for _, id := range []string{f.husband, f.wife} {
+			if id != "" {
+				spouseIn[id] = append(spouseIn[id], f.xref)
+			}
+		}
+		for _, id := range f.children {
+			childOf[id] = append(childOf[id], f.xref)
+		}
+	}
+
+	var sources []*fs_data.FSSource
+	sourceXrefs := make(map[string]string)
+	for _, id := range ids {
+		for _, fsSource := range persons[id].GetSources() {
+			if sourceID := fsSource.GetSourceId(); sourceID != "" && sourceXrefs[sourceID] == "" {
+				sourceXrefs[sourceID] = xrefs.next("S")
+				sources = append(sources, fsSource)
+			}
+		}
+	}
+	submitterXref := xrefs.next("U")
+
+	w := fs_gedcom.NewWriter(out)
+	w.Line(0, "", "HEAD", "")
+	w.Line(1, "", "SOUR", "protobuf2gedcom")
+	w.Line(1, "", "GEDC", "")
+	w.Line(2, "", "VERS", "5.5.1")
+	w.Line(2, "", "FORM", "LINEAGE-LINKED")
+	w.Line(1, "", "CHAR", "UTF-8")
+	w.Line(1, "", "SUBM", "@"+submitterXref+"@")
+	w.Line(0, submitterXref, "SUBM", "")
+	w.Line(1, "", "NAME", "protobuf2gedcom")
+
+	for _, id := range ids {
+		fsPerson := persons[id]
+		w.Line(0, personXrefs[id], "INDI", "")
+		// preferred name first
+		for _, preferred := range []bool{true, false} {
+			for _, fsName := range fsPerson.Names {
+				if fsName.GetPreferred() == preferred {
+					writeName(w, fsName)
+				}
+			}
+		}
+		switch getGender(fsPerson) {
+		case fs_data.FSGender_MALE:
+			w.Line(1, "", "SEX", "M")
+		case fs_data.FSGender_FEMALE:
+			w.Line(1, "", "SEX", "F")
+		default:
+			w.Line(1, "", "SEX", "U")
+		}
+		for _, fsFact := range fsPerson.Facts {
+			writeFact(w, fsFact, false)
+		}
+		for _, xref := range childOf[id] {
+			w.Line(1, "", "FAMC", "@"+xref+"@")
+		}
+		for _, xref := range spouseIn[id] {
+			w.Line(1, "", "FAMS", "@"+xref+"@")
+		}
+		for _, fsSource := range fsPerson.Sources {
+			if xref := sourceXrefs[fsSource.GetSourceId()]; xref != "" {
+				w.Line(1, "", "SOUR", "@"+xref+"@")
+			}
+		}
+		w.Line(1, "", "REFN", id)
+		if fsPerson.Modified != nil {
+			writeChange(w, fsPerson.GetModified())
+		}
+	}
+
+	for _, f := range families {
+		w.Line(0, f.xref, "FAM", "")
+		if f.husband != "" {
+			w.Line(1, "", "HUSB", "@"+personXrefs[f.husband]+"@")
+		}
+		if f.wife != "" {
+			w.Line(1, "", "WIFE", "@"+personXrefs[f.wife]+"@")
+		}
+		for _, id := range f.children {
+			w.Line(1, "", "CHIL", "@"+personXrefs[id]+"@")
+		}
+		for _, fsFact := range f.facts {
+			writeFact(w, fsFact, true)
+		}
+	}
+
+	for _, fsSource := range sources {
+		w.Line(0, sourceXrefs[fsSource.GetSourceId()], "SOUR", "")
+		if title := fsSource.GetTitle(); title != "" {
+			w.Line(1, "", "TITL", title)
+		}
+		w.Line(1, "", "REFN", fsSource.GetSourceId())
+	}
+
+	w.Line(0, "", "TRLR", "")
+	return len(families), w.Flush()
+}
+
+// readIDs reads a file of person IDs, one per line, dropping blank lines and duplicates
+func readIDs(r io.Reader) ([]string, error) {
+	var ids []string
+	seen := make(map[string]bool)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		id := strings.TrimSpace(scanner.Text())
+		if id != "" && !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	return ids, scanner.Err()
+}
+
+func check(err error) {
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+var idsFilename = flag.String("i", "", "person IDs filename, one ID per line")
+var personsFilename = flag.String("p", "", "FS Persons proto filename or directory")
+var outFilename = flag.String("o", "", "output GEDCOM filename")
+var numWorkers = flag.Int("w", 1, "number of workers")
+
+func main() {
+	flag.Parse()
+
+	fmt.Printf("Number of CPUs=%d\n", fs_parallel.SetMaxProcs(*numWorkers))
+
+	fmt.Println("Reading person IDs")
+	idsFile, err := os.Open(*idsFilename)
+	check(err)
+	ids, err := readIDs(idsFile)
+	idsFile.Close()
+	check(err)
+	idSet := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		idSet[id] = true
+	}
+
+	fileNames, err := fs_reader.Filenames(*personsFilename)
+	check(err)
+
+	fmt.Print("Reading persons")
+	persons := make(map[string]*fs_data.FamilySearchPerson, len(ids))
+	err = fs_parallel.Run(context.Background(), fileNames,
+		fs_parallel.Options{Workers: *numWorkers, Progress: fs_parallel.Dots(1000)},
+		func(ctx context.Context, fileName string) (interface{}, error) {
+			fsPersons, err := fs_reader.ReadFile(fileName)
+			if err != nil {
+				return nil, err
+			}
+			var selected []*fs_data.FamilySearchPerson
+			for _, fsPerson := range fsPersons.GetPersons() {
+				if idSet[fsPerson.GetId()] {
+					selected = append(selected, fsPerson)
+				}
+			}
+			return selected, nil
+		},
+		func(fileName string, result interface{}) error {
+			for _, fsPerson := range result.([]*fs_data.FamilySearchPerson) {
+				persons[fsPerson.GetId()] = fsPerson
+			}
+			return nil
+		})
+	check(err)
+	fmt.Println()
+
+	found := make([]string, 0, len(persons))
+	for _, id := range ids {
+		if persons[id] != nil {
+			found = append(found, id)
+		}
+	}
+
+	out, err := os.Create(*outFilename)
+	check(err)
+	defer out.Close()
+	familyCount, err := writeGedcom(out, found, persons)
+	check(err)
+
+	fmt.Printf("Total persons=%d families=%d missing persons=%d\n", len(found), familyCount, len(ids)-len(found))
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"code.google.com/p/goprotobuf/proto"
+	"github.com/rootsdev/fsbff/fs_data"
+	"strings"
+	"testing"
+)
+
+const wantGedcom = `0 HEAD
+1 SOUR protobuf2gedcom
+1 GEDC
+2 VERS 5.5.1
+2 FORM LINEAGE-LINKED
+1 CHAR UTF-8
+1 SUBM @U1@
+0 @U1@ SUBM
+1 NAME protobuf2gedcom
+0 @KWCB-001@ INDI
+1 NAME John /Smith/
+2 GIVN John
+2 SURN Smith
+2 TYPE birth
+1 NAME Jack /Smith/
+2 GIVN Jack
+2 SURN Smith
+2 TYPE aka
+1 SEX M
+1 BIRT
+2 DATE ABT 1850
+2 PLAC Springfield, Ohio
+1 OCCU Farmer
+1 RESI Y
+1 EVEN
+2 TYPE Custom
+2 DATE 1862
+1 FAMS @F1@
+1 SOUR @S1@
+1 REFN KWCB-001
+1 CHAN
+2 DATE 5 MAR 2010
+3 TIME 12:30:15
+0 @A2@ INDI
+1 NAME Mary /Jones/
+2 GIVN Mary
+2 SURN Jones
+1 SEX F
+1 DEAT
+2 DATE BET 1900 AND 1910
+1 FAMS @F1@
+1 FAMS @F2@
+1 REFN A2
+0 @C3@ INDI
+1 NAME Tom
+1 SEX U
+1 FAMC @F1@
+1 REFN C3
+0 @I1@ INDI
+1 SEX M
+1 FAMC @F2@
+1 REFN bad id@x
+0 @F1@ FAM
+1 HUSB @KWCB-001@
+1 WIFE @A2@
+1 CHIL @C3@
+1 MARR
+2 DATE 12 JUN 1875
+0 @F2@ FAM
+1 WIFE @A2@
+1 CHIL @I1@
+0 @S1@ SOUR
+1 TITL Ohio Births
+1 REFN S-1
+0 TRLR
+`
+
+func testPersons() ([]string, map[string]*fs_data.FamilySearchPerson) {
+	male, female := fs_data.FSGender_MALE, fs_data.FSGender_FEMALE
+	about, between := fs_data.FSDateModifier_ABOUT, fs_data.FSDateModifier_BETWEEN
+	spouse := fs_data.FSRelationshipType_SPOUSE
+	fsPersons := []*fs_data.FamilySearchPerson{
+		{
+			Id:     proto.String("KWCB-001"),
+			Gender: &male,
+			Names: []*fs_data.FSName{
+				{Given: proto.String("Jack"), Surname: proto.String("Smith"), Type: proto.String("AlsoKnownAs")},
+				{Given: proto.String("John"), Surname: proto.String("Smith"), Type: proto.String("BirthName"),
+					Preferred: proto.Bool(true)},
+			},
+			Facts: []*fs_data.FSFact{
+				{Type: proto.String("Birth"), Year: proto.Int32(1850), Modifier: &about, Place: proto.String("Springfield, Ohio")},
+				{Type: proto.String("Occupation"), Value: proto.String("Farmer")},
+				{Type: proto.String("Residence")},
+				{Type: proto.String("Custom"), Year: proto.Int32(1862)},
+			},
+			Spouses:  []string{"A2"},
+			Children: []string{"C3"},
+			Relationships: []*fs_data.FSRelationship{
+				{Type: &spouse, RelatedId: proto.String("A2"), Facts: []*fs_data.FSFact{
+					{Type: proto.String("Marriage"), Year: proto.Int32(1875), Month: proto.Int32(6), Day: proto.Int32(12)},
+				}},
+			},
+			Sources:  []*fs_data.FSSource{{SourceId: proto.String("S-1"), Title: proto.String("Ohio Births")}},
+			Modified: proto.Int64(1267792215000),
+		},
+		{
+			Id:     proto.String("A2"),
+			Gender: &female,
+			Names:  []*fs_data.FSName{{Given: proto.String("Mary"), Surname: proto.String("Jones")}},
+			Facts: []*fs_data.FSFact{
+				{Type: proto.String("Death"), Year: proto.Int32(1900), Modifier: &between, EndYear: proto.Int32(1910)},
+			},
+			Spouses:  []string{"KWCB-001", "OUTSIDE"},
+			Children: []string{"C3", "bad id@x"},
+		},
+		{
+			Id:      proto.String("C3"),
+			Names:   []*fs_data.FSName{{FullText: proto.String("Tom")}},
+			Parents: []string{"KWCB-001", "A2", "OUTSIDE"},
+		},
+		{
+			Id:      proto.String("bad id@x"),
+			Gender:  &male,
+			Parents: []string{"A2"},
+		},
+	}
+	var ids []string
+	persons := make(map[string]*fs_data.FamilySearchPerson)
+	for _, fsPerson := range fsPersons {
+		ids = append(ids, fsPerson.GetId())
+		persons[fsPerson.GetId()] = fsPerson
+	}
+	return ids, persons
+}
+
+func TestWriteGedcom(t *testing.T) {
+	ids, persons := testPersons()
+	var buf bytes.Buffer
+	familyCount, err := writeGedcom(&buf, ids, persons)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if familyCount != 2 {
+		t.Errorf("writeGedcom families = %d; want 2", familyCount)
+	}
+	got, want := strings.Split(buf.String(), "\n"), strings.Split(wantGedcom, "\n")
+	for i := 0; i < len(got) || i < len(want); i++ {
+		var g, w string
+		if i < len(got) {
+			g = got[i]
+		}
+		if i < len(want) {
+			w = want[i]
+		}
+		if g != w {
+			t.Fatalf("writeGedcom line %d = %q; want %q", i+1, g, w)
+		}
+	}
+}
+
+func TestGetFamilies(t *testing.T) {
+	male, female := fs_data.FSGender_MALE, fs_data.FSGender_FEMALE
+	persons := map[string]*fs_data.FamilySearchPerson{
+		"f1": {Id: proto.String("f1"), Gender: &male, Spouses: []string{"m1"}},
+		"m1": {Id: proto.String("m1"), Gender: &female},
+		"f2": {Id: proto.String("f2"), Gender: &male, Spouses: []string{"m2"}},
+		"m2": {Id: proto.String("m2"), Gender: &female},
+		"c":  {Id: proto.String("c"), Parents: []string{"f1", "f2", "m1", "m2", "x"}},
+	}
+	families := getFamilies([]string{"f1", "m1", "f2", "m2", "c"}, persons)
+
+	var actual []string
+	for _, f := range families {
+		actual = append(actual, f.husband+"+"+f.wife+":"+strings.Join(f.children, ","))
+	}
+	if want := "f1+m1:c f2+m2:c"; strings.Join(actual, " ") != want {
+		t.Errorf("getFamilies = %q; want %q", strings.Join(actual, " "), want)
+	}
+}